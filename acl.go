@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// aclSummary counts ACL tokens, policies and roles and how they relate to
+// each other.
+type aclSummary struct {
+	top int
+
+	tokens, policies, roles typeStats
+
+	// policyNames maps policy IDs to names.
+	policyNames map[string]string
+	// policyTokens counts the tokens linked to each policy ID.
+	policyTokens map[string]int
+	// rolePolicies counts the policies linked to each role name.
+	rolePolicies map[string]int
+	// policySizes is the size of each policy's record by ID.
+	policySizes map[string]int
+}
+
+func newACLSummary(c *reportConfig) report {
+	return &aclSummary{
+		top:          c.Top,
+		tokens:       typeStats{Name: "Tokens"},
+		policies:     typeStats{Name: "Policies"},
+		roles:        typeStats{Name: "Roles"},
+		policyNames:  make(map[string]string),
+		policyTokens: make(map[string]int),
+		rolePolicies: make(map[string]int),
+		policySizes:  make(map[string]int),
+	}
+}
+
+// links returns the IDs of the policy or role links in a decoded list,
+// recording their names along the way.
+func links(v interface{}, names map[string]string) []string {
+	ls, _ := v.([]interface{})
+	ids := make([]string, 0, len(ls))
+	for _, l := range ls {
+		id := stringField(l, "ID")
+		if name := stringField(l, "Name"); name != "" && names != nil {
+			names[id] = name
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (a *aclSummary) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "ACLTokenSet":
+		a.tokens.Sum += size
+		a.tokens.Count++
+		for _, id := range links(field(val, "Policies"), a.policyNames) {
+			a.policyTokens[id]++
+		}
+
+	case "ACLPolicySet":
+		a.policies.Sum += size
+		a.policies.Count++
+		id := stringField(val, "ID")
+		a.policyNames[id] = stringField(val, "Name")
+		a.policySizes[id] = size
+
+	case "ACLRoleSetRequestType":
+		a.roles.Sum += size
+		a.roles.Count++
+		a.rolePolicies[stringField(val, "Name")] = len(links(field(val, "Policies"), a.policyNames))
+	}
+}
+
+// policyName returns the name of the policy with the given ID or the ID if
+// the name isn't known.
+func (a *aclSummary) policyName(id string) string {
+	if name := a.policyNames[id]; name != "" {
+		return name
+	}
+	return id
+}
+
+func (a *aclSummary) print(w io.Writer) {
+	printStats(w, "ACL Object", statSlice{a.tokens, a.policies, a.roles}, a.tokens.Sum+a.policies.Sum+a.roles.Sum)
+
+	fmt.Fprintln(w)
+	printCounts(w, "Policy", "Tokens", a.policyTokens, a.policyName, a.top)
+
+	fmt.Fprintln(w)
+	printCounts(w, "Role", "Policies", a.rolePolicies, nil, a.top)
+
+	fmt.Fprintln(w)
+	sizes := make(statMap)
+	for id, size := range a.policySizes {
+		sizes[a.policyName(id)] = typeStats{Name: a.policyName(id), Sum: size, Count: a.policyTokens[id]}
+	}
+	fmt.Fprintln(w, "Largest policies (count is the number of tokens using them)")
+	printTopStats(w, "Policy", sizes.slice(), a.policies.Sum, a.top)
+}
+
+// printCounts writes the top largest counts as a two column table, naming the
+// keys with name if it's not nil.
+func printCounts(w io.Writer, heading, countHeading string, counts map[string]int, name func(string) string, top int) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if top > 0 && len(keys) > top {
+		keys = keys[:top]
+	}
+
+	fmt.Fprintf(w, "% 22s % 8s\n", heading, countHeading)
+	fmt.Fprintf(w, "%s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 8))
+	for _, k := range keys {
+		label := k
+		if name != nil {
+			label = name(k)
+		}
+		fmt.Fprintf(w, "% 22s % 8d\n", label, counts[k])
+	}
+}
+
+// legacyACLs finds ACL state still in the legacy format that must be migrated
+// before upgrading to Consul versions that drop support for it.
+type legacyACLs struct {
+	top int
+
+	// deprecated counts records of the pre-1.4 ACL type.
+	deprecated typeStats
+	// noAccessor are tokens that were never given an AccessorID.
+	noAccessor typeStats
+	// legacyType are tokens with a legacy client/management type or embedded
+	// rules.
+	legacyType typeStats
+
+	examples []string
+	total    int
+}
+
+func newLegacyACLs(c *reportConfig) report {
+	return &legacyACLs{
+		top:        c.Top,
+		deprecated: typeStats{Name: "Legacy ACL records"},
+		noAccessor: typeStats{Name: "No AccessorID"},
+		legacyType: typeStats{Name: "Legacy type/rules"},
+	}
+}
+
+func (l *legacyACLs) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "ACL (Deprecated)":
+		l.deprecated.Sum += size
+		l.deprecated.Count++
+		l.total += size
+
+	case "ACLTokenSet":
+		accessor := stringField(val, "AccessorID")
+		legacy := stringField(val, "Type") != "" || stringField(val, "Rules") != ""
+		if accessor == "" {
+			l.noAccessor.Sum += size
+			l.noAccessor.Count++
+		}
+		if legacy {
+			l.legacyType.Sum += size
+			l.legacyType.Count++
+		}
+		if accessor == "" || legacy {
+			l.total += size
+		}
+		if (accessor == "" || legacy) && (l.top == 0 || len(l.examples) < l.top) {
+			// Never print the SecretID, it's the credential.
+			name := accessor
+			if name == "" {
+				name = "(no accessor)"
+			}
+			if desc := stringField(val, "Description"); desc != "" {
+				name += " " + fmt.Sprintf("%q", desc)
+			}
+			if t := stringField(val, "Type"); t != "" {
+				name += " type=" + t
+			}
+			l.examples = append(l.examples, name)
+		}
+	}
+}
+
+func (l *legacyACLs) print(w io.Writer) {
+	printStats(w, "Legacy ACL State", statSlice{l.deprecated, l.noAccessor, l.legacyType}, l.total)
+
+	if l.deprecated.Count+l.noAccessor.Count+l.legacyType.Count == 0 {
+		fmt.Fprintln(w, "\nNo legacy ACL state remains.")
+		return
+	}
+	if len(l.examples) > 0 {
+		fmt.Fprintln(w, "\nTokens to migrate:")
+		for _, e := range l.examples {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+}
+
+// policyRules reports the size of each ACL policy's Rules, flagging those over
+// a threshold. Machine generated policies can grow large enough to slow down
+// ACL resolution.
+type policyRules struct {
+	top int
+	max int
+
+	policies []policyRule
+	total    int
+}
+
+type policyRule struct {
+	Name string
+	Size int
+}
+
+func newPolicyRules(c *reportConfig) report {
+	return &policyRules{top: c.Top, max: int(c.MaxPolicyRules)}
+}
+
+func (p *policyRules) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "ACLPolicySet" {
+		return
+	}
+	rules := len(stringField(val, "Rules"))
+	p.policies = append(p.policies, policyRule{Name: stringField(val, "Name"), Size: rules})
+	p.total += rules
+}
+
+func (p *policyRules) print(w io.Writer) {
+	sort.Slice(p.policies, func(i, j int) bool { return p.policies[i].Size > p.policies[j].Size })
+	over := 0
+	for _, r := range p.policies {
+		if r.Size > p.max {
+			over++
+		}
+	}
+
+	fmt.Fprintf(w, "ACL Policy Rules: %s in %d policies, %d larger than %s\n\n",
+		ByteSize(uint64(p.total)), len(p.policies), over, ByteSize(uint64(p.max)))
+	fmt.Fprintf(w, "% 12s %s\n", "Rules Size", "Policy")
+	fmt.Fprintf(w, "%s %s\n", strings.Repeat("-", 12), strings.Repeat("-", 22))
+	for i, r := range p.policies {
+		if p.top > 0 && i >= p.top {
+			break
+		}
+		marker := ""
+		if r.Size > p.max {
+			marker = " (!)"
+		}
+		fmt.Fprintf(w, "% 12s %s%s\n", ByteSize(uint64(r.Size)), r.Name, marker)
+	}
+}
+
+// tokenAuthMethods groups ACL tokens by the auth method that created them so
+// it's clear which login integrations create the most tokens.
+type tokenAuthMethods struct {
+	top     int
+	methods statMap
+	total   int
+}
+
+func newTokenAuthMethods(c *reportConfig) report {
+	return &tokenAuthMethods{top: c.Top, methods: make(statMap)}
+}
+
+func (t *tokenAuthMethods) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "ACLTokenSet" {
+		return
+	}
+	method := stringField(val, "AuthMethod")
+	if method == "" {
+		method = "(static)"
+	}
+	t.methods.add(method, size)
+	t.total += size
+}
+
+func (t *tokenAuthMethods) print(w io.Writer) {
+	printTopStats(w, "Auth Method", t.methods.slice(), t.total, t.top)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// alertTimeout limits how long posting to each webhook can take.
+const alertTimeout = 10 * time.Second
+
+// alertConfig is where to send alerts when a snapshot exceeds its limits, so
+// they reach people without glue scripts around the tool.
+type alertConfig struct {
+	webhooks stringsFlag
+	slack    stringsFlag
+}
+
+// alertFlags registers -webhook and -slack-webhook.
+func alertFlags(fs *flag.FlagSet) *alertConfig {
+	c := &alertConfig{}
+	fs.Var(&c.webhooks, "webhook", "URL to POST the check report to as JSON when any limit is exceeded (may be repeated)")
+	fs.Var(&c.slack, "slack-webhook", "Slack incoming webhook URL to post a summary to when any limit is exceeded (may be repeated)")
+	return c
+}
+
+// alertPayload is the JSON posted to -webhook URLs: the check report with a
+// readable summary added.
+type alertPayload struct {
+	*checkReport
+	Summary string `json:"summary"`
+}
+
+// send posts report to the webhooks if it has any violations, warning rather
+// than failing if it can't so the exit code still reflects the check.
+func (c *alertConfig) send(report *checkReport) {
+	if report.OK {
+		return
+	}
+	summary := alertSummary(report)
+	for _, u := range c.webhooks {
+		if err := postJSON(u, alertPayload{report, summary}); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send alert to webhook: %s\n", err)
+		}
+	}
+	for _, u := range c.slack {
+		if err := postJSON(u, map[string]string{"text": summary}); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send alert to Slack: %s\n", err)
+		}
+	}
+}
+
+// alertSummary describes every limit report's snapshot exceeded.
+func alertSummary(report *checkReport) string {
+	lines := []string{fmt.Sprintf("Snapshot %s (%s, %d records) exceeded its limits:", report.Path, ByteSize(uint64(report.Size)), report.Count)}
+	for _, v := range report.Violations {
+		lines = append(lines, "• "+violationText(v))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// postJSON posts v to u as JSON. The URL isn't included in errors since
+// webhook URLs are secrets.
+func postJSON(u string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: alertTimeout}
+	resp, err := client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// printAlerts writes a line for each limit the snapshot exceeded.
+func printAlerts(w io.Writer, report *checkReport) {
+	for _, v := range report.Violations {
+		fmt.Fprintf(w, "ALERT %s: %s\n", report.Path, violationText(v))
+	}
+}
+
+// violationText describes a violation, e.g. "max-kv-prefix-size vault/ is
+// 2.1GB, over the limit of 2GB".
+func violationText(v checkViolation) string {
+	subject := ""
+	if v.Subject != "" {
+		subject = " " + v.Subject
+	}
+	var limit, actual string
+	switch v.Check {
+	case "max-record-count":
+		limit, actual = fmt.Sprint(v.Limit), fmt.Sprint(v.Actual)
+	case "max-kv-prefix-growth":
+		limit, actual = fmt.Sprintf("%d%%", v.Limit), fmt.Sprintf("%d%%", v.Actual)
+	default:
+		limit, actual = ByteSize(v.Limit), ByteSize(v.Actual)
+	}
+	return fmt.Sprintf("%s%s is %s, over the limit of %s", v.Check, subject, actual, limit)
+}
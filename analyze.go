@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// analyzeSnapshot returns the type and KV prefix breakdown of the snapshot
+// read from r, for serve, or ctx's error wrapped in a snapshotError if it's
+// done first.
+func analyzeSnapshot(ctx context.Context, r io.Reader, kvDepth int) (*snapshot.Report, error) {
+	stats := make(map[int]typeStats)
+	kv := newKVStats(kvDepth, nil)
+	total, err := scanSnapshotContext(ctx, r, func(msgType int, raw []byte, size int) {
+		s := stats[msgType]
+		s.Name = typeName(msgType)
+		s.Sum += size
+		s.Count++
+		stats[msgType] = s
+
+		if typeName(msgType) == "KVS" {
+			key := skippedKey
+			if raw != nil {
+				key, _ = snapshot.MapString(raw, "Key")
+			}
+			kv.add(key, size)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newReport(stats, kv, total), nil
+}
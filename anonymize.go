@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// anonymizer replaces node names, service names and KV key segments with
+// pseudonyms derived from an HMAC of the original, so the same name always
+// gets the same pseudonym for a given salt. Sharing the salt between runs
+// keeps diffs of anonymized snapshots meaningful.
+type anonymizer struct {
+	salt  []byte
+	names map[string]string
+}
+
+func newAnonymizer(salt string) *anonymizer {
+	return &anonymizer{salt: []byte(salt), names: make(map[string]string)}
+}
+
+// name returns the pseudonym for name. It's the same length as name so sizes
+// are preserved, except that short names are padded to 8 characters to keep
+// collisions unlikely. Wildcards are left as they are.
+func (a *anonymizer) name(name string) string {
+	if name == "" || name == "*" {
+		return name
+	}
+	if p, ok := a.names[name]; ok {
+		return p
+	}
+	mac := hmac.New(sha256.New, a.salt)
+	mac.Write([]byte(name))
+	sum := hex.EncodeToString(mac.Sum(nil))
+	n := len(name)
+	if n < 8 {
+		n = 8
+	}
+	for len(sum) < n {
+		sum += sum
+	}
+	a.names[name] = sum[:n]
+	return sum[:n]
+}
+
+// key anonymizes each segment of a KV key, keeping the slashes so the prefix
+// breakdown still has the same shape.
+func (a *anonymizer) key(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = a.name(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// checkID anonymizes a check ID. The checks registered with a service are
+// named service:<service ID>, with :<n> added when there are several, so the
+// service ID in them gets the same pseudonym it does elsewhere. The checks
+// Consul adds itself keep their names.
+func (a *anonymizer) checkID(id string) string {
+	switch {
+	case id == "serfHealth" || id == "_node_maintenance":
+		return id
+	case strings.HasPrefix(id, "_service_maintenance:"):
+		return "_service_maintenance:" + a.name(strings.TrimPrefix(id, "_service_maintenance:"))
+	case strings.HasPrefix(id, "service:"):
+		svc, n := strings.TrimPrefix(id, "service:"), ""
+		if i := strings.LastIndex(svc, ":"); i >= 0 {
+			if _, err := strconv.Atoi(svc[i+1:]); err == nil {
+				svc, n = svc[:i], svc[i:]
+			}
+		}
+		return "service:" + a.name(svc) + n
+	}
+	return a.name(id)
+}
+
+// configEntryNames are the fields of config entries, at any depth, that hold
+// the name of a service, such as the Sources of service-intentions or the
+// Destination of a service-router route.
+var configEntryNames = map[string]bool{
+	"Name":                   true,
+	"Service":                true,
+	"ServiceName":            true,
+	"DestinationName":        true,
+	"DestinationServiceName": true,
+}
+
+// globalConfigEntries are the kinds of config entry whose Name isn't a
+// service but always "global".
+var globalConfigEntries = map[string]bool{
+	"proxy-defaults": true,
+	"mesh":           true,
+}
+
+// anonymizeConfigEntry anonymizes the service names in a config entry
+// record, returning it re-encoded as Consul's MarshalBinary would.
+func (a *anonymizer) anonymizeConfigEntry(val interface{}) (interface{}, bool) {
+	var raw []byte
+	switch v := val.(type) {
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		// Already decoded, e.g. written by encode from hand edited JSONL.
+		entry := field(val, "Entry")
+		return val, entry != nil && a.configEntryNames(entry, !globalConfigEntries[stringField(entry, "Kind")])
+	}
+	kind, req, err := snapshot.DecodeConfigEntryRequest(raw)
+	if err != nil {
+		return val, false
+	}
+	if !a.configEntryNames(field(req, "Entry"), !globalConfigEntries[kind]) {
+		return val, false
+	}
+	b, err := snapshot.EncodeConfigEntryRequest(kind, req)
+	if err != nil {
+		return val, false
+	}
+	if _, ok := val.(string); ok {
+		return string(b), true
+	}
+	return b, true
+}
+
+// configEntryNames anonymizes the names in a decoded config entry, including
+// its own Name if name is set, returning true if any were changed.
+func (a *anonymizer) configEntryNames(v interface{}, name bool) bool {
+	changed := false
+	var walk func(v interface{}, top bool)
+	walk = func(v interface{}, top bool) {
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			for k, e := range v {
+				if s, ok := e.(string); ok {
+					if configEntryNames[fmt.Sprint(k)] && (!top || name || k != "Name") && a.name(s) != s {
+						v[k] = a.name(s)
+						changed = true
+					}
+					continue
+				}
+				walk(e, false)
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e, false)
+			}
+		}
+	}
+	walk(v, true)
+	return changed
+}
+
+// anonymize replaces the names in a record, returning the record and true if
+// any were changed. Config entries are re-encoded so they're returned as a new
+// value, other records are changed in place.
+func (a *anonymizer) anonymize(msgType int, val interface{}) (interface{}, bool) {
+	changed := false
+	set := func(v interface{}, fn func(string) string, path ...string) {
+		if s := stringField(v, path...); s != "" {
+			changed = setField(v, fn(s), path...) || changed
+		}
+	}
+
+	switch typeName(msgType) {
+	case "KVS", "Tombstone":
+		set(val, a.key, "Key")
+	case "Register":
+		set(val, a.name, "Node")
+		if svc := field(val, "Service"); svc != nil {
+			set(svc, a.name, "ID")
+			set(svc, a.name, "Service")
+			set(svc, a.name, "Proxy", "DestinationServiceName")
+			set(svc, a.name, "Proxy", "DestinationServiceID")
+			upstreams, _ := field(svc, "Proxy", "Upstreams").([]interface{})
+			for _, u := range upstreams {
+				set(u, a.name, "DestinationName")
+			}
+		}
+		if check := field(val, "Check"); check != nil {
+			set(check, a.name, "Node")
+			set(check, a.checkID, "CheckID")
+			set(check, a.name, "Name")
+			set(check, a.name, "ServiceID")
+			set(check, a.name, "ServiceName")
+		}
+	case "Session":
+		set(val, a.name, "Node")
+	case "CoordinateBatchUpdate":
+		coords, _ := val.([]interface{})
+		for _, coord := range coords {
+			set(coord, a.name, "Node")
+		}
+	case "Intention":
+		set(val, a.name, "SourceName")
+		set(val, a.name, "DestinationName")
+	case "PreparedQuery":
+		set(val, a.name, "Service", "Service")
+	case "ConfigEntryRequestType":
+		return a.anonymizeConfigEntry(val)
+	}
+	return val, changed
+}
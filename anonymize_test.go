@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+func TestAnonymizeCheckID(t *testing.T) {
+	a := newAnonymizer("salt")
+	for id, want := range map[string]string{
+		"serfHealth":                 "serfHealth",
+		"service:web-1":              "service:" + a.name("web-1"),
+		"service:web-1:2":            "service:" + a.name("web-1") + ":2",
+		"_service_maintenance:web-1": "_service_maintenance:" + a.name("web-1"),
+		"custom-check":               a.name("custom-check"),
+	} {
+		if got := a.checkID(id); got != want {
+			t.Errorf("%s: got %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestAnonymizeConfigEntry(t *testing.T) {
+	req := &testConfigEntryRequest{Op: "upsert", Entry: &testServiceIntentionsConfigEntry{
+		Kind:    "service-intentions",
+		Name:    "api",
+		Sources: []*testSourceIntention{{Name: "web", Action: "allow"}, {Name: "*", Action: "deny"}},
+	}}
+	raw, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAnonymizer("salt")
+	val, changed := a.anonymize(snapshot.ConfigEntryType, raw)
+	if !changed {
+		t.Fatal("config entry wasn't changed")
+	}
+	is := recordIntentions(snapshot.ConfigEntryType, val)
+	if len(is) != 2 {
+		t.Fatalf("got %d intentions, want 2", len(is))
+	}
+	if is[0].Source != a.name("web") || is[0].Destination != a.name("api") {
+		t.Errorf("got %s -> %s, want the pseudonyms of web -> api", is[0].Source, is[0].Destination)
+	}
+	if is[1].Source != "*" {
+		t.Errorf("got wildcard source %q, want it kept", is[1].Source)
+	}
+
+	// proxy-defaults are always named global.
+	req.Entry = &testServiceConfigEntry{Kind: "proxy-defaults", Name: "global"}
+	if raw, err = req.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed := a.anonymize(snapshot.ConfigEntryType, raw); changed {
+		t.Error("proxy-defaults global was changed")
+	}
+}
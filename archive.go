@@ -0,0 +1,179 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// snapshotOutput is where a command that writes a new snapshot writes it.
+type snapshotOutput struct {
+	path    string
+	archive bool
+}
+
+// outputFlags registers the flags choosing where and how a command writes the
+// snapshot it produces.
+func outputFlags(fs *flag.FlagSet) *snapshotOutput {
+	o := &snapshotOutput{}
+	fs.StringVar(&o.path, "o", "-", "file to write the new snapshot to, - for stdout")
+	fs.BoolVar(&o.archive, "archive", false, "write a backup archive that 'consul snapshot restore' accepts rather than a bare state.bin")
+	return o
+}
+
+// write runs fn from r, which was read from the file in, to the output. If an
+// archive was asked for the state fn writes is packaged up along with the
+// metadata from in, if it was an archive too.
+func (o *snapshotOutput) write(in string, r io.Reader, fn func(io.Reader, io.Writer) error) {
+	if !o.archive {
+		convertFile(in, r, o.path, fn)
+		return
+	}
+
+	meta, err := readArchiveMeta(in)
+	if err != nil {
+		fatal(err)
+	}
+	convertFile(in, r, o.path, func(r io.Reader, w io.Writer) error {
+		return writeArchive(w, meta, func(state io.Writer) error {
+			return fn(r, state)
+		})
+	})
+}
+
+// readArchiveMeta returns the raft snapshot metadata from the backup archive
+// at path, or nil if path isn't an archive. The metadata is kept as a map so
+// fields this tool doesn't know about are written back unchanged.
+func readArchiveMeta(path string) (map[string]interface{}, error) {
+	if path == "-" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		// Not an archive.
+		return nil, nil
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if hdr.Name != "meta.json" {
+			continue
+		}
+		var meta map[string]interface{}
+		if err := json.NewDecoder(tr).Decode(&meta); err != nil {
+			return nil, fmt.Errorf("%s: invalid meta.json: %s", path, err)
+		}
+		return meta, nil
+	}
+}
+
+// writeArchive writes a backup archive in the format `consul snapshot save`
+// does: a gzipped tarball holding meta.json, state.bin and SHA256SUMS. The
+// state is written by fn and buffered in a temporary file since its size and
+// CRC have to go in meta.json, which comes first. meta is updated with them,
+// or made up from the snapshot header if it's nil.
+func writeArchive(w io.Writer, meta map[string]interface{}, fn func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile("", "state.bin")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	crc := crc64.New(crc64.MakeTable(crc64.ECMA))
+	stateSum := sha256.New()
+	buf := bufio.NewWriter(tmp)
+	if err := fn(io.MultiWriter(buf, crc, stateSum)); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if meta == nil {
+		r, err := snapshot.Open(tmp)
+		if err != nil {
+			return fmt.Errorf("failed to read header: %s", err)
+		}
+		header := r.Header()
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		meta = map[string]interface{}{
+			"Version":            1,
+			"ID":                 fmt.Sprintf("1-%d-%d", header.LastIndex, time.Now().UnixNano()/int64(time.Millisecond)),
+			"Index":              header.LastIndex,
+			"Term":               1,
+			"Peers":              nil,
+			"Configuration":      map[string]interface{}{"Servers": nil},
+			"ConfigurationIndex": 0,
+		}
+	}
+	meta["Size"] = size
+	meta["CRC"] = crc.Sum(nil)
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	metaSum := sha256.Sum256(metaJSON)
+
+	var sums bytes.Buffer
+	fmt.Fprintf(&sums, "%x  meta.json\n", metaSum)
+	fmt.Fprintf(&sums, "%x  state.bin\n", stateSum.Sum(nil))
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range []struct {
+		name string
+		size int64
+		r    io.Reader
+	}{
+		{"meta.json", int64(len(metaJSON)), bytes.NewReader(metaJSON)},
+		{"state.bin", size, tmp},
+		{"SHA256SUMS", int64(sums.Len()), &sums},
+	} {
+		hdr := &tar.Header{Name: file.name, Mode: 0600, Size: file.size, ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, file.r); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestArchive writes an archive of state to a file, returning its path.
+func writeTestArchive(t *testing.T, meta map[string]interface{}, state []byte) string {
+	path := filepath.Join(t.TempDir(), "backup.snap")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = writeArchive(f, meta, func(w io.Writer) error {
+		_, err := w.Write(state)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readTestArchive returns the files in the archive at path by name.
+func readTestArchive(t *testing.T, path string) map[string][]byte {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if files[hdr.Name], err = ioutil.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if fmt.Sprint(names) != "[meta.json state.bin SHA256SUMS]" {
+		t.Errorf("got files %v, want meta.json, state.bin and SHA256SUMS in that order", names)
+	}
+	return files
+}
+
+func TestWriteArchive(t *testing.T) {
+	state := testSnapshot(t, 500, testKV("app/config", "v", 5, 10), testTombstone("app/gone", 20))
+	path := writeTestArchive(t, nil, state)
+
+	r, err := openSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, state) {
+		t.Fatalf("read back %d bytes of state, want the %d written", len(got), len(state))
+	}
+
+	files := readTestArchive(t, path)
+	if !bytes.Equal(files["state.bin"], state) {
+		t.Errorf("state.bin doesn't hold the state written")
+	}
+	want := fmt.Sprintf("%x  meta.json\n%x  state.bin\n", sha256.Sum256(files["meta.json"]), sha256.Sum256(state))
+	if string(files["SHA256SUMS"]) != want {
+		t.Errorf("got SHA256SUMS\n%s\nwant\n%s", files["SHA256SUMS"], want)
+	}
+
+	var meta struct {
+		Index uint64
+		Size  int
+		CRC   []byte
+	}
+	if err := json.Unmarshal(files["meta.json"], &meta); err != nil {
+		t.Fatal(err)
+	}
+	crc := crc64.Checksum(state, crc64.MakeTable(crc64.ECMA))
+	if meta.Size != len(state) || len(meta.CRC) != 8 || binary.BigEndian.Uint64(meta.CRC) != crc {
+		t.Errorf("got Size %d, CRC %x, want %d, %016x", meta.Size, meta.CRC, len(state), crc)
+	}
+	if meta.Index != 500 {
+		t.Errorf("got Index %d, want the snapshot's LastIndex 500", meta.Index)
+	}
+}
+
+func TestWriteArchiveKeepsMeta(t *testing.T) {
+	state := testSnapshot(t, 500, testKV("app/config", "v", 5, 10))
+	path := writeTestArchive(t, map[string]interface{}{"ID": "2-500-1700000000000", "Index": 500, "Term": 2, "Size": 1, "CRC": "AAAAAAAAAAA="}, state)
+
+	meta, err := readArchiveMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["ID"] != "2-500-1700000000000" || meta["Term"] != 2.0 {
+		t.Errorf("got meta %v, want the ID and Term kept", meta)
+	}
+	if meta["Size"] != float64(len(state)) {
+		t.Errorf("got Size %v, want %d", meta["Size"], len(state))
+	}
+	crc := make([]byte, 8)
+	binary.BigEndian.PutUint64(crc, crc64.Checksum(state, crc64.MakeTable(crc64.ECMA)))
+	if meta["CRC"] != base64.StdEncoding.EncodeToString(crc) {
+		t.Errorf("got CRC %v, want it updated", meta["CRC"])
+	}
+
+	// A bare state.bin has no metadata.
+	bare := filepath.Join(t.TempDir(), "state.bin")
+	if err := ioutil.WriteFile(bare, state, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := readArchiveMeta(bare); err != nil || meta != nil {
+		t.Errorf("got %v, %v for a bare state.bin, want no metadata", meta, err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// auditReportVersion is bumped whenever a field of auditReport changes meaning
+// or is removed. New fields may be added without bumping it.
+const auditReportVersion = 1
+
+// auditPattern is a kind of sensitive data audit looks for.
+type auditPattern struct {
+	Name string
+	re   *regexp.Regexp
+}
+
+// auditPatterns are the built-in patterns, roughly from most to least
+// sensitive. They favor catching likely credentials over avoiding false
+// positives since every finding is reviewed by a person.
+var auditPatterns = []auditPattern{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"bearer-token", regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{20,}=*`)},
+	{"password", regexp.MustCompile(`(?i)\b(password|passwd|secret|api_?key)["']?\s*[:=]\s*["']?[^\s"',;]{6,}`)},
+	{"email", regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+}
+
+// auditFinding is a place something sensitive looking was found. Values are
+// never included so the report itself can be shared.
+type auditFinding struct {
+	Kind string `json:"kind"`
+	// Record is what holds the value, e.g. "KVS app/config" or
+	// "ConfigEntry service-defaults/web".
+	Record string `json:"record"`
+	// Field is the path to the value within a config entry.
+	Field string `json:"field,omitempty"`
+}
+
+// auditReport is the JSON written by `audit -format json`.
+type auditReport struct {
+	Version int    `json:"version"`
+	Path    string `json:"path"`
+	Scanned int    `json:"scanned"`
+	// Undecoded is the number of config entries that couldn't be decoded
+	// and so weren't scanned.
+	Undecoded int            `json:"undecoded,omitempty"`
+	Findings  []auditFinding `json:"findings"`
+	Counts    map[string]int `json:"counts"`
+}
+
+// auditor scans values for the patterns.
+type auditor struct {
+	patterns []auditPattern
+	report   auditReport
+}
+
+// scan records a finding for each pattern that matches s.
+func (a *auditor) scan(s, record, field string) {
+	for _, p := range a.patterns {
+		if p.re.MatchString(s) {
+			a.report.Findings = append(a.report.Findings, auditFinding{p.Name, record, field})
+			a.report.Counts[p.Name]++
+		}
+	}
+}
+
+// walk scans every string in a decoded value, naming fields by their path.
+func (a *auditor) walk(v interface{}, record, path string) {
+	switch v := v.(type) {
+	case string:
+		a.scan(v, record, path)
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			a.walk(v[k], record, child)
+		}
+	case []interface{}:
+		for i, e := range v {
+			a.walk(e, record, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func (a *auditor) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "KVS":
+		a.report.Scanned++
+		a.scan(stringField(val, "Value"), "KVS "+kvKey(val), "")
+	case "ConfigEntryRequestType":
+		entry := configEntry(val)
+		if entry == nil {
+			a.report.Undecoded++
+			return
+		}
+		a.report.Scanned++
+		a.walk(entry, recordIdentity(msgType, val), "")
+	}
+}
+
+// parseAuditPatterns returns the built-in patterns named in only, or all of
+// them if it's empty, followed by the custom patterns given as name=regexp.
+func parseAuditPatterns(only string, custom []string) ([]auditPattern, error) {
+	var patterns []auditPattern
+	if only == "" {
+		patterns = append(patterns, auditPatterns...)
+	} else {
+		for _, name := range strings.Split(only, ",") {
+			name = strings.TrimSpace(name)
+			found := false
+			for _, p := range auditPatterns {
+				if p.Name == name {
+					patterns = append(patterns, p)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown pattern %q, expected one of: %s", name, strings.Join(auditPatternNames(), ", "))
+			}
+		}
+	}
+	for _, c := range custom {
+		name, expr, ok := strings.Cut(c, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid -pattern %q, expected name=regexp", c)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -pattern %q: %s", c, err)
+		}
+		patterns = append(patterns, auditPattern{name, re})
+	}
+	return patterns, nil
+}
+
+// auditPatternNames returns the names of the built-in patterns.
+func auditPatternNames() []string {
+	names := make([]string, len(auditPatterns))
+	for i, p := range auditPatterns {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// auditCommand implements `audit [snapshot]`, which looks through KV values
+// and config entries for things resembling credentials or personal data and
+// lists where they are, without the values, for security reviews of what's
+// been stored in Consul. It exits with exitThreshold if anything was found.
+func auditCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	only := fs.String("only", "", "comma separated list of the built-in patterns to look for: "+strings.Join(auditPatternNames(), ", ")+" (default all)")
+	var custom stringsFlag
+	fs.Var(&custom, "pattern", "additional pattern to look for as name=regexp (may be repeated)")
+	format := formatFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool audit [options] [snapshot]")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() > 1 || !validFormat(*format) {
+			fs.Usage()
+			exit(exitError)
+		}
+		path := "-"
+		if fs.NArg() == 1 {
+			path = fs.Arg(0)
+		}
+		patterns, err := parseAuditPatterns(*only, custom)
+		if err != nil {
+			fatal(err)
+		}
+
+		a := &auditor{patterns: patterns, report: auditReport{
+			Version:  auditReportVersion,
+			Path:     path,
+			Findings: []auditFinding{},
+			Counts:   make(map[string]int),
+		}}
+		in, err := openSnapshot(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer in.Close()
+		if _, err := readSnapshot(in, a.add); err != nil {
+			fatal(err)
+		}
+
+		if *format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(a.report); err != nil {
+				fatal(err)
+			}
+		} else {
+			printAudit(os.Stdout, &a.report)
+		}
+		if len(a.report.Findings) > 0 {
+			exit(exitThreshold)
+		}
+	}
+}
+
+func printAudit(w io.Writer, r *auditReport) {
+	if r.Undecoded > 0 {
+		defer fmt.Fprintf(w, "%d config entries couldn't be decoded and weren't scanned; check them with the verify command.\n", r.Undecoded)
+	}
+	if len(r.Findings) == 0 {
+		fmt.Fprintf(w, "Nothing sensitive looking found in %d KV entries and config entries.\n", r.Scanned)
+		return
+	}
+	fmt.Fprintf(w, "% 16s %s\n", "Kind", "Location")
+	fmt.Fprintf(w, "%s %s\n", strings.Repeat("-", 16), strings.Repeat("-", 40))
+	for _, f := range r.Findings {
+		loc := f.Record
+		if f.Field != "" {
+			loc += " " + f.Field
+		}
+		fmt.Fprintf(w, "% 16s %s\n", f.Kind, loc)
+	}
+
+	kinds := make([]string, 0, len(r.Counts))
+	for kind := range r.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintln(w)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "% 16s %d\n", kind+":", r.Counts[kind])
+	}
+	fmt.Fprintf(w, "%d findings in %d KV entries and config entries. Values aren't shown; review them with the decode command.\n", len(r.Findings), r.Scanned)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// benchResult is the cost of reading a snapshot in one way, averaged over a
+// number of runs.
+type benchResult struct {
+	Name   string
+	Time   time.Duration
+	Allocs uint64
+	Bytes  uint64
+}
+
+// benchRead reads the snapshot in data n times with read and measures it.
+func benchRead(name string, data []byte, n int, read func(io.Reader) error) (benchResult, error) {
+	res := benchResult{Name: name}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := read(bytes.NewReader(data)); err != nil {
+			return res, err
+		}
+	}
+	res.Time = time.Since(start) / time.Duration(n)
+	runtime.ReadMemStats(&after)
+	res.Allocs = (after.Mallocs - before.Mallocs) / uint64(n)
+	res.Bytes = (after.TotalAlloc - before.TotalAlloc) / uint64(n)
+	return res, nil
+}
+
+// benchCommand implements `bench <snapshot>`, which reads a snapshot from
+// memory several times and reports how fast it was decoded, so changes to the
+// tool itself can be measured.
+func benchCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	n := fs.Int("n", 5, "number of times to read the snapshot")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool bench [options] <snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 || *n < 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		r, err := openSnapshot(fs.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", fs.Arg(0), err))
+		}
+
+		runs := []struct {
+			name string
+			read func(io.Reader) error
+		}{
+			{"decode", func(r io.Reader) error {
+				_, err := readSnapshot(r, func(int, interface{}, int) {})
+				return err
+			}},
+			{"scan", func(r io.Reader) error {
+				_, err := scanSnapshot(r, func(int, []byte, int) {})
+				return err
+			}},
+		}
+		fmt.Printf("Read %s %d times using %d CPUs\n\n", ByteSize(uint64(len(data))), *n, runtime.GOMAXPROCS(0))
+		fmt.Printf("% 8s % 12s % 12s % 14s % 12s\n", "Mode", "Time", "Rate", "Allocs", "Alloc Bytes")
+		fmt.Printf("%s %s %s %s %s\n", strings.Repeat("-", 8), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 14), strings.Repeat("-", 12))
+		for _, run := range runs {
+			res, err := benchRead(run.name, data, *n, run.read)
+			if err != nil {
+				fatal(fmt.Errorf("%s: %w", fs.Arg(0), err))
+			}
+			rate := float64(len(data)) / res.Time.Seconds()
+			fmt.Printf("% 8s % 12s % 10s/s % 14d % 12s\n", res.Name, res.Time.Round(time.Microsecond),
+				ByteSize(uint64(rate)), res.Allocs, ByteSize(res.Bytes))
+		}
+	}
+}
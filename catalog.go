@@ -0,0 +1,543 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// nodeStats attributes the size of Register records to the node they belong
+// to, which makes agents pushing abnormal amounts of catalog state stand out.
+type nodeStats struct {
+	top   int
+	nodes statMap
+	total int
+}
+
+func newNodeStats(c *reportConfig) report {
+	return &nodeStats{top: c.Top, nodes: make(statMap)}
+}
+
+func (n *nodeStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Register" {
+		return
+	}
+	n.nodes.add(stringField(val, "Node"), size)
+	n.total += size
+}
+
+func (n *nodeStats) print(w io.Writer) {
+	printTopStats(w, "Node", n.nodes.slice(), n.total, n.top)
+}
+
+// serviceStats attributes the size of service registrations, and the checks
+// that belong to them, to the service name.
+type serviceStats struct {
+	top      int
+	services statMap
+	total    int
+}
+
+func newServiceStats(c *reportConfig) report {
+	return &serviceStats{top: c.Top, services: make(statMap)}
+}
+
+func (s *serviceStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Register" {
+		return
+	}
+	name := stringField(val, "Service", "Service")
+	if name == "" {
+		name = stringField(val, "Check", "ServiceName")
+	}
+	if name == "" {
+		return
+	}
+	s.services.add(name, size)
+	s.total += size
+}
+
+func (s *serviceStats) print(w io.Writer) {
+	printTopStats(w, "Service", s.services.slice(), s.total, s.top)
+}
+
+// checkOutputStats measures how much of the catalog is made up of health check
+// Output, which verbose checks can bloat considerably.
+type checkOutputStats struct {
+	top int
+
+	types   statMap
+	largest []checkOutput
+
+	output, catalog int
+}
+
+type checkOutput struct {
+	Node, CheckID string
+	Size          int
+}
+
+func newCheckOutputStats(c *reportConfig) report {
+	return &checkOutputStats{top: c.Top, types: make(statMap)}
+}
+
+func (c *checkOutputStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Register" {
+		return
+	}
+	c.catalog += size
+
+	check := field(val, "Check")
+	if check == nil {
+		return
+	}
+	out := len(stringField(check, "Output"))
+	c.output += out
+
+	checkType := stringField(check, "Type")
+	if checkType == "" {
+		if stringField(check, "CheckID") == "serfHealth" {
+			checkType = "serf"
+		} else {
+			checkType = "(unknown)"
+		}
+	}
+	c.types.add(checkType, out)
+
+	c.largest = append(c.largest, checkOutput{
+		Node:    stringField(check, "Node"),
+		CheckID: stringField(check, "CheckID"),
+		Size:    out,
+	})
+	// Only keep the largest few around
+	if c.top > 0 && len(c.largest) > 2*c.top {
+		c.trim()
+	}
+}
+
+func (c *checkOutputStats) trim() {
+	sort.Slice(c.largest, func(i, j int) bool { return c.largest[i].Size > c.largest[j].Size })
+	if c.top > 0 && len(c.largest) > c.top {
+		c.largest = c.largest[:c.top]
+	}
+}
+
+func (c *checkOutputStats) print(w io.Writer) {
+	pct := 0.0
+	if c.catalog > 0 {
+		pct = 100 * float64(c.output) / float64(c.catalog)
+	}
+	fmt.Fprintf(w, "Check Output: %s of %s catalog records (%.1f%%)\n\n", ByteSize(uint64(c.output)), ByteSize(uint64(c.catalog)), pct)
+	printTopStats(w, "Check Type", c.types.slice(), c.output, c.top)
+
+	c.trim()
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "% 12s %s\n", "Output Size", "Check")
+	fmt.Fprintf(w, "%s %s\n", strings.Repeat("-", 12), strings.Repeat("-", 22))
+	for _, o := range c.largest {
+		fmt.Fprintf(w, "% 12s %s/%s\n", ByteSize(uint64(o.Size)), o.Node, o.CheckID)
+	}
+}
+
+// catalogSummary counts the high-level objects in the catalog, which is often
+// what's needed to size a migration when a snapshot is all there is.
+type catalogSummary struct {
+	nodes     map[string]bool
+	services  map[string]bool
+	instances int
+	checks    int
+	connect   map[string]bool
+}
+
+func newCatalogSummary(c *reportConfig) report {
+	return &catalogSummary{
+		nodes:    make(map[string]bool),
+		services: make(map[string]bool),
+		connect:  make(map[string]bool),
+	}
+}
+
+func (c *catalogSummary) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Register" {
+		return
+	}
+	node := stringField(val, "Node")
+	c.nodes[node] = true
+
+	if svc := field(val, "Service"); svc != nil {
+		c.instances++
+		c.services[stringField(svc, "Service")] = true
+
+		// A workload is connect-enabled if it's native or has a sidecar proxy
+		// registered for it.
+		switch {
+		case boolField(svc, "Connect", "Native"):
+			c.connect[node+"/"+stringField(svc, "ID")] = true
+		case stringField(svc, "Kind") == "connect-proxy":
+			dest := stringField(svc, "Proxy", "DestinationServiceID")
+			if dest == "" {
+				dest = stringField(svc, "Proxy", "DestinationServiceName")
+			}
+			c.connect[node+"/"+dest] = true
+		}
+	}
+	if field(val, "Check") != nil {
+		c.checks++
+	}
+}
+
+func (c *catalogSummary) print(w io.Writer) {
+	fmt.Fprintln(w, "Catalog Summary")
+	fmt.Fprintf(w, "% 30s % 10d\n", "Nodes:", len(c.nodes))
+	fmt.Fprintf(w, "% 30s % 10d\n", "Service Instances:", c.instances)
+	fmt.Fprintf(w, "% 30s % 10d\n", "Distinct Services:", len(c.services))
+	fmt.Fprintf(w, "% 30s % 10d\n", "Checks:", c.checks)
+	fmt.Fprintf(w, "% 30s % 10d\n", "Connect-enabled Instances:", len(c.connect))
+}
+
+// serviceKindStats classifies service registrations by their Kind so the share
+// of the catalog taken up by sidecar proxies and gateways is visible.
+type serviceKindStats struct {
+	kinds   statMap
+	catalog int
+}
+
+func newServiceKindStats(c *reportConfig) report {
+	return &serviceKindStats{kinds: make(statMap)}
+}
+
+func (s *serviceKindStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Register" {
+		return
+	}
+	s.catalog += size
+
+	svc := field(val, "Service")
+	if svc == nil {
+		return
+	}
+	kind := stringField(svc, "Kind")
+	if kind == "" {
+		kind = "typical"
+	}
+	s.kinds.add(kind, size)
+}
+
+func (s *serviceKindStats) print(w io.Writer) {
+	ss := s.kinds.slice()
+	total := 0
+	for _, k := range ss {
+		total += k.Sum
+	}
+	printStats(w, "Service Kind", ss, total)
+
+	if s.catalog == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	for _, kind := range []string{"typical", "connect-proxy"} {
+		k := s.kinds[kind]
+		fmt.Fprintf(w, "%s registrations are %s (%.1f%%) of %s catalog records\n", kind,
+			ByteSize(uint64(k.Sum)), 100*float64(k.Sum)/float64(s.catalog), ByteSize(uint64(s.catalog)))
+	}
+}
+
+// nodeMetaStats measures the bytes spent on NodeMeta and TaggedAddresses.
+// Every service and check record for a node carries a copy of the node's
+// fields so the cost is multiplied by the number of records the node has.
+type nodeMetaStats struct {
+	top int
+
+	nodes map[string]*nodeMeta
+	// keys counts the nodes with each meta key.
+	keys map[string]int
+}
+
+type nodeMeta struct {
+	Name    string
+	Size    int
+	Records int
+}
+
+func newNodeMetaStats(c *reportConfig) report {
+	return &nodeMetaStats{
+		top:   c.Top,
+		nodes: make(map[string]*nodeMeta),
+		keys:  make(map[string]int),
+	}
+}
+
+// mapSize approximates the encoded size of a string map by the length of its
+// keys and values.
+func mapSize(v interface{}) int {
+	m, _ := v.(map[interface{}]interface{})
+	size := 0
+	for k, v := range m {
+		ks, _ := k.(string)
+		vs, _ := v.(string)
+		size += len(ks) + len(vs)
+	}
+	return size
+}
+
+func (n *nodeMetaStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Register" {
+		return
+	}
+	name := stringField(val, "Node")
+	nm, ok := n.nodes[name]
+	if !ok {
+		nm = &nodeMeta{Name: name}
+		n.nodes[name] = nm
+	}
+	nm.Records++
+
+	if field(val, "Service") != nil || field(val, "Check") != nil {
+		return
+	}
+	nm.Size = mapSize(field(val, "NodeMeta")) + mapSize(field(val, "TaggedAddresses"))
+	meta, _ := field(val, "NodeMeta").(map[interface{}]interface{})
+	for k := range meta {
+		if ks, ok := k.(string); ok {
+			n.keys[ks]++
+		}
+	}
+}
+
+func (n *nodeMetaStats) print(w io.Writer) {
+	nodes := make([]*nodeMeta, 0, len(n.nodes))
+	total := 0
+	for _, nm := range n.nodes {
+		nodes = append(nodes, nm)
+		total += nm.Size * nm.Records
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Size*nodes[i].Records > nodes[j].Size*nodes[j].Records
+	})
+	if n.top > 0 && len(nodes) > n.top {
+		nodes = nodes[:n.top]
+	}
+
+	fmt.Fprintf(w, "Node Meta and Tagged Addresses: ~%s including copies in service and check records\n\n", ByteSize(uint64(total)))
+	fmt.Fprintf(w, "% 22s % 12s % 8s % 12s\n", "Node", "Meta Size", "Records", "Total Size")
+	fmt.Fprintf(w, "%s %s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 12), strings.Repeat("-", 8), strings.Repeat("-", 12))
+	for _, nm := range nodes {
+		fmt.Fprintf(w, "% 22s % 12s % 8d % 12s\n", nm.Name, ByteSize(uint64(nm.Size)), nm.Records, ByteSize(uint64(nm.Size*nm.Records)))
+	}
+
+	keys := make([]string, 0, len(n.keys))
+	for k := range n.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return n.keys[keys[i]] > n.keys[keys[j]] })
+	if n.top > 0 && len(keys) > n.top {
+		keys = keys[:n.top]
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "% 22s % 8s\n", "Meta Key", "Nodes")
+	fmt.Fprintf(w, "%s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 8))
+	for _, k := range keys {
+		fmt.Fprintf(w, "% 22s % 8d\n", k, n.keys[k])
+	}
+}
+
+// tenantStats attributes catalog records to the admin partition and namespace
+// they belong to so enterprise operators can see which tenants own the most
+// catalog state.
+type tenantStats struct {
+	top     int
+	tenants statMap
+	total   int
+}
+
+func newTenantStats(c *reportConfig) report {
+	return &tenantStats{top: c.Top, tenants: make(statMap)}
+}
+
+func (t *tenantStats) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "Register", "Deregister":
+	default:
+		return
+	}
+
+	// Services and checks carry their own namespace, nodes are only scoped to
+	// a partition.
+	meta := val
+	if svc := field(val, "Service"); svc != nil {
+		meta = svc
+	} else if check := field(val, "Check"); check != nil {
+		meta = check
+	}
+	partition := stringField(meta, "Partition")
+	if partition == "" {
+		partition = stringField(val, "Partition")
+	}
+	t.tenants.add(tenantName(partition, stringField(meta, "Namespace")), size)
+	t.total += size
+}
+
+// tenantName formats a partition and namespace, either of which may be empty
+// in OSS snapshots meaning default.
+func tenantName(partition, namespace string) string {
+	if partition == "" {
+		partition = "default"
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return partition + "/" + namespace
+}
+
+func (t *tenantStats) print(w io.Writer) {
+	printTopStats(w, "Partition/Namespace", t.tenants.slice(), t.total, t.top)
+}
+
+// proxyConfigStats finds the largest opaque Proxy.Config and Expose payloads
+// in proxy registrations. Envoy escape hatches embedded in these can get huge
+// and are better expressed as config entries.
+type proxyConfigStats struct {
+	top      int
+	services map[string]*proxyConfig
+}
+
+type proxyConfig struct {
+	Service           string
+	Instances         int
+	MaxConfig, Config int
+	MaxExpose, Expose int
+}
+
+func newProxyConfigStats(c *reportConfig) report {
+	return &proxyConfigStats{top: c.Top, services: make(map[string]*proxyConfig)}
+}
+
+func (p *proxyConfigStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Register" {
+		return
+	}
+	// Typical services always carry an empty Proxy struct.
+	if stringField(val, "Service", "Kind") == "" {
+		return
+	}
+	proxy := field(val, "Service", "Proxy")
+	config := encodedSize(field(proxy, "Config"))
+	expose := 0
+	if paths, _ := field(proxy, "Expose", "Paths").([]interface{}); len(paths) > 0 || boolField(proxy, "Expose", "Checks") {
+		expose = encodedSize(field(proxy, "Expose"))
+	}
+	if config == 0 && expose == 0 {
+		return
+	}
+
+	name := stringField(val, "Service", "Service")
+	pc, ok := p.services[name]
+	if !ok {
+		pc = &proxyConfig{Service: name}
+		p.services[name] = pc
+	}
+	pc.Instances++
+	pc.Config += config
+	pc.Expose += expose
+	if config > pc.MaxConfig {
+		pc.MaxConfig = config
+	}
+	if expose > pc.MaxExpose {
+		pc.MaxExpose = expose
+	}
+}
+
+func (p *proxyConfigStats) print(w io.Writer) {
+	pcs := make([]*proxyConfig, 0, len(p.services))
+	for _, pc := range p.services {
+		pcs = append(pcs, pc)
+	}
+	sort.Slice(pcs, func(i, j int) bool {
+		return pcs[i].MaxConfig+pcs[i].MaxExpose > pcs[j].MaxConfig+pcs[j].MaxExpose
+	})
+	if p.top > 0 && len(pcs) > p.top {
+		pcs = pcs[:p.top]
+	}
+
+	fmt.Fprintf(w, "% 22s % 9s % 12s % 12s % 12s % 12s\n", "Service", "Instances", "Max Config", "Total Config", "Max Expose", "Total Expose")
+	fmt.Fprintf(w, "%s %s %s %s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 9),
+		strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 12))
+	for _, pc := range pcs {
+		fmt.Fprintf(w, "% 22s % 9d % 12s % 12s % 12s % 12s\n", pc.Service, pc.Instances,
+			ByteSize(uint64(pc.MaxConfig)), ByteSize(uint64(pc.Config)),
+			ByteSize(uint64(pc.MaxExpose)), ByteSize(uint64(pc.Expose)))
+	}
+}
+
+// duplicateNodes finds node names registered with more than one node ID and
+// node IDs shared by more than one node name, both of which cause flapping
+// registrations.
+type duplicateNodes struct {
+	idsByName map[string]map[string]string
+	namesByID map[string]map[string]string
+}
+
+func newDuplicateNodes(c *reportConfig) report {
+	return &duplicateNodes{
+		idsByName: make(map[string]map[string]string),
+		namesByID: make(map[string]map[string]string),
+	}
+}
+
+func (d *duplicateNodes) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Register" {
+		return
+	}
+	name, id := stringField(val, "Node"), stringField(val, "ID")
+	addr := stringField(val, "Address")
+	if id == "" {
+		// Very old agents don't have node IDs
+		return
+	}
+	addConflict(d.idsByName, name, id, addr)
+	addConflict(d.namesByID, id, name, addr)
+}
+
+// addConflict records that key was seen with value at addr.
+func addConflict(m map[string]map[string]string, key, value, addr string) {
+	vs, ok := m[key]
+	if !ok {
+		vs = make(map[string]string)
+		m[key] = vs
+	}
+	vs[value] = addr
+}
+
+func (d *duplicateNodes) print(w io.Writer) {
+	fmt.Fprintln(w, "Node Names With Multiple IDs")
+	printConflicts(w, d.idsByName)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Node IDs With Multiple Names")
+	printConflicts(w, d.namesByID)
+}
+
+func printConflicts(w io.Writer, m map[string]map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k, vs := range m {
+		if len(vs) > 1 {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(w, "  none")
+		return
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s:\n", k)
+		vs := make([]string, 0, len(m[k]))
+		for v := range m[k] {
+			vs = append(vs, v)
+		}
+		sort.Strings(vs)
+		for _, v := range vs {
+			fmt.Fprintf(w, "    %s (%s)\n", v, m[k][v])
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// catRecord is the JSON cat-record prints: where the record is along with
+// its value in the same form as decode writes.
+type catRecord struct {
+	Record int             `json:"record"`
+	Offset int             `json:"offset"`
+	Size   int             `json:"size"`
+	Type   *int            `json:"type,omitempty"`
+	Name   string          `json:"name,omitempty"`
+	Header *snapshotHeader `json:"header,omitempty"`
+	Value  interface{}     `json:"value,omitempty"`
+
+	// raw is the record's encoding, including its type.
+	raw []byte
+}
+
+// catRecordCommand implements `cat-record <record> <snapshot>`, which prints
+// one record in full so a record named by stats, verify or an error message
+// can be inspected without decoding the whole snapshot to JSON.
+func catRecordCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	byOffset := fs.Bool("offset", false, "find the record starting at the given byte offset rather than by its ordinal")
+	hex := fs.Bool("hex", false, "print the record's raw msgpack bytes as a hex and ASCII dump rather than decoding it")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool cat-record [options] <record> <snapshot>")
+		fmt.Fprintln(os.Stderr, "Records are numbered from 1, with 0 being the header.")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 2 {
+			fs.Usage()
+			exit(exitError)
+		}
+		n, err := strconv.Atoi(fs.Arg(0))
+		if err != nil || n < 0 {
+			fatal(fmt.Errorf("invalid record %q, expected a record number or with -offset a byte offset", fs.Arg(0)))
+		}
+		path := fs.Arg(1)
+
+		r, err := openSnapshot(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer r.Close()
+		rec, err := findRecord(r, n, *byOffset, !*hex)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", path, err))
+		}
+		if *hex {
+			name := rec.Name
+			if rec.Header != nil {
+				name = "header"
+			}
+			fmt.Printf("Record %d (%s) at offset %d, %d bytes\n", rec.Record, name, rec.Offset, rec.Size)
+			hexDump(os.Stdout, rec.raw, rec.Offset)
+			return
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rec); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// findRecord reads r up to the record with ordinal n, or starting at offset n
+// if byOffset is set, and returns it, decoded if decode is set.
+func findRecord(r io.Reader, n int, byOffset, decode bool) (*catRecord, error) {
+	s, err := newSnapshotScanner(r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		// The header is at offset 0 either way.
+		return &catRecord{Size: len(s.headerRaw), Header: &s.header, raw: s.headerRaw}, nil
+	}
+	if byOffset && n < s.offset {
+		return nil, fmt.Errorf("offset %d is inside the header, which is the first %d bytes", n, s.offset)
+	}
+	s.skipLarge = true
+	for {
+		msgType, raw, err := s.nextRaw()
+		if err == io.EOF {
+			if byOffset {
+				return nil, fmt.Errorf("offset %d is past the end of the snapshot at %d", n, s.offset)
+			}
+			return nil, fmt.Errorf("there are only %d records", s.records)
+		} else if err != nil {
+			return nil, err
+		}
+		start := s.offset - s.size
+		switch {
+		case byOffset && n > start && n < s.offset:
+			return nil, fmt.Errorf("offset %d is inside record %d, which starts at offset %d", n, s.records, start)
+		case byOffset && n != start, !byOffset && n != s.records:
+			continue
+		}
+		if raw == nil {
+			return nil, fmt.Errorf("record %d is %s, larger than -max-record-bytes; raise it to read the record", s.records, ByteSize(uint64(s.size)))
+		}
+		rec := &catRecord{
+			Record: s.records,
+			Offset: start,
+			Size:   s.size,
+			Type:   &msgType,
+			Name:   typeName(msgType),
+			raw:    s.raw(),
+		}
+		if decode {
+			val, err := snapshot.Decode(raw)
+			if err != nil {
+				err = fmt.Errorf("%w (see its bytes with -hex)", err)
+				return nil, &snapshotError{Offset: start, Record: s.records, Type: typeName(msgType), Err: err}
+			}
+			rec.Value = recordJSON(msgType, val)
+		}
+		return rec, nil
+	}
+}
+
+// hexDump writes b as lines of 16 bytes in hex and ASCII, like hexdump -C,
+// numbering the lines from offset so they match offsets in the snapshot.
+func hexDump(w io.Writer, b []byte, offset int) {
+	for i := 0; i < len(b); i += 16 {
+		line := b[i:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		fmt.Fprintf(w, "%08x ", offset+i)
+		for j := 0; j < 16; j++ {
+			if j == 8 {
+				fmt.Fprint(w, " ")
+			}
+			if j < len(line) {
+				fmt.Fprintf(w, " %02x", line[j])
+			} else {
+				fmt.Fprint(w, "   ")
+			}
+		}
+		fmt.Fprint(w, "  |")
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			fmt.Fprintf(w, "%c", c)
+		}
+		fmt.Fprintln(w, "|")
+	}
+}
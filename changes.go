@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// recordVersion identifies the version of a record by the raft index it was
+// last modified at.
+type recordVersion struct {
+	ModifyIndex uint64
+	Size        int
+}
+
+// recordIdentity returns a name that identifies the object a record holds
+// across snapshots, e.g. "KVS foo/bar" or "Service node-1/web". It returns ""
+// for records that can't be identified.
+func recordIdentity(msgType int, val interface{}) string {
+	name := typeName(msgType)
+	switch name {
+	case "KVS":
+		return "KVS " + kvKey(val)
+	case "Register":
+		node := stringField(val, "Node")
+		if svc := field(val, "Service"); svc != nil {
+			return "Service " + node + "/" + stringField(svc, "ID")
+		}
+		if check := field(val, "Check"); check != nil {
+			return "Check " + node + "/" + stringField(check, "CheckID")
+		}
+		return "Node " + node
+	case "ConfigEntryRequestType":
+		entry := configEntry(val)
+		return "ConfigEntry " + stringField(entry, "Kind") + "/" + intentionName(stringField(entry, "Namespace"), stringField(entry, "Name"))
+	case "ACLTokenSet":
+		if id := stringField(val, "AccessorID"); id != "" {
+			return name + " " + id
+		}
+	case "Session", "ACLPolicySet", "ACLRoleSetRequestType", "Intention", "PreparedQuery", "ConnectCA":
+		if id := stringField(val, "ID"); id != "" {
+			return name + " " + id
+		}
+	}
+	return ""
+}
+
+// recordModifyIndex returns the raft index the record was last modified at.
+func recordModifyIndex(msgType int, val interface{}) uint64 {
+	switch typeName(msgType) {
+	case "Register":
+		if svc := field(val, "Service"); svc != nil {
+			return uintField(svc, "ModifyIndex")
+		}
+		if check := field(val, "Check"); check != nil {
+			return uintField(check, "ModifyIndex")
+		}
+	case "ConfigEntryRequestType":
+		return uintField(configEntry(val), "ModifyIndex")
+	}
+	return uintField(val, "ModifyIndex")
+}
+
+// recordChange is a record that differs between two snapshots.
+type recordChange struct {
+	Change      string
+	ID          string
+	Size        int
+	ModifyIndex uint64
+}
+
+// recordChanges returns the records that were added, modified or removed
+// between older and newer, largest first.
+func recordChanges(older, newer map[string]recordVersion) []recordChange {
+	var changes []recordChange
+	for id, n := range newer {
+		o, ok := older[id]
+		switch {
+		case !ok:
+			changes = append(changes, recordChange{"added", id, n.Size, n.ModifyIndex})
+		case o.ModifyIndex != n.ModifyIndex || o.Size != n.Size:
+			changes = append(changes, recordChange{"modified", id, n.Size, n.ModifyIndex})
+		}
+	}
+	for id, o := range older {
+		if _, ok := newer[id]; !ok {
+			changes = append(changes, recordChange{"removed", id, o.Size, o.ModifyIndex})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Size != changes[j].Size {
+			return changes[i].Size > changes[j].Size
+		}
+		return changes[i].ID < changes[j].ID
+	})
+	return changes
+}
+
+// printRecordChanges lists the top largest records that changed.
+func printRecordChanges(w io.Writer, older, newer map[string]recordVersion, top int) {
+	changes := recordChanges(older, newer)
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.Change]++
+	}
+	fmt.Fprintf(w, "Changed Records: %d added, %d modified, %d removed\n\n", counts["added"], counts["modified"], counts["removed"])
+
+	fmt.Fprintf(w, "% 8s % 12s % 12s %s\n", "Change", "Size", "ModifyIndex", "Record")
+	fmt.Fprintf(w, "%s %s %s %s\n", strings.Repeat("-", 8), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 22))
+	for i, c := range changes {
+		if top > 0 && i >= top {
+			fmt.Fprintf(w, "... and %d more\n", len(changes)-i)
+			break
+		}
+		fmt.Fprintf(w, "% 8s % 12s % 12d %s\n", c.Change, ByteSize(uint64(c.Size)), c.ModifyIndex, c.ID)
+	}
+}
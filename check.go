@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// checkReportVersion is bumped whenever a field of checkReport changes meaning
+// or is removed. New fields may be added without bumping it.
+const checkReportVersion = 1
+
+// checkReport is the JSON written by `check`.
+type checkReport struct {
+	Version    int              `json:"version"`
+	Path       string           `json:"path"`
+	Count      int              `json:"count"`
+	Size       int              `json:"size"`
+	OK         bool             `json:"ok"`
+	Violations []checkViolation `json:"violations"`
+}
+
+// checkViolation is a single threshold that was exceeded. Limit and Actual are
+// in bytes for sizes and records for counts.
+type checkViolation struct {
+	Check   string `json:"check"`
+	Subject string `json:"subject,omitempty"`
+	Limit   uint64 `json:"limit"`
+	Actual  uint64 `json:"actual"`
+}
+
+// checkLimits are the thresholds a snapshot is checked against.
+type checkLimits struct {
+	TotalSize    uint64
+	PrefixSizes  map[string]uint64
+	RecordCounts map[int]uint64
+}
+
+// splitLimit splits a flag value of the form name=limit.
+func splitLimit(s string) (string, string, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid limit %q, expected name=limit", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// checkLimitFlags registers -max-total-size, -max-kv-prefix-size and
+// -max-record-count, whose usage starts with verb, and returns a function
+// that parses them once the flags have been.
+func checkLimitFlags(fs *flag.FlagSet, verb string) func() (*checkLimits, error) {
+	var totalSize byteSizeFlag
+	fs.Var(&totalSize, "max-total-size", verb+" if the snapshot is larger than this, e.g. 2GB")
+	var prefixSizes, recordCounts stringsFlag
+	fs.Var(&prefixSizes, "max-kv-prefix-size", verb+" if KV entries under a prefix take more than a size, e.g. vault/=2GB (may be repeated)")
+	fs.Var(&recordCounts, "max-record-count", verb+" if there are more records of a type than a count, e.g. KVS=1e6 (may be repeated)")
+	return func() (*checkLimits, error) {
+		return parseCheckLimits(uint64(totalSize), prefixSizes, recordCounts)
+	}
+}
+
+// parseCheckLimits parses the values of -max-kv-prefix-size and
+// -max-record-count.
+func parseCheckLimits(totalSize uint64, prefixSizes, recordCounts []string) (*checkLimits, error) {
+	l := &checkLimits{
+		TotalSize:    totalSize,
+		PrefixSizes:  make(map[string]uint64),
+		RecordCounts: make(map[int]uint64),
+	}
+	for _, v := range prefixSizes {
+		prefix, limit, err := splitLimit(v)
+		if err != nil {
+			return nil, err
+		}
+		size, err := ParseByteSize(limit)
+		if err != nil {
+			return nil, err
+		}
+		l.PrefixSizes[prefix] = size
+	}
+	for _, v := range recordCounts {
+		name, limit, err := splitLimit(v)
+		if err != nil {
+			return nil, err
+		}
+		msgType, err := parseMsgType(name)
+		if err != nil {
+			return nil, err
+		}
+		// Accept counts like 1e6 as well as plain integers.
+		n, err := strconv.ParseFloat(limit, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid record count %q", limit)
+		}
+		l.RecordCounts[msgType] = uint64(n)
+	}
+	return l, nil
+}
+
+// limitChecker tallies records as they're read to check them against limits.
+type limitChecker struct {
+	limits      *checkLimits
+	count       int
+	prefixSizes map[string]uint64
+	counts      map[int]uint64
+}
+
+func newLimitChecker(l *checkLimits) *limitChecker {
+	return &limitChecker{limits: l, prefixSizes: make(map[string]uint64), counts: make(map[int]uint64)}
+}
+
+func (c *limitChecker) add(msgType int, raw []byte, size int) {
+	c.count++
+	c.counts[msgType]++
+	if typeName(msgType) != "KVS" {
+		return
+	}
+	key, _ := snapshot.MapString(raw, "Key")
+	for prefix := range c.limits.PrefixSizes {
+		if strings.HasPrefix(key, prefix) {
+			c.prefixSizes[prefix] += uint64(size)
+		}
+	}
+}
+
+// report returns every limit exceeded by the records added, which totalled
+// total bytes.
+func (c *limitChecker) report(path string, total int) *checkReport {
+	l := c.limits
+	report := &checkReport{Version: checkReportVersion, Path: path, Count: c.count, Size: total, Violations: []checkViolation{}}
+	if l.TotalSize > 0 && uint64(total) > l.TotalSize {
+		report.Violations = append(report.Violations, checkViolation{"max-total-size", "", l.TotalSize, uint64(total)})
+	}
+	prefixes := make([]string, 0, len(l.PrefixSizes))
+	for prefix := range l.PrefixSizes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if c.prefixSizes[prefix] > l.PrefixSizes[prefix] {
+			report.Violations = append(report.Violations, checkViolation{"max-kv-prefix-size", prefix, l.PrefixSizes[prefix], c.prefixSizes[prefix]})
+		}
+	}
+	types := make([]int, 0, len(l.RecordCounts))
+	for msgType := range l.RecordCounts {
+		types = append(types, msgType)
+	}
+	sort.Ints(types)
+	for _, msgType := range types {
+		if c.counts[msgType] > l.RecordCounts[msgType] {
+			report.Violations = append(report.Violations, checkViolation{"max-record-count", typeName(msgType), l.RecordCounts[msgType], c.counts[msgType]})
+		}
+	}
+	report.OK = len(report.Violations) == 0
+	return report
+}
+
+// checkSnapshot reads the snapshot from r and returns every limit it exceeds.
+func checkSnapshot(r io.Reader, path string, l *checkLimits) (*checkReport, error) {
+	c := newLimitChecker(l)
+	total, err := scanSnapshot(r, c.add)
+	if err != nil {
+		return nil, err
+	}
+	return c.report(path, total), nil
+}
+
+// checkCommand implements `check <snapshot>`, which fails when a snapshot
+// exceeds any of the given thresholds so backup pipelines notice runaway
+// growth.
+func checkCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	limitsFlag := checkLimitFlags(fs, "fail")
+	alerts := alertFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool check [options] <snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		limits, err := limitsFlag()
+		if err != nil {
+			fatal(err)
+		}
+
+		r, err := openSnapshot(fs.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		defer r.Close()
+
+		report, err := checkSnapshot(r, fs.Arg(0), limits)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", fs.Arg(0), err))
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fatal(err)
+		}
+		alerts.send(report)
+		if !report.OK {
+			exit(exitThreshold)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a subcommand of the tool.
+type command struct {
+	name     string
+	synopsis string
+	// setup registers the command's flags with fs and returns the function
+	// that runs the command once they've been parsed. It does nothing else
+	// so the flags can be listed without running the command.
+	setup func(fs *flag.FlagSet) func()
+}
+
+// commands are the subcommands in the order they're listed by help.
+var commands []command
+
+func init() {
+	// Set here since some commands refer to commands.
+	commands = []command{
+		{"stats", "print the breakdown by record type and KV prefix (the default)", statsCommand},
+		{"kv", "list the keys under a KV prefix with their sizes", kvCommand},
+		{"grep", "search KV keys and values", grepCommand},
+		{"graph", "write the service mesh intentions as a Graphviz graph", graphCommand},
+		{"diff", "compare the breakdowns of two snapshots", diffCommand},
+		{"trend", "chart growth across a directory of backups", trendCommand},
+		{"watch", "periodically fetch and compare snapshots from a live cluster", watchCommand},
+		{"index-sample", "record the raft index a cluster has reached for estimating record ages", indexSampleCommand},
+		{"verify", "fully decode a snapshot to check it can be restored", verifyCommand},
+		{"check", "check a snapshot against size and count limits", checkCommand},
+		{"audit", "list KV values and config entries that look like credentials or personal data", auditCommand},
+		{"hook", "analyze a backup just taken, keeping a trend and alerting on limits", hookCommand},
+		{"rewrite", "write a copy of a snapshot with records dropped or changed", rewriteCommand},
+		{"sanitize", "write a copy of a snapshot with secrets and values scrubbed", sanitizeCommand},
+		{"merge", "write a snapshot with KV data taken from another", mergeCommand},
+		{"cat-record", "print a single record by number or byte offset", catRecordCommand},
+		{"extract-raw", "write the raw bytes of each record to its own file", extractRawCommand},
+		{"decode", "write a snapshot as JSON lines", decodeCommand},
+		{"encode", "write a snapshot from JSON lines", encodeCommand},
+		{"estimate", "estimate the time and memory restoring a snapshot will take", estimateCommand},
+		{"bench", "measure how fast a snapshot is decoded", benchCommand},
+		{"dashboard", "write a Grafana dashboard for the gauges sent with -statsd-addr", dashboardCommand},
+		{"serve", "serve an HTTP API and page for analyzing snapshots", serveCommand},
+		{"version", "print the version and the newest Consul types understood", versionCommand},
+		{"completion", "write a bash, zsh or fish completion script", completionCommand},
+	}
+}
+
+// subcommands are the words that must follow the names of the commands that
+// have them, before any flags, as in `kv ls`.
+var subcommands = map[string]string{"kv": "ls"}
+
+// flagSet returns the command's flags, including those every command has,
+// and the function that runs it once they're parsed.
+func (c command) flagSet(errorHandling flag.ErrorHandling) (*flag.FlagSet, func()) {
+	fs := flag.NewFlagSet(c.name, errorHandling)
+	run := c.setup(fs)
+	fs.String("config", "", "file to read default flag values from (default ~/"+defaultConfigFile+")")
+	return fs, run
+}
+
+// run runs the command with args, the arguments following its name.
+func (c command) run(args []string) {
+	fs, run := c.flagSet(flag.ExitOnError)
+	if sub := subcommands[c.name]; sub != "" {
+		if len(args) == 0 || args[0] != sub {
+			fs.Usage()
+			exit(exitError)
+		}
+		args = args[1:]
+	}
+	parseFlags(fs, args)
+	run()
+}
+
+// parseFlags parses a command's arguments with fs, after setting defaults from
+// the config file and the environment, and then acts on the input flags.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if err := applyConfig(fs, args); err != nil {
+		fatal(err)
+	}
+	if err := applyEnv(fs); err != nil {
+		fatal(err)
+	}
+	fs.Parse(args)
+	if err := applyInputFlags(fs); err != nil {
+		fatal(err)
+	}
+}
+
+func main() {
+	defer stopProfiling()
+
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+		args[0] = "version"
+	}
+	// With no command, or only flags, stats reads stdin as it always has.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		commands[0].run(args)
+		return
+	}
+
+	name := args[0]
+	help := name == "help"
+	if help {
+		if len(args) < 2 {
+			usage()
+			return
+		}
+		name = args[1]
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if help {
+			fs, _ := c.flagSet(flag.ExitOnError)
+			fs.Usage()
+			return
+		}
+		c.run(args[1:])
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage()
+	exit(exitError)
+}
+
+// flags returns the command's flags without running it. Shared flags such as
+// -strict are bound to globals, which this resets to their defaults, so it
+// mustn't be called once flags have been set.
+func (c command) flags() *flag.FlagSet {
+	fs, _ := c.flagSet(flag.ContinueOnError)
+	return fs
+}
+
+// usage lists the commands.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool <command> [options] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands that read a snapshot take its path, or - for stdin, and accept backup")
+	fmt.Fprintln(os.Stderr, "archives as well as raw state.bin files. With no command, stats reads stdin.")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.synopsis)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'consul-snapshot-tool help <command>' for a command's options.")
+}
+
+// formatFlag registers -format, shared by the commands that can write JSON.
+func formatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", "table", "output format, table or json")
+}
+
+// validFormat returns true if format is one formatFlag accepts.
+func validFormat(format string) bool {
+	return format == "table" || format == "json"
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestCommandFlags(t *testing.T) {
+	for _, c := range commands {
+		fs := c.flags()
+		if fs.Lookup("config") == nil {
+			t.Errorf("%s: no -config flag", c.name)
+		}
+	}
+	names := commandFlagNames()
+	if !names["kv-depth"] || names["no-such-flag"] {
+		t.Error("commandFlagNames doesn't match the commands' flags")
+	}
+}
+
+// runCommand runs the named command with args as if from the command line,
+// without the config file or environment.
+func runCommand(t *testing.T, name string, args ...string) {
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		fs, run := c.flagSet(flag.ContinueOnError)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		run()
+		return
+	}
+	t.Fatalf("no %s command", name)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completionFlag is a flag of a command as completions need it.
+type completionFlag struct {
+	name, usage string
+	isBool      bool
+	// values are the values the flag takes, if there's a fixed set.
+	values []string
+}
+
+// flagValues returns the values to complete for flags that take one of a
+// fixed set.
+func flagValues() map[string][]string {
+	versions := make([]string, 0, len(consulVersions))
+	for _, v := range consulVersions {
+		versions = append(versions, fmt.Sprintf("%d.%d", v.major, v.minor))
+	}
+	return map[string][]string{
+		"format":         {"table", "json"},
+		"report":         reportList(),
+		"consul-version": versions,
+		"product":        productList(),
+	}
+}
+
+// completionFlags returns the flags of the command c, sorted by name.
+func completionFlags(c command) []completionFlag {
+	values := flagValues()
+	var flags []completionFlag
+	c.flags().VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:   f.Name,
+			usage:  f.Usage,
+			isBool: ok && b.IsBoolFlag(),
+			values: values[f.Name],
+		})
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+// completionCommand implements `completion <shell>`, which writes a
+// completion script for bash, zsh or fish generated from the commands and
+// their flags.
+func completionCommand(fs *flag.FlagSet) func() {
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool completion <bash|zsh|fish>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		switch fs.Arg(0) {
+		case "bash":
+			writeBashCompletion(os.Stdout)
+		case "zsh":
+			writeZshCompletion(os.Stdout)
+		case "fish":
+			writeFishCompletion(os.Stdout)
+		default:
+			fs.Usage()
+			exit(exitError)
+		}
+	}
+}
+
+// completionCommands returns every command but completion, which the scripts
+// complete by hand along with help since their arguments aren't files.
+func completionCommands() []command {
+	var cs []command
+	for _, c := range commands {
+		if c.name != "completion" {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func writeBashCompletion(w io.Writer) {
+	cs := completionCommands()
+	names := []string{"help", "completion"}
+	for _, c := range cs {
+		names = append(names, c.name)
+	}
+
+	fmt.Fprintln(w, "# bash completion for consul-snapshot-tool")
+	fmt.Fprintln(w, "_consul_snapshot_tool() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd="${COMP_WORDS[1]}" flags=""`)
+	fmt.Fprintln(w, `	if [ "$COMP_CWORD" -eq 1 ] && [[ "$cur" != -* ]]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, `	case "$cmd" in`)
+	fmt.Fprintln(w, "\t-*) cmd=stats ;;")
+	fmt.Fprintf(w, "\thelp) COMPREPLY=( $(compgen -W %q -- \"$cur\") ); return ;;\n", strings.Join(names[2:], " "))
+	fmt.Fprintln(w, "\tcompletion) COMPREPLY=( $(compgen -W \"bash zsh fish\" -- \"$cur\") ); return ;;")
+	fmt.Fprintln(w, "\tesac")
+
+	values := flagValues()
+	valueNames := make([]string, 0, len(values))
+	for name := range values {
+		valueNames = append(valueNames, name)
+	}
+	sort.Strings(valueNames)
+	fmt.Fprintln(w, `	case "$prev" in`)
+	for _, name := range valueNames {
+		fmt.Fprintf(w, "\t-%s) COMPREPLY=( $(compgen -W %q -- \"$cur\") ); return ;;\n", name, strings.Join(values[name], " "))
+	}
+	fmt.Fprintln(w, "\tesac")
+
+	fmt.Fprintln(w, `	case "$cmd" in`)
+	for _, c := range cs {
+		var flags []string
+		for _, f := range completionFlags(c) {
+			flags = append(flags, "-"+f.name)
+		}
+		fmt.Fprintf(w, "\t%s) flags=%q ;;\n", c.name, strings.Join(flags, " "))
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintln(w, `		COMPREPLY=( $(compgen -W "$flags" -- "$cur") )`)
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, `		COMPREPLY=( $(compgen -f -- "$cur") )`)
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _consul_snapshot_tool consul-snapshot-tool")
+}
+
+// zshQuote escapes s for use in a single quoted zsh _arguments or _describe
+// spec.
+func zshQuote(s string) string {
+	return strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
+
+func writeZshCompletion(w io.Writer) {
+	cs := completionCommands()
+
+	fmt.Fprintln(w, "#compdef consul-snapshot-tool")
+	fmt.Fprintln(w, "_consul_snapshot_tool() {")
+	fmt.Fprintln(w, "\tlocal -a commands")
+	fmt.Fprintln(w, "\tcommands=(")
+	fmt.Fprintln(w, "\t\t'help:show the commands, or a command'\\''s options'")
+	fmt.Fprintln(w, "\t\t'completion:write a shell completion script'")
+	for _, c := range cs {
+		fmt.Fprintf(w, "\t\t'%s:%s'\n", c.name, zshQuote(c.synopsis))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, `	if (( CURRENT == 2 )) && [[ "$words[2]" != -* ]]; then`)
+	fmt.Fprintln(w, "\t\t_describe 'command' commands")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, `	local cmd="$words[2]"`)
+	fmt.Fprintln(w, `	if [[ "$cmd" == -* ]]; then`)
+	fmt.Fprintln(w, "\t\tcmd=stats")
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, "\t\tshift words")
+	fmt.Fprintln(w, "\t\t(( CURRENT-- ))")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, `	case "$cmd" in`)
+	fmt.Fprintln(w, "\thelp) _describe 'command' commands ;;")
+	fmt.Fprintln(w, "\tcompletion) _values 'shell' bash zsh fish ;;")
+	for _, c := range cs {
+		fmt.Fprintf(w, "\t%s)\n\t\t_arguments", c.name)
+		for _, f := range completionFlags(c) {
+			spec := fmt.Sprintf("-%s[%s]", f.name, zshQuote(f.usage))
+			if !f.isBool {
+				spec += ":" + f.name + ":"
+				if len(f.values) > 0 {
+					spec += "(" + strings.Join(f.values, " ") + ")"
+				}
+			}
+			fmt.Fprintf(w, " \\\n\t\t\t'%s'", spec)
+		}
+		fmt.Fprintf(w, " \\\n\t\t\t'*:file:_files'\n\t\t;;\n")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_consul_snapshot_tool "$@"`)
+}
+
+// fishQuote quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func writeFishCompletion(w io.Writer) {
+	cs := completionCommands()
+	names := []string{"help", "completion"}
+	for _, c := range cs {
+		names = append(names, c.name)
+	}
+
+	fmt.Fprintln(w, "# fish completion for consul-snapshot-tool")
+	fmt.Fprintln(w, "complete -c consul-snapshot-tool -f")
+	fmt.Fprintln(w, "complete -c consul-snapshot-tool -n __fish_use_subcommand -a help -d 'show the commands, or a command\\'s options'")
+	fmt.Fprintln(w, "complete -c consul-snapshot-tool -n __fish_use_subcommand -a completion -d 'write a shell completion script'")
+	fmt.Fprintf(w, "complete -c consul-snapshot-tool -n '__fish_seen_subcommand_from help' -a %s\n", fishQuote(strings.Join(names[2:], " ")))
+	fmt.Fprintln(w, "complete -c consul-snapshot-tool -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'")
+	for _, c := range cs {
+		fmt.Fprintf(w, "complete -c consul-snapshot-tool -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.synopsis))
+		cond := fishQuote("__fish_seen_subcommand_from " + c.name)
+		fmt.Fprintf(w, "complete -c consul-snapshot-tool -n %s -F\n", cond)
+		for _, f := range completionFlags(c) {
+			line := fmt.Sprintf("complete -c consul-snapshot-tool -n %s -o %s -d %s", cond, f.name, fishQuote(f.usage))
+			if c.name == "stats" {
+				// stats is the default so its flags can come first.
+				line = fmt.Sprintf("complete -c consul-snapshot-tool -n %s -o %s -d %s", fishQuote("__fish_use_subcommand; or __fish_seen_subcommand_from stats"), f.name, fishQuote(f.usage))
+			}
+			if len(f.values) > 0 {
+				line += " -xa " + fishQuote(strings.Join(f.values, " "))
+			} else if !f.isBool {
+				line += " -r"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
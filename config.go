@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultConfigFile is the config file read from the home directory when
+// -config isn't given.
+const defaultConfigFile = ".dumpsnap.hcl"
+
+// config holds flag defaults read from a config file. Settings at the top
+// level apply to every command with a flag of that name, and those in a block
+// named after a command only to that command:
+//
+//	kv-depth   = 2
+//	kv-exclude = ["vault/", "locks/"]
+//
+//	diff {
+//	  format = "json"
+//	}
+type config struct {
+	settings map[string][]string
+	commands map[string]map[string][]string
+}
+
+// loadConfig reads the config file at path.
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &configParser{src: string(data), line: 1}
+	c := &config{settings: make(map[string][]string), commands: make(map[string]map[string][]string)}
+	if err := p.parseBody(c.settings, c.commands); err != nil {
+		return nil, fmt.Errorf("%s:%d: %s", path, p.line, err)
+	}
+	return c, nil
+}
+
+// configPath returns the config file given with -config in args or
+// DUMPSNAP_CONFIG, or the default one in the home directory. explicit is false
+// for the default, which doesn't have to exist.
+func configPath(args []string) (path string, explicit bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if len(name) == len(arg) {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1], true
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config="), true
+		}
+	}
+	if path := os.Getenv(envName("config")); path != "" {
+		return path, true
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(home, defaultConfigFile), false
+}
+
+// applyConfig sets the flags in fs to the defaults from the config file
+// chosen by args. Flags given on the command line are parsed afterwards so
+// override them, except that repeatable flags add to them.
+func applyConfig(fs *flag.FlagSet, args []string) error {
+	path, explicit := configPath(args)
+	if path == "" {
+		return nil
+	}
+	c, err := loadConfig(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// The other commands' flags are looked up before anything is set since
+	// building them resets the globals shared flags are bound to.
+	known := commandFlagNames()
+	name := strings.Fields(fs.Name())[0]
+	for cmd := range c.commands {
+		if !isCommand(cmd) {
+			return fmt.Errorf("%s: unknown command %q", path, cmd)
+		}
+	}
+	own := c.commands[name]
+	for key, values := range c.settings {
+		if _, ok := own[key]; ok {
+			// The command's own setting takes precedence.
+			continue
+		}
+		if fs.Lookup(key) == nil {
+			if !known[key] {
+				return fmt.Errorf("%s: unknown setting %q", path, key)
+			}
+			continue
+		}
+		if err := setFlag(fs, key, values); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	for key, values := range own {
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("%s: %s has no setting %q", path, name, key)
+		}
+		if err := setFlag(fs, key, values); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// setFlag sets the flag name to each of values in turn.
+func setFlag(fs *flag.FlagSet, name string, values []string) error {
+	for _, v := range values {
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %s", v, name, err)
+		}
+	}
+	return nil
+}
+
+// isCommand returns true if name is one of the commands.
+func isCommand(name string) bool {
+	for _, c := range commands {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// commandFlagNames returns the names of the flags of every command. Like
+// command.flags it resets the globals shared flags are bound to.
+func commandFlagNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, c := range commands {
+		c.flags().VisitAll(func(f *flag.Flag) {
+			names[f.Name] = true
+		})
+	}
+	return names
+}
+
+// configParser parses the subset of HCL config files need: attributes set to
+// strings, numbers, bools or lists of them, and blocks of attributes.
+type configParser struct {
+	src  string
+	line int
+}
+
+// parseBody parses attributes into settings, and blocks into blocks if it's
+// not nil, until the end of the file or a closing brace.
+func (p *configParser) parseBody(settings map[string][]string, blocks map[string]map[string][]string) error {
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if tok == "" || tok == "}" {
+			if tok == "}" && blocks != nil {
+				return errors.New("unexpected }")
+			}
+			if tok == "" && blocks == nil {
+				return errors.New("unexpected end of file, expected }")
+			}
+			return nil
+		}
+		if !isIdent(tok) {
+			return fmt.Errorf("expected a setting name, got %s", describeToken(tok))
+		}
+		name := strings.Replace(tok, "_", "-", -1)
+
+		switch op, err := p.next(); {
+		case err != nil:
+			return err
+		case op == "=":
+			values, err := p.parseValue()
+			if err != nil {
+				return err
+			}
+			settings[name] = values
+		case op == "{" && blocks != nil:
+			block := make(map[string][]string)
+			if err := p.parseBody(block, nil); err != nil {
+				return err
+			}
+			blocks[name] = block
+		default:
+			return fmt.Errorf("expected = after %s", tok)
+		}
+	}
+}
+
+// parseValue parses a value, returning lists as multiple values.
+func (p *configParser) parseValue() ([]string, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok != "[" {
+		v, err := scalarValue(tok)
+		return []string{v}, err
+	}
+	var values []string
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok == "]" {
+			return values, nil
+		}
+		v, err := scalarValue(tok)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if tok, err = p.next(); err != nil {
+			return nil, err
+		}
+		if tok == "]" {
+			return values, nil
+		} else if tok != "," {
+			return nil, fmt.Errorf("expected , or ] in list, got %s", describeToken(tok))
+		}
+	}
+}
+
+// scalarValue returns the value of a string, number or bool token.
+func scalarValue(tok string) (string, error) {
+	switch {
+	case strings.HasPrefix(tok, `"`):
+		return strconv.Unquote(tok)
+	case tok == "true", tok == "false":
+		return tok, nil
+	case tok != "" && (tok[0] == '-' || tok[0] >= '0' && tok[0] <= '9'):
+		return tok, nil
+	}
+	return "", fmt.Errorf("expected a value, got %s", describeToken(tok))
+}
+
+// describeToken describes tok for errors.
+func describeToken(tok string) string {
+	if tok == "" {
+		return "end of file"
+	}
+	return tok
+}
+
+func isIdent(tok string) bool {
+	for i, r := range tok {
+		if !(unicode.IsLetter(r) || r == '_' || i > 0 && (unicode.IsDigit(r) || r == '-')) {
+			return false
+		}
+	}
+	return tok != ""
+}
+
+// next returns the next token, or "" at the end of the file. Tokens are
+// punctuation, quoted strings or runs of anything else, and comments start with
+// # or //.
+func (p *configParser) next() (string, error) {
+	for len(p.src) > 0 {
+		c := p.src[0]
+		switch {
+		case c == '\n':
+			p.line++
+			p.src = p.src[1:]
+		case c == ' ' || c == '\t' || c == '\r':
+			p.src = p.src[1:]
+		case c == '#' || strings.HasPrefix(p.src, "//"):
+			i := strings.IndexByte(p.src, '\n')
+			if i < 0 {
+				i = len(p.src)
+			}
+			p.src = p.src[i:]
+		case strings.IndexByte("=[]{},", c) >= 0:
+			p.src = p.src[1:]
+			return string(c), nil
+		case c == '"':
+			i := 1
+			for ; i < len(p.src) && p.src[i] != '"'; i++ {
+				if p.src[i] == '\\' {
+					i++
+				} else if p.src[i] == '\n' {
+					break
+				}
+			}
+			if i >= len(p.src) || p.src[i] != '"' {
+				return "", errors.New("unterminated string")
+			}
+			tok := p.src[:i+1]
+			p.src = p.src[i+1:]
+			return tok, nil
+		default:
+			i := strings.IndexAny(p.src, " \t\r\n=[]{},#\"")
+			if i < 0 {
+				i = len(p.src)
+			}
+			tok := p.src[:i]
+			p.src = p.src[i:]
+			return tok, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyConfigSharedFlags(t *testing.T) {
+	defer func() { strictTypes = false }()
+	// Settings kv doesn't have are checked against the other commands'
+	// flags, which mustn't undo the settings it does have.
+	path := filepath.Join(t.TempDir(), "config.hcl")
+	config := "strict = true\ntop = 5\nkv-depth = 2\nvault-path = \"vault/\"\nmax-key-length = 100\n"
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range commands {
+		if c.name != "kv" {
+			continue
+		}
+		// Settings are applied in map order, so try a few.
+		for i := 0; i < 10; i++ {
+			strictTypes = false
+			fs, _ := c.flagSet(flag.ContinueOnError)
+			if err := applyConfig(fs, []string{"-config", path}); err != nil {
+				t.Fatal(err)
+			}
+			if !strictTypes {
+				t.Fatal("strict = true was reset by looking up the other commands' flags")
+			}
+		}
+		return
+	}
+	t.Fatal("no kv command")
+}
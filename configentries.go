@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// configEntryStats breaks config entries down by kind and finds the largest
+// individual entries.
+type configEntryStats struct {
+	top int
+
+	kinds   statMap
+	entries statMap
+	total   int
+}
+
+func newConfigEntryStats(c *reportConfig) report {
+	return &configEntryStats{top: c.Top, kinds: make(statMap), entries: make(statMap)}
+}
+
+func (c *configEntryStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "ConfigEntryRequestType" {
+		return
+	}
+	entry := configEntry(val)
+	kind := stringField(entry, "Kind")
+	if kind == "" {
+		kind = "(unknown)"
+	}
+	c.kinds.add(kind, size)
+	c.entries.add(kind+"/"+intentionName(stringField(entry, "Namespace"), stringField(entry, "Name")), size)
+	c.total += size
+}
+
+func (c *configEntryStats) print(w io.Writer) {
+	printStats(w, "Config Entry Kind", c.kinds.slice(), c.total)
+	fmt.Fprintln(w)
+	printTopStats(w, "Config Entry", c.entries.slice(), c.total, c.top)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type testSourceIntention struct {
+	Name   string
+	Action string
+}
+
+type testServiceIntentionsConfigEntry struct {
+	Kind    string
+	Name    string
+	Sources []*testSourceIntention
+	RaftIndex
+}
+
+func (e *testServiceIntentionsConfigEntry) GetKind() string { return e.Kind }
+
+func TestConfigEntryReports(t *testing.T) {
+	snap := testConfigEntrySnapshot(t, testServiceDefaults, &testServiceIntentionsConfigEntry{
+		Kind: "service-intentions",
+		Name: "api",
+		Sources: []*testSourceIntention{
+			{Name: "web", Action: "allow"},
+			{Name: "*", Action: "deny"},
+		},
+		RaftIndex: RaftIndex{CreateIndex: 10, ModifyIndex: 12},
+	})
+
+	c := newConfigEntryStats(&reportConfig{Top: 10}).(*configEntryStats)
+	var is []intention
+	idx := newIndexChecks(11)
+	records := 0
+	_, err := readSnapshot(bytes.NewReader(snap), func(msgType int, val interface{}, size int) {
+		records++
+		c.add(msgType, val, size)
+		is = append(is, recordIntentions(msgType, val)...)
+		idx.add(records, msgType, val)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range []string{"service-defaults", "service-intentions"} {
+		if c.kinds[kind].Count != 1 {
+			t.Errorf("got %d %s entries, want 1", c.kinds[kind].Count, kind)
+		}
+	}
+	if _, ok := c.entries["service-intentions/api"]; !ok {
+		t.Errorf("service-intentions/api missing from %v", c.entries)
+	}
+
+	want := []intention{{"web", "api", "allow"}, {"*", "api", "deny"}}
+	if !reflect.DeepEqual(is, want) {
+		t.Errorf("got intentions %v, want %v", is, want)
+	}
+
+	// Only the intentions were modified after the index the snapshot claims.
+	if idx.afterSnapshot.Count != 1 {
+		t.Errorf("got %d records after the snapshot, want 1", idx.afterSnapshot.Count)
+	}
+}
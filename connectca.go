@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"time"
+)
+
+// connectCAStats summarises the Connect CA: the configured provider, the
+// roots and intermediates it has issued and the provider state stored for it.
+type connectCAStats struct {
+	provider, clusterID string
+	configSize          int
+
+	roots []caRoot
+
+	providerStates int
+	stateSize      int
+}
+
+type caRoot struct {
+	ID, Name string
+	Active   bool
+	Certs    []caCert
+	Size     int
+}
+
+type caCert struct {
+	Kind, Subject       string
+	NotBefore, NotAfter time.Time
+}
+
+func newConnectCAStats(c *reportConfig) report {
+	return &connectCAStats{}
+}
+
+// parseCert returns the subject and validity of a PEM encoded certificate.
+func parseCert(kind, certPEM string) (caCert, bool) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return caCert{}, false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return caCert{}, false
+	}
+	return caCert{
+		Kind:      kind,
+		Subject:   cert.Subject.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}, true
+}
+
+func (c *connectCAStats) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "ConnectCA":
+		root := caRoot{
+			ID:     stringField(val, "ID"),
+			Name:   stringField(val, "Name"),
+			Active: boolField(val, "Active"),
+			Size:   size,
+		}
+		if cert, ok := parseCert("root", stringField(val, "RootCert")); ok {
+			root.Certs = append(root.Certs, cert)
+		} else {
+			// Fall back to what Consul recorded about the root.
+			notBefore, _ := timeField(val, "NotBefore")
+			notAfter, _ := timeField(val, "NotAfter")
+			root.Certs = append(root.Certs, caCert{Kind: "root", Subject: root.Name, NotBefore: notBefore, NotAfter: notAfter})
+		}
+		for _, certPEM := range stringList(field(val, "IntermediateCerts")) {
+			if cert, ok := parseCert("intermediate", certPEM); ok {
+				root.Certs = append(root.Certs, cert)
+			}
+		}
+		c.roots = append(c.roots, root)
+
+	case "ConnectCAProviderState":
+		c.providerStates++
+		c.stateSize += size
+
+	case "ConnectCAConfig":
+		c.provider = stringField(val, "Provider")
+		c.clusterID = stringField(val, "ClusterID")
+		c.configSize = size
+	}
+}
+
+func (c *connectCAStats) print(w io.Writer) {
+	provider := c.provider
+	if provider == "" {
+		provider = "(not configured)"
+	}
+	fmt.Fprintf(w, "Connect CA Provider: %s\n", provider)
+	if c.clusterID != "" {
+		fmt.Fprintf(w, "Cluster ID: %s\n", c.clusterID)
+	}
+	fmt.Fprintf(w, "CA Config: %s\n", ByteSize(uint64(c.configSize)))
+	fmt.Fprintf(w, "Provider State: %d records (%s)\n", c.providerStates, ByteSize(uint64(c.stateSize)))
+
+	fmt.Fprintf(w, "\nRoots: %d\n", len(c.roots))
+	for _, r := range c.roots {
+		active := ""
+		if r.Active {
+			active = ", active"
+		}
+		fmt.Fprintf(w, "  %s %q (%s%s)\n", r.ID, r.Name, ByteSize(uint64(r.Size)), active)
+		for _, cert := range r.Certs {
+			fmt.Fprintf(w, "    %-12s %s\n", cert.Kind, cert.Subject)
+			fmt.Fprintf(w, "    %-12s expires %s\n", "", formatExpiry(cert.NotAfter))
+		}
+	}
+}
+
+// formatExpiry formats a certificate expiry noting if it has already passed.
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	s := t.UTC().Format(time.RFC3339)
+	if t.Before(time.Now()) {
+		s += " (EXPIRED)"
+	}
+	return s
+}
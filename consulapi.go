@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// consulAPI is how to reach a Consul agent's HTTP API, set with the same
+// flags and CONSUL_ environment variables as the Consul CLI.
+type consulAPI struct {
+	addr, token, tokenFile            string
+	caFile, caPath                    string
+	clientCert, clientKey, serverName string
+}
+
+// consulAPIFlags registers the flags for reaching the Consul API.
+func consulAPIFlags(fs *flag.FlagSet) *consulAPI {
+	c := &consulAPI{}
+	fs.StringVar(&c.addr, "http-addr", "http://127.0.0.1:8500", "address of the Consul agent, also set with CONSUL_HTTP_ADDR")
+	fs.StringVar(&c.token, "token", "", "ACL token to use, also set with CONSUL_HTTP_TOKEN")
+	fs.StringVar(&c.tokenFile, "token-file", "", "file containing the ACL token, also set with CONSUL_HTTP_TOKEN_FILE")
+	fs.StringVar(&c.caFile, "ca-file", "", "CA certificate to verify the agent with, also set with CONSUL_CACERT")
+	fs.StringVar(&c.caPath, "ca-path", "", "directory of CA certificates to verify the agent with, also set with CONSUL_CAPATH")
+	fs.StringVar(&c.clientCert, "client-cert", "", "client certificate for TLS, also set with CONSUL_CLIENT_CERT")
+	fs.StringVar(&c.clientKey, "client-key", "", "client key for TLS, also set with CONSUL_CLIENT_KEY")
+	fs.StringVar(&c.serverName, "tls-server-name", "", "server name to verify the agent's certificate against, also set with CONSUL_TLS_SERVER_NAME")
+	return c
+}
+
+// get requests path from the agent, returning an error if the response isn't
+// 200 OK.
+func (c *consulAPI) get(ctx context.Context, path string) (*http.Response, error) {
+	client, base, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	token := c.token
+	if token == "" && c.tokenFile != "" {
+		b, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	if auth := os.Getenv("CONSUL_HTTP_AUTH"); auth != "" {
+		user, pass, _ := strings.Cut(auth, ":")
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// client returns the HTTP client to reach the agent with and the base URL of
+// its API. As with the Consul CLI an address without a scheme uses https if
+// CONSUL_HTTP_SSL is true, and unix:// addresses are sockets.
+func (c *consulAPI) client() (*http.Client, string, error) {
+	addr := strings.TrimSuffix(c.addr, "/")
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		socket := strings.TrimPrefix(addr, "unix://")
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		addr = "http://consul"
+	case !strings.Contains(addr, "://"):
+		scheme := "http://"
+		if ssl, _ := strconv.ParseBool(os.Getenv("CONSUL_HTTP_SSL")); ssl {
+			scheme = "https://"
+		}
+		addr = scheme + addr
+	}
+
+	tlsConfig := &tls.Config{ServerName: c.serverName}
+	if v := os.Getenv("CONSUL_HTTP_SSL_VERIFY"); v != "" {
+		verify, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("CONSUL_HTTP_SSL_VERIFY: %s", err)
+		}
+		tlsConfig.InsecureSkipVerify = !verify
+	}
+	if c.caFile != "" || c.caPath != "" {
+		pool, err := loadCAs(c.caFile, c.caPath)
+		if err != nil {
+			return nil, "", err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.clientCert != "" || c.clientKey != "" {
+		if c.clientCert == "" || c.clientKey == "" {
+			return nil, "", errors.New("-client-cert and -client-key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.clientCert, c.clientKey)
+		if err != nil {
+			return nil, "", err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, addr, nil
+}
+
+// loadCAs returns a pool of the PEM certificates in file and every file in
+// dir.
+func loadCAs(file, dir string) (*x509.CertPool, error) {
+	var files []string
+	if file != "" {
+		files = append(files, file)
+	}
+	if dir != "" {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		pem, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", f)
+		}
+	}
+	return pool, nil
+}
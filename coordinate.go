@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// coordinateStats summarises network coordinates. Each CoordinateBatchUpdate
+// record holds a batch of coordinates so sizes per segment are estimated by
+// re-encoding each coordinate.
+type coordinateStats struct {
+	nodes    map[string]bool
+	segments statMap
+	records  int
+	total    int
+}
+
+func newCoordinateStats(c *reportConfig) report {
+	return &coordinateStats{nodes: make(map[string]bool), segments: make(statMap)}
+}
+
+func (c *coordinateStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "CoordinateBatchUpdate" {
+		return
+	}
+	c.records++
+	c.total += size
+
+	coords, _ := val.([]interface{})
+	for _, coord := range coords {
+		c.nodes[stringField(coord, "Node")] = true
+		segment := stringField(coord, "Segment")
+		if segment == "" {
+			segment = "(default)"
+		}
+		c.segments.add(segment, encodedSize(coord))
+	}
+}
+
+func (c *coordinateStats) print(w io.Writer) {
+	fmt.Fprintf(w, "Coordinates: %d nodes in %d records (%s)\n\n", len(c.nodes), c.records, ByteSize(uint64(c.total)))
+	printStats(w, "Segment", c.segments.slice(), c.total)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// dashboardCommand implements `dashboard`, which writes a Grafana dashboard
+// for the gauges stats, hook and watch send with -statsd-addr -dogstatsd, as
+// Prometheus sees them once they've passed through statsd_exporter.
+func dashboardCommand(fs *flag.FlagSet) func() {
+	prefix := fs.String("statsd-prefix", "consul_snapshot", "prefix the gauges were sent with")
+	title := fs.String("title", "Consul Snapshots", "title of the dashboard")
+	datasource := fs.String("datasource-uid", "", "UID of the Prometheus data source to use, by default Grafana asks for one on import")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool dashboard [options]")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 0 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(newDashboard(*prefix, *title, *datasource)); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// promMetric returns the Prometheus name statsd_exporter gives the gauge
+// name sent with prefix, since its default mapping replaces dots.
+func promMetric(prefix, name string) string {
+	return strings.Replace(prefix+"."+name, ".", "_", -1)
+}
+
+// newDashboard returns the Grafana dashboard model. Without a data source UID
+// it declares an input so Grafana asks which Prometheus to use on import.
+func newDashboard(prefix, title, datasourceUID string) map[string]interface{} {
+	uid := datasourceUID
+	if uid == "" {
+		uid = "${DS_PROMETHEUS}"
+	}
+	ds := map[string]string{"type": "prometheus", "uid": uid}
+
+	target := func(expr, legend string) map[string]interface{} {
+		return map[string]interface{}{"datasource": ds, "expr": expr, "legendFormat": legend, "refId": "A"}
+	}
+	panel := func(id int, typ, title string, x, y, w, h int, expr, legend string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         id,
+			"type":       typ,
+			"title":      title,
+			"datasource": ds,
+			"gridPos":    map[string]int{"x": x, "y": y, "w": w, "h": h},
+			"targets":    []interface{}{target(expr, legend)},
+			"fieldConfig": map[string]interface{}{
+				"defaults":  map[string]interface{}{"unit": "bytes"},
+				"overrides": []interface{}{},
+			},
+		}
+	}
+
+	records := panel(2, "stat", "Records", 6, 0, 6, 4, promMetric(prefix, "records"), "")
+	records["fieldConfig"] = map[string]interface{}{
+		"defaults":  map[string]interface{}{"unit": "short"},
+		"overrides": []interface{}{},
+	}
+	d := map[string]interface{}{
+		"title":         title,
+		"uid":           "consul-snapshots",
+		"schemaVersion": 39,
+		"version":       1,
+		"editable":      true,
+		"refresh":       "5m",
+		"time":          map[string]string{"from": "now-30d", "to": "now"},
+		"tags":          []string{"consul", "snapshots"},
+		"panels": []interface{}{
+			panel(1, "stat", "Snapshot Size", 0, 0, 6, 4, promMetric(prefix, "size"), ""),
+			records,
+			panel(3, "timeseries", "Snapshot Size", 12, 0, 12, 8, promMetric(prefix, "size"), "size"),
+			panel(4, "timeseries", "Size by Record Type", 0, 8, 12, 10, "topk(10, "+promMetric(prefix, "type.size")+")", "{{type}}"),
+			panel(5, "timeseries", "Size by KV Prefix", 12, 8, 12, 10, promMetric(prefix, "kv_prefix.size"), "{{kv_prefix}}"),
+		},
+	}
+	if datasourceUID == "" {
+		d["__inputs"] = []interface{}{map[string]string{
+			"name":     "DS_PROMETHEUS",
+			"label":    "Prometheus",
+			"type":     "datasource",
+			"pluginId": "prometheus",
+		}}
+	}
+	return d
+}
@@ -0,0 +1,44 @@
+package main
+
+// renameDatacenter replaces the datacenter from with to wherever a record
+// names it, returning true if anything changed. Rather than listing every
+// field in every record type it walks the whole record looking for fields
+// called Datacenter, PeerDatacenter or Datacenters, which covers catalog
+// registrations, upstreams, prepared query failover, federation states and
+// network areas. Config entries are opaque binary blobs so they're left alone.
+func renameDatacenter(v interface{}, from, to string) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for k, e := range v {
+			switch k {
+			case "Datacenter", "PeerDatacenter":
+				if e == from {
+					v[k] = to
+					changed = true
+					continue
+				}
+			case "Datacenters":
+				if dcs, ok := e.([]interface{}); ok {
+					for i, dc := range dcs {
+						if dc == from {
+							dcs[i] = to
+							changed = true
+						}
+					}
+					continue
+				}
+			}
+			if renameDatacenter(e, from, to) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if renameDatacenter(e, from, to) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// Records are decoded generically so the tool doesn't depend on Consul's own
+// types. These helpers pull fields out of the resulting maps.
+
+// field returns the value found by following path through nested maps in a
+// decoded record or nil if any part of the path is missing.
+func field(v interface{}, path ...string) interface{} {
+	for _, p := range path {
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[p]
+	}
+	return v
+}
+
+// setField replaces the value at path in a decoded record, returning false if
+// any part of the path is missing.
+func setField(v interface{}, value interface{}, path ...string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	m, ok := field(v, path[:len(path)-1]...).(map[interface{}]interface{})
+	if !ok {
+		return false
+	}
+	if _, ok := m[path[len(path)-1]]; !ok {
+		return false
+	}
+	m[path[len(path)-1]] = value
+	return true
+}
+
+// stringField returns the string at path or "" if it's missing or isn't a
+// string. Byte slices, such as KV values Consul writes as msgpack's binary
+// type, are returned as strings too.
+func stringField(v interface{}, path ...string) string {
+	switch s := field(v, path...).(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+// uintField returns the unsigned integer at path or 0 if it's missing or isn't
+// a number.
+func uintField(v interface{}, path ...string) uint64 {
+	switch n := field(v, path...).(type) {
+	case uint64:
+		return n
+	case int64:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
+
+// boolField returns the bool at path or false if it's missing or isn't a bool.
+func boolField(v interface{}, path ...string) bool {
+	b, _ := field(v, path...).(bool)
+	return b
+}
+
+// encodedSize returns the size of v when encoded as msgpack, which for a part
+// of a decoded record approximates the space it takes up in the snapshot.
+func encodedSize(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	bs, err := snapshot.Encode(v)
+	if err != nil {
+		return 0
+	}
+	return len(bs)
+}
+
+// configEntry returns the entry from a ConfigEntryRequestType record. Consul
+// encodes these with ConfigEntryRequest's MarshalBinary so they usually come
+// back as bytes, or a string from releases that didn't write msgpack's binary
+// type, containing the entry's Kind followed by the whole request, with its
+// Op, Datacenter and Entry, encoded one after the other. The Kind is needed
+// to decode the Entry into the right type in Consul but it's also added to
+// the Entry here in case it's missing.
+func configEntry(val interface{}) interface{} {
+	entry, _ := decodeConfigEntry(val)
+	return entry
+}
+
+// decodeConfigEntry is configEntry returning why the entry couldn't be
+// decoded.
+func decodeConfigEntry(val interface{}) (interface{}, error) {
+	var raw []byte
+	switch v := val.(type) {
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		if entry := field(val, "Entry"); entry != nil {
+			return entry, nil
+		}
+		return nil, fmt.Errorf("expected a config entry request, got %s", kindOf(val))
+	}
+	kind, req, err := snapshot.DecodeConfigEntryRequest(raw)
+	if err != nil {
+		return nil, err
+	}
+	entry := field(req, "Entry")
+	if entry == nil {
+		return nil, fmt.Errorf("request has no Entry")
+	}
+	if m, ok := entry.(map[interface{}]interface{}); ok && m["Kind"] == nil {
+		m["Kind"] = kind
+	}
+	return entry, nil
+}
+
+// timeField returns the time at path. Times are encoded using their
+// MarshalBinary form so they're decoded as strings.
+func timeField(v interface{}, path ...string) (time.Time, bool) {
+	var t time.Time
+	switch tv := field(v, path...).(type) {
+	case time.Time:
+		return tv, true
+	case string:
+		if err := t.UnmarshalBinary([]byte(tv)); err == nil {
+			return t, true
+		}
+	case []byte:
+		if err := t.UnmarshalBinary(tv); err == nil {
+			return t, true
+		}
+	}
+	return t, false
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// consulMsgpackHandle is structs.MsgpackHandle from Consul.
+var consulMsgpackHandle = &codec.MsgpackHandle{
+	RawToString: true,
+	WriteExt:    true,
+	BasicHandle: codec.BasicHandle{
+		DecodeOptions: codec.DecodeOptions{
+			MapType: reflect.TypeOf(map[string]interface{}{}),
+		},
+	},
+}
+
+// The types below mirror the parts of Consul's structs package that config
+// entries are written with, including ConfigEntryRequest's MarshalBinary, so
+// records are encoded here byte for byte as Consul's FSM persists them. The
+// embedded structs are exported, as in Consul, so their fields are flattened.
+
+type RaftIndex struct {
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+type testServiceConfigEntry struct {
+	Kind     string
+	Name     string
+	Protocol string
+	Meta     map[string]string
+	RaftIndex
+}
+
+func (e *testServiceConfigEntry) GetKind() string { return e.Kind }
+
+type WriteRequest struct {
+	Token string
+}
+
+type testConfigEntryRequest struct {
+	Op         string
+	Datacenter string
+	Entry      interface{ GetKind() string }
+	WriteRequest
+}
+
+func (c *testConfigEntryRequest) MarshalBinary() ([]byte, error) {
+	bs := make([]byte, 128)
+	enc := codec.NewEncoderBytes(&bs, consulMsgpackHandle)
+	if err := enc.Encode(c.Entry.GetKind()); err != nil {
+		return nil, err
+	}
+	type Alias testConfigEntryRequest
+	if err := enc.Encode(struct{ *Alias }{Alias: (*Alias)(c)}); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+// testServiceDefaults is the config entry the tests write unless they need
+// a particular kind.
+var testServiceDefaults = &testServiceConfigEntry{
+	Kind:      "service-defaults",
+	Name:      "web",
+	Protocol:  "http",
+	Meta:      map[string]string{"owner": "team-a"},
+	RaftIndex: RaftIndex{CreateIndex: 7, ModifyIndex: 9},
+}
+
+// testDirEntry is structs.DirEntry, which KV entries and, with only the Key
+// and ModifyIndex set, tombstones are written as.
+type testDirEntry struct {
+	LockIndex uint64
+	Key       string
+	Flags     uint64
+	Value     []byte
+	Session   string
+	RaftIndex
+}
+
+// Message types the tests write that the snapshot package has no constant for.
+const (
+	sessionType     = 3
+	tombstoneType   = 5
+	aclTokenSetType = 17
+)
+
+// testRecord is a record for testSnapshot to write.
+type testRecord struct {
+	msgType int
+	val     interface{}
+}
+
+// testSnapshot returns a snapshot with the given LastIndex holding the
+// records encoded the way Consul's FSM persists them.
+func testSnapshot(t *testing.T, lastIndex uint64, records ...testRecord) []byte {
+	var buf bytes.Buffer
+	enc := codec.NewEncoder(&buf, consulMsgpackHandle)
+	if err := enc.Encode(snapshotHeader{LastIndex: lastIndex}); err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		buf.WriteByte(byte(rec.msgType))
+		if err := enc.Encode(rec.val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeTestSnapshot writes a snapshot to a file, returning its path.
+func writeTestSnapshot(t *testing.T, snap []byte) string {
+	path := filepath.Join(t.TempDir(), "state.bin")
+	if err := ioutil.WriteFile(path, snap, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readTestSnapshot returns the decoded records of the snapshot at path.
+func readTestSnapshot(t *testing.T, path string) []testRecord {
+	r, err := openSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var records []testRecord
+	_, err = readSnapshot(r, func(msgType int, val interface{}, size int) {
+		records = append(records, testRecord{msgType, val})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// testConfigEntrySnapshot returns a snapshot holding a record for each of the
+// config entries written the way Consul's FSM persists them.
+func testConfigEntrySnapshot(t *testing.T, entries ...interface{ GetKind() string }) []byte {
+	var records []testRecord
+	for _, entry := range entries {
+		records = append(records, testRecord{snapshot.ConfigEntryType, &testConfigEntryRequest{Op: "upsert", Entry: entry}})
+	}
+	return testSnapshot(t, 42, records...)
+}
+
+func TestConfigEntry(t *testing.T) {
+	s, err := newSnapshotScanner(bytes.NewReader(testConfigEntrySnapshot(t, testServiceDefaults)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgType, val, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != snapshot.ConfigEntryType {
+		t.Fatalf("got type %d, want %d", msgType, snapshot.ConfigEntryType)
+	}
+	if _, ok := val.([]byte); !ok {
+		t.Fatalf("got %T, want the MarshalBinary form as bytes", val)
+	}
+
+	entry := configEntry(val)
+	if entry == nil {
+		t.Fatal("couldn't decode config entry")
+	}
+	for path, want := range map[string]string{
+		"Kind":     "service-defaults",
+		"Name":     "web",
+		"Protocol": "http",
+	} {
+		if got := stringField(entry, path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+	if got := stringField(entry, "Meta", "owner"); got != "team-a" {
+		t.Errorf("Meta.owner: got %q, want %q", got, "team-a")
+	}
+	if got := uintField(entry, "ModifyIndex"); got != 9 {
+		t.Errorf("ModifyIndex: got %d, want 9", got)
+	}
+	if got := recordIdentity(msgType, val); got != "ConfigEntry service-defaults/web" {
+		t.Errorf("recordIdentity: got %q", got)
+	}
+
+	if _, _, err := s.next(); err != io.EOF {
+		t.Fatalf("got %v after the only record, want EOF", err)
+	}
+}
+
+func TestConfigEntryString(t *testing.T) {
+	// Releases before msgpack's binary type was enabled wrote the
+	// MarshalBinary form as a string.
+	req := &testConfigEntryRequest{
+		Op:    "upsert",
+		Entry: &testServiceConfigEntry{Kind: "service-defaults", Name: "api"},
+	}
+	raw, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stringField(configEntry(string(raw)), "Name"); got != "api" {
+		t.Errorf("got Name %q, want %q", got, "api")
+	}
+}
+
+func TestConfigEntryDecodedRequest(t *testing.T) {
+	// Records that aren't in the MarshalBinary form, such as those written by
+	// encode from hand edited JSONL, hold the request as a map.
+	val := map[interface{}]interface{}{
+		"Op":    "upsert",
+		"Entry": map[interface{}]interface{}{"Kind": "proxy-defaults", "Name": "global"},
+	}
+	if got := stringField(configEntry(val), "Name"); got != "global" {
+		t.Errorf("got Name %q, want %q", got, "global")
+	}
+}
+
+func TestStringFieldBinary(t *testing.T) {
+	var buf []byte
+	dirEntry := map[string]interface{}{"Key": "app/config", "Value": []byte("password=hunter2")}
+	if err := codec.NewEncoderBytes(&buf, consulMsgpackHandle).Encode(dirEntry); err != nil {
+		t.Fatal(err)
+	}
+	val, err := snapshot.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stringField(val, "Value"); got != "password=hunter2" {
+		t.Errorf("got Value %q", got)
+	}
+}
+
+func TestConfigEntryJSONL(t *testing.T) {
+	var out bytes.Buffer
+	if err := decodeSnapshot(bytes.NewReader(testConfigEntrySnapshot(t, testServiceDefaults)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`{"$configEntry":{"kind":"service-defaults","request":{`)) ||
+		!bytes.Contains(out.Bytes(), []byte(`"Protocol":"http"`)) {
+		t.Fatalf("config entry not decoded in:\n%s", out.Bytes())
+	}
+
+	var snap bytes.Buffer
+	if err := encodeSnapshot(&out, &snap); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSnapshotScanner(&snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, val, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := configEntry(val)
+	if got := stringField(entry, "Meta", "owner"); got != "team-a" {
+		t.Errorf("Meta.owner: got %q after encoding, want %q", got, "team-a")
+	}
+	if got := uintField(entry, "ModifyIndex"); got != 9 {
+		t.Errorf("ModifyIndex: got %d after encoding, want 9", got)
+	}
+}
+
+func TestEncodeLongLine(t *testing.T) {
+	// Lines longer than 64MB used to fail even though records can be larger.
+	value := strings.Repeat("a", 65*MEGABYTE)
+	var out bytes.Buffer
+	if err := decodeSnapshot(bytes.NewReader(testSnapshot(t, 1, testKV("big", value, 1, 1))), &out); err != nil {
+		t.Fatal(err)
+	}
+	var snap bytes.Buffer
+	if err := encodeSnapshot(&out, &snap); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSnapshotScanner(&snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, val, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stringField(val, "Value"); len(got) != len(value) {
+		t.Errorf("got a %d byte value after encoding, want %d", len(got), len(value))
+	}
+}
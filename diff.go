@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// diffCommand implements `diff <old> <new>` which compares the per type and
+// KV prefix breakdowns of two snapshots.
+func diffCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	var kvExclude stringsFlag
+	kvDepth := fs.Int("kv-depth", 1, "number of path segments to group KV keys by in the prefix breakdown")
+	fs.Var(&kvExclude, "kv-exclude", "KV prefix to leave out of the prefix breakdown (may be repeated)")
+	changes := fs.Bool("changes", false, "list the individual records that were added, modified or removed")
+	top := fs.Int("top", 50, "maximum number of changed records to list, largest first, 0 for all")
+	format := formatFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool diff [options] <old snapshot> <new snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 2 || !validFormat(*format) {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		older, err := summarizeFile(fs.Arg(0), *kvDepth, kvExclude, *changes)
+		if err != nil {
+			fatal(err)
+		}
+		newer, err := summarizeFile(fs.Arg(1), *kvDepth, kvExclude, *changes)
+		if err != nil {
+			fatal(err)
+		}
+
+		if *format == "json" {
+			report := newDiffReport(fs.Arg(0), fs.Arg(1), older, newer, *top)
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				fatal(err)
+			}
+			return
+		}
+
+		printDiff(os.Stdout, "Record Type", older.Types, newer.Types)
+		fmt.Println()
+		printDiff(os.Stdout, "KV Prefix", older.KV.prefixes, newer.KV.prefixes)
+		printPrefixChanges(os.Stdout, older.KV.prefixes, newer.KV.prefixes)
+		if *changes {
+			fmt.Println()
+			printRecordChanges(os.Stdout, older.Records, newer.Records, *top)
+		}
+	}
+}
+
+// snapshotSummary is the per type and KV prefix breakdown of a snapshot.
+type snapshotSummary struct {
+	Types statMap
+	KV    *kvStats
+
+	// Records holds the version of each identifiable record, if requested.
+	Records map[string]recordVersion
+}
+
+// summarizeFile returns the breakdown of the snapshot at path, including the
+// version of every record if records is true.
+func summarizeFile(path string, kvDepth int, kvExclude []string, records bool) (*snapshotSummary, error) {
+	f, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := summarize(f, kvDepth, kvExclude, records)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// summarize returns the breakdown of the snapshot read from r.
+func summarize(r io.Reader, kvDepth int, kvExclude []string, records bool) (*snapshotSummary, error) {
+	s := &snapshotSummary{
+		Types: make(statMap),
+		KV:    newKVStats(kvDepth, kvExclude),
+	}
+	if records {
+		s.Records = make(map[string]recordVersion)
+	}
+	var err error
+	if !records {
+		// Only keys are needed so there's no need to decode records.
+		_, err = scanSnapshot(r, func(msgType int, raw []byte, size int) {
+			s.Types.add(typeName(msgType), size)
+			if typeName(msgType) == "KVS" {
+				key, _ := snapshot.MapString(raw, "Key")
+				s.KV.add(key, size)
+			}
+		})
+	} else {
+		_, err = readSnapshot(r, func(msgType int, val interface{}, size int) {
+			s.Types.add(typeName(msgType), size)
+			if typeName(msgType) == "KVS" {
+				s.KV.add(kvKey(val), size)
+			}
+			if id := recordIdentity(msgType, val); id != "" {
+				s.Records[id] = recordVersion{ModifyIndex: recordModifyIndex(msgType, val), Size: size}
+			}
+		})
+	}
+	return s, err
+}
+
+// prefixChanges returns the sorted names that only exist in newer (added) or
+// only in older (removed).
+func prefixChanges(older, newer statMap) (added, removed []string) {
+	for name := range newer {
+		if _, ok := older[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for name := range older {
+		if _, ok := newer[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// printPrefixChanges lists the prefixes that only exist in one of older or
+// newer.
+func printPrefixChanges(w io.Writer, older, newer statMap) {
+	added, removed := prefixChanges(older, newer)
+
+	if len(added) > 0 {
+		fmt.Fprintln(w, "\nNew prefixes:")
+		for _, name := range added {
+			fmt.Fprintf(w, "  + %s (%d keys, %s)\n", name, newer[name].Count, ByteSize(uint64(newer[name].Sum)))
+		}
+	}
+	if len(removed) > 0 {
+		fmt.Fprintln(w, "\nRemoved prefixes:")
+		for _, name := range removed {
+			fmt.Fprintf(w, "  - %s (%d keys, %s)\n", name, older[name].Count, ByteSize(uint64(older[name].Sum)))
+		}
+	}
+}
+
+// statDiff is the change in a row of stats between two snapshots.
+type statDiff struct {
+	Name     string
+	Old, New typeStats
+}
+
+func (d statDiff) sizeDelta() int  { return d.New.Sum - d.Old.Sum }
+func (d statDiff) countDelta() int { return d.New.Count - d.Old.Count }
+
+// diffStats pairs up the rows of older and newer, ordered by the largest change in
+// size first.
+func diffStats(older, newer statMap) []statDiff {
+	names := make(map[string]bool)
+	for name := range older {
+		names[name] = true
+	}
+	for name := range newer {
+		names[name] = true
+	}
+	diffs := make([]statDiff, 0, len(names))
+	for name := range names {
+		diffs = append(diffs, statDiff{Name: name, Old: older[name], New: newer[name]})
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		di, dj := abs(diffs[i].sizeDelta()), abs(diffs[j].sizeDelta())
+		if di != dj {
+			return di > dj
+		}
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// signedCount formats a change in count with an explicit sign.
+func signedCount(n int) string {
+	if n > 0 {
+		return fmt.Sprintf("+%d", n)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// signedByteSize formats a change in size with an explicit sign.
+func signedByteSize(n int) string {
+	switch {
+	case n > 0:
+		return "+" + ByteSize(uint64(n))
+	case n < 0:
+		return "-" + ByteSize(uint64(-n))
+	}
+	return "0"
+}
+
+// percentChange formats the change from older to newer as a percentage.
+func percentChange(older, newer int) string {
+	if older == 0 {
+		if newer == 0 {
+			return "0%"
+		}
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*float64(newer-older)/float64(older))
+}
+
+func sumStats(m statMap) (count, size int) {
+	for _, s := range m {
+		count += s.Count
+		size += s.Sum
+	}
+	return count, size
+}
+
+// printDiff writes a table comparing older and newer stats.
+func printDiff(w io.Writer, heading string, older, newer statMap) {
+	diffs := diffStats(older, newer)
+
+	width := len(heading)
+	if width < 22 {
+		width = 22
+	}
+	for _, d := range diffs {
+		if len(d.Name) > width {
+			width = len(d.Name)
+		}
+	}
+
+	line := func() {
+		fmt.Fprintf(w, "%s %s %s %s %s %s %s %s\n", strings.Repeat("-", width), strings.Repeat("-", 8), strings.Repeat("-", 8),
+			strings.Repeat("-", 9), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 8))
+	}
+	row := func(name string, oldCount, newCount, oldSize, newSize int) {
+		fmt.Fprintf(w, "% *s % 8d % 8d % 9s % 12s % 12s % 12s % 8s\n", width, name, oldCount, newCount,
+			signedCount(newCount-oldCount), ByteSize(uint64(oldSize)), ByteSize(uint64(newSize)),
+			signedByteSize(newSize-oldSize), percentChange(oldSize, newSize))
+	}
+
+	fmt.Fprintf(w, "% *s % 8s % 8s % 9s % 12s % 12s % 12s % 8s\n", width, heading,
+		"Old", "New", "Change", "Old Size", "New Size", "Change", "%")
+	line()
+	for _, d := range diffs {
+		row(d.Name, d.Old.Count, d.New.Count, d.Old.Sum, d.New.Sum)
+	}
+	line()
+	oldCount, oldSize := sumStats(older)
+	newCount, newSize := sumStats(newer)
+	row("TOTAL:", oldCount, newCount, oldSize, newSize)
+}
+
+// diffReportVersion is bumped whenever a field of diffReport changes meaning
+// or is removed. New fields may be added without bumping it.
+const diffReportVersion = 1
+
+// diffReport is the JSON form of a diff, written by `diff -format json`.
+type diffReport struct {
+	Version         int              `json:"version"`
+	Old             diffSnapshot     `json:"old"`
+	New             diffSnapshot     `json:"new"`
+	Types           []diffRow        `json:"types"`
+	KVPrefixes      []diffRow        `json:"kv_prefixes"`
+	AddedPrefixes   []string         `json:"added_prefixes"`
+	RemovedPrefixes []string         `json:"removed_prefixes"`
+	Changes         []diffRecordJSON `json:"changes,omitempty"`
+}
+
+// diffSnapshot identifies one side of a diff and its totals.
+type diffSnapshot struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+	Size  int    `json:"size"`
+}
+
+// diffRow is the change in count and size of a single record type or KV
+// prefix. PercentChange is null when the row didn't exist in the old snapshot.
+type diffRow struct {
+	Name          string   `json:"name"`
+	OldCount      int      `json:"old_count"`
+	NewCount      int      `json:"new_count"`
+	CountChange   int      `json:"count_change"`
+	OldSize       int      `json:"old_size"`
+	NewSize       int      `json:"new_size"`
+	SizeChange    int      `json:"size_change"`
+	PercentChange *float64 `json:"percent_change"`
+}
+
+// diffRecordJSON is a single changed record, included when -changes is given.
+type diffRecordJSON struct {
+	Change      string `json:"change"`
+	Record      string `json:"record"`
+	Size        int    `json:"size"`
+	ModifyIndex uint64 `json:"modify_index"`
+}
+
+// newDiffReport builds the JSON form of the diff between older and newer,
+// listing at most top changed records.
+func newDiffReport(oldPath, newPath string, older, newer *snapshotSummary, top int) *diffReport {
+	r := &diffReport{
+		Version:    diffReportVersion,
+		Old:        newDiffSnapshot(oldPath, older.Types),
+		New:        newDiffSnapshot(newPath, newer.Types),
+		Types:      diffRows(older.Types, newer.Types),
+		KVPrefixes: diffRows(older.KV.prefixes, newer.KV.prefixes),
+	}
+	r.AddedPrefixes, r.RemovedPrefixes = prefixChanges(older.KV.prefixes, newer.KV.prefixes)
+	if r.AddedPrefixes == nil {
+		r.AddedPrefixes = []string{}
+	}
+	if r.RemovedPrefixes == nil {
+		r.RemovedPrefixes = []string{}
+	}
+	if older.Records != nil {
+		r.Changes = []diffRecordJSON{}
+		for i, c := range recordChanges(older.Records, newer.Records) {
+			if top > 0 && i >= top {
+				break
+			}
+			r.Changes = append(r.Changes, diffRecordJSON{c.Change, c.ID, c.Size, c.ModifyIndex})
+		}
+	}
+	return r
+}
+
+func newDiffSnapshot(path string, types statMap) diffSnapshot {
+	count, size := sumStats(types)
+	return diffSnapshot{Path: path, Count: count, Size: size}
+}
+
+// diffRows converts the diff of older and newer into JSON rows, in the same
+// order as the table.
+func diffRows(older, newer statMap) []diffRow {
+	rows := []diffRow{}
+	for _, d := range diffStats(older, newer) {
+		row := diffRow{
+			Name:        d.Name,
+			OldCount:    d.Old.Count,
+			NewCount:    d.New.Count,
+			CountChange: d.countDelta(),
+			OldSize:     d.Old.Sum,
+			NewSize:     d.New.Sum,
+			SizeChange:  d.sizeDelta(),
+		}
+		if d.Old.Sum > 0 {
+			pct := 100 * float64(d.sizeDelta()) / float64(d.Old.Sum)
+			row.PercentChange = &pct
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
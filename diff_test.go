@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// testDiffSummaries returns the summaries of two snapshots where app/a is
+// modified, app/b removed, new/c added and a tombstone added.
+func testDiffSummaries(t *testing.T, records bool) (older, newer *snapshotSummary) {
+	o := testSnapshot(t, 40,
+		testRecord{snapshot.RegisterType, map[string]interface{}{"Node": "node-1"}},
+		testKV("app/a", "v1", 5, 10),
+		testKV("app/b", "v", 6, 6),
+	)
+	n := testSnapshot(t, 60,
+		testRecord{snapshot.RegisterType, map[string]interface{}{"Node": "node-1"}},
+		testKV("app/a", "v2-longer", 5, 45),
+		testTombstone("app/b", 50),
+		testKV("new/c", "v", 55, 55),
+	)
+	var err error
+	if older, err = summarize(bytes.NewReader(o), 1, nil, records); err != nil {
+		t.Fatal(err)
+	}
+	if newer, err = summarize(bytes.NewReader(n), 1, nil, records); err != nil {
+		t.Fatal(err)
+	}
+	return older, newer
+}
+
+func TestDiffTable(t *testing.T) {
+	older, newer := testDiffSummaries(t, false)
+	if older.Records != nil {
+		t.Error("got records without asking for them")
+	}
+
+	var out strings.Builder
+	printDiff(&out, "Record Type", older.Types, newer.Types)
+	printPrefixChanges(&out, older.KV.prefixes, newer.KV.prefixes)
+	lines := strings.Split(out.String(), "\n")
+	row := func(name string) []string {
+		for _, l := range lines {
+			if f := strings.Fields(l); len(f) > 0 && f[0] == name {
+				return f
+			}
+		}
+		t.Fatalf("no %s row in:\n%s", name, out.String())
+		return nil
+	}
+	if f := row("KVS"); f[1] != "2" || f[2] != "2" || f[3] != "0" {
+		t.Errorf("got KVS row %q, want 2 before and after", f)
+	}
+	if f := row("Tombstone"); f[1] != "0" || f[2] != "1" || f[3] != "+1" || f[len(f)-1] != "new" {
+		t.Errorf("got Tombstone row %q, want one new", f)
+	}
+	if f := row("Register"); f[3] != "0" || f[len(f)-1] != "+0.0%" {
+		t.Errorf("got Register row %q, want no change", f)
+	}
+	if !strings.Contains(out.String(), "New prefixes:\n  + new/") {
+		t.Errorf("new/ isn't listed as a new prefix in:\n%s", out.String())
+	}
+}
+
+func TestDiffChanges(t *testing.T) {
+	older, newer := testDiffSummaries(t, true)
+	var out strings.Builder
+	printRecordChanges(&out, older.Records, newer.Records, 0)
+	if !strings.Contains(out.String(), "Changed Records: 1 added, 1 modified, 1 removed") {
+		t.Errorf("got:\n%s", out.String())
+	}
+	for _, want := range [][]string{
+		{"modified", "45", "KVS app/a"},
+		{"added", "55", "KVS new/c"},
+		{"removed", "6", "KVS app/b"},
+	} {
+		found := false
+		for _, l := range strings.Split(out.String(), "\n") {
+			f := strings.Fields(l)
+			if len(f) == 5 && f[0] == want[0] && f[2] == want[1] && f[3]+" "+f[4] == want[2] {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no %s row for %s at index %s in:\n%s", want[0], want[2], want[1], out.String())
+		}
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	older, newer := testDiffSummaries(t, true)
+	b, err := json.Marshal(newDiffReport("old.snap", "new.snap", older, newer, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r struct {
+		Version int
+		Old     struct{ Path string }
+		New     struct {
+			Path  string
+			Count int
+		}
+		Types []struct {
+			Name          string
+			OldCount      int      `json:"old_count"`
+			NewCount      int      `json:"new_count"`
+			PercentChange *float64 `json:"percent_change"`
+		}
+		AddedPrefixes   []string `json:"added_prefixes"`
+		RemovedPrefixes []string `json:"removed_prefixes"`
+		Changes         []struct {
+			Change      string
+			Record      string
+			ModifyIndex uint64 `json:"modify_index"`
+		}
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != diffReportVersion || diffReportVersion != 1 {
+		t.Errorf("got version %d, want 1", r.Version)
+	}
+	if r.Old.Path != "old.snap" || r.New.Path != "new.snap" || r.New.Count != 4 {
+		t.Errorf("got old %+v, new %+v", r.Old, r.New)
+	}
+	tombstones := false
+	for _, row := range r.Types {
+		if row.Name != "Tombstone" {
+			continue
+		}
+		tombstones = true
+		if row.OldCount != 0 || row.NewCount != 1 || row.PercentChange != nil {
+			t.Errorf("got Tombstone row %+v, want a new row with no percentage", row)
+		}
+	}
+	if !tombstones {
+		t.Errorf("no Tombstone row in %+v", r.Types)
+	}
+	if len(r.AddedPrefixes) != 1 || r.AddedPrefixes[0] != "new/" || r.RemovedPrefixes == nil || len(r.RemovedPrefixes) != 0 {
+		t.Errorf("got added %q, removed %q", r.AddedPrefixes, r.RemovedPrefixes)
+	}
+	// Only the top 2 changes, largest first.
+	if len(r.Changes) != 2 || r.Changes[0].Record != "KVS app/a" || r.Changes[0].ModifyIndex != 45 {
+		t.Errorf("got changes %+v, want app/a first and only 2", r.Changes)
+	}
+
+	// Without -changes there's no changes field at all.
+	older, newer = testDiffSummaries(t, false)
+	if b, _ := json.Marshal(newDiffReport("a", "b", older, newer, 0)); bytes.Contains(b, []byte(`"changes"`)) {
+		t.Errorf("got changes without -changes: %s", b)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -8,15 +10,11 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/banks/consul-snapshot-tool/snapshot"
 )
 
 // snapshotHeader is the first entry in our snapshot
-type snapshotHeader struct {
-	// LastIndex is the last index that affects the data.
-	// This is used when we do the restore for watchers.
-	LastIndex uint64
-}
+type snapshotHeader = snapshot.Header
 
 type typeStats struct {
 	Name       string
@@ -31,131 +29,225 @@ func (s statSlice) Len() int { return len(s) }
 func (s statSlice) Less(i, j int) bool { return s[i].Sum > s[j].Sum }
 func (s statSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 
-var typeNames []string
-
-func init() {
-	// These mirror the const values from
-	// https://github.com/hashicorp/consul/blob/master/agent/structs/structs.go#L37-L70
-	// (line numbers may change but I want to link to master so it shows most recent 
-	// constants).
-	typeNames = []string{
-		"Register",
-		"Deregister",
-		"KVS",
-		"Session",
-		"ACL (Deprecated)",
-		"Tombstone",
-		"CoordinateBatchUpdate",
-		"PreparedQuery",
-		"Txn",
-		"Autopilot",
-		"Area",
-		"ACLBootstrap",
-		"Intention",
-		"ConnectCA",
-		"ConnectCAProviderState",
-		"ConnectCAConfig",
-		"Index",
-		"ACLTokenSet",
-		"ACLTokenDelete",
-		"ACLPolicySet",
-		"ACLPolicyDelete",
-		"ConnectCALeafRequestType",
-		"ConfigEntryRequestType",
-		"ACLRoleSetRequestType",
-		"ACLRoleDeleteRequestType",
-		"ACLBindingRuleSetRequestType",
-		"ACLBindingRuleDeleteRequestType",
-		"ACLAuthMethodSetRequestType",
-		"ACLAuthMethodDeleteRequestType",
-		"ChunkingStateType",
-	}
-}
-
-type countingReader struct {
-	r    io.Reader
-	read int
-}
-
-func (r *countingReader) Read(p []byte) (n int, err error) {
-	n, err = r.r.Read(p)
-	if err == nil {
-		r.read += n
-	}
-	return n, err
-}
-
-func main() {
-
-	// msgpackHandle is a shared handle for encoding/decoding msgpack payloads
-	var msgpackHandle = &codec.MsgpackHandle{
-		RawToString: true,
-	}
-
-	stats := make(map[int]typeStats)
-
-	cr := &countingReader{r: os.Stdin}
-
-	dec := codec.NewDecoder(cr, msgpackHandle)
-
-	// Read in the header
-	var header snapshotHeader
-	if err := dec.Decode(&header); err != nil {
-		panic(err)
-	}
-
-	// Populate the new state
-	msgType := make([]byte, 1)
-	offset := 0
-	for {
-		// Read the message type
-		_, err := cr.Read(msgType)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic(err)
-		}
+// statMap accumulates stats by name.
+type statMap map[string]typeStats
 
-		// Decode
-		s := stats[int(msgType[0])]
-		if s.Name == "" {
-			s.Name = typeNames[int(msgType[0])]
-		}
+func (m statMap) add(name string, size int) {
+	s := m[name]
+	s.Name = name
+	s.Sum += size
+	s.Count++
+	m[name] = s
+}
+
+func (m statMap) slice() statSlice {
+	ss := make(statSlice, 0, len(m))
+	for _, s := range m {
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// typeNames is the table of known message types, which -product replaces and
+// -consul-version can shorten. Empty names are gaps in the table.
+var typeNames = append([]string(nil), snapshot.TypeNames...)
+
+// typeName returns the name of a message type, including names given with
+// -type-map. Types added by Consul versions newer than the table above are
+// named Unknown(<N>) so they're still counted separately rather than being
+// mistaken for a known type.
+func typeName(msgType int) string {
+	if name, ok := typeOverrides[msgType]; ok {
+		return name
+	}
+	if msgType >= 0 && msgType < len(typeNames) && typeNames[msgType] != "" {
+		return typeNames[msgType]
+	}
+	return fmt.Sprintf("Unknown(%d)", msgType)
+}
 
-		var val interface{}
+// stringsFlag is a flag.Value that can be given multiple times, collecting each
+// value in order.
+type stringsFlag []string
 
-		err = dec.Decode(&val)
-		if err != nil {
-			panic(err)
-		}
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
 
-		// See how big it was
-		size := cr.read - offset
+// skippedKey stands in for the keys of records too large to read.
+const skippedKey = "(skipped)"
+
+// newReport returns the type and KV prefix breakdown of a snapshot in its
+// exported form.
+func newReport(stats map[int]typeStats, kv *kvStats, total int) *snapshot.Report {
+	rep := &snapshot.Report{
+		Version:    snapshot.ReportVersion,
+		Size:       total,
+		Types:      []snapshot.TypeStat{},
+		KVPrefixes: []snapshot.PrefixStat{},
+	}
+	for msgType, s := range stats {
+		rep.Records += s.Count
+		rep.Types = append(rep.Types, snapshot.TypeStat{Type: msgType, Name: s.Name, Count: s.Count, Size: s.Sum})
+	}
+	for _, s := range kv.prefixes {
+		rep.KVPrefixes = append(rep.KVPrefixes, snapshot.PrefixStat{Prefix: s.Name, Count: s.Count, Size: s.Sum, Samples: kv.samples[s.Name]})
+	}
+	if kv.sample > 1 {
+		rep.Sample = kv.sample
+	}
+	rep.Sort()
+	return rep
+}
+
+// snapshotScanner reads the records of a snapshot one at a time, wrapping a
+// snapshot.Reader with the tool's flags and error messages.
+type snapshotScanner struct {
+	r *snapshot.Reader
+
+	header snapshotHeader
+	// headerRaw is the encoded header.
+	headerRaw []byte
+
+	// last is the encoding of the last record read, including its type.
+	last []byte
+	// size is the encoded size of the last record read and offset the total
+	// number of bytes read so far.
+	size, offset int
+	// records is the number of records read so far.
+	records int
+
+	// skipLarge skips records larger than maxRecordBytes, which nextRaw
+	// returns without an encoding, rather than failing. Only callers that can
+	// make do without some records should set it.
+	skipLarge bool
+}
+
+// newSnapshotScanner reads the header of the snapshot in r.
+func newSnapshotScanner(r io.Reader) (*snapshotScanner, error) {
+	return newSnapshotScannerContext(context.Background(), r)
+}
 
-		s.Sum += size
-		s.Count++
-		offset += size
+// newSnapshotScannerContext is like newSnapshotScanner but reading fails once
+// ctx is done.
+func newSnapshotScannerContext(ctx context.Context, r io.Reader) (*snapshotScanner, error) {
+	if product == "vault" {
+		return nil, errVaultStats
+	}
+	sr, err := snapshot.OpenContext(ctx, r)
+	if err != nil {
+		return nil, scanError(err)
+	}
+	return &snapshotScanner{r: sr, header: sr.Header(), headerRaw: sr.RawHeader(), offset: sr.Offset()}, nil
+}
 
-		stats[int(msgType[0])] = s
+// nextRaw returns the message type and encoding of the next record without
+// decoding it, or io.EOF once there are no more. Other errors are
+// *snapshotErrors. The encoding is only valid until nextRaw is called again,
+// and is nil if the record was skipped for being too large.
+func (s *snapshotScanner) nextRaw() (int, []byte, error) {
+	s.r.MaxRecordBytes = int(maxRecordBytes)
+	s.r.SkipLarge = s.skipLarge && !strictTypes
+	rec, err := s.r.Next()
+	if err == io.EOF {
+		return 0, nil, err
+	} else if err != nil {
+		return 0, nil, scanError(err)
 	}
 
-	// Output stats in size-order
-	ss := make(statSlice, 0, len(stats))
+	if strictTypes && !knownType(rec.Type) {
+		return 0, nil, &snapshotError{Offset: rec.Offset, Record: rec.Ordinal,
+			Err: fmt.Errorf("unknown record type %d, name it with -type-map to continue", rec.Type)}
+	}
 
-	for _, s := range stats {
-		ss = append(ss, s)
+	s.last, s.size, s.offset, s.records = rec.Raw, rec.Size, s.r.Offset(), rec.Ordinal
+	if rec.Skipped() {
+		fmt.Fprintf(os.Stderr, "Skipping record %d (%s) at offset %d: %s\n", s.records, typeName(rec.Type), rec.Offset, errRecordTooLarge)
+		return rec.Type, nil, nil
+	}
+	return rec.Type, rec.Body(), nil
+}
+
+// next returns the message type and decoded value of the next record, or
+// io.EOF once there are no more. Other errors are *snapshotErrors.
+func (s *snapshotScanner) next() (int, interface{}, error) {
+	msgType, raw, err := s.nextRaw()
+	if err != nil {
+		return 0, nil, err
+	}
+	if raw == nil {
+		return msgType, nil, nil
+	}
+	val, err := snapshot.Decode(raw)
+	if err != nil {
+		return 0, nil, &snapshotError{Offset: s.offset - s.size, Record: s.records, Type: typeName(msgType), Err: err}
+	}
+	return msgType, val, nil
+}
+
+// scanError converts an error from snapshot.Reader into a *snapshotError
+// naming types and limits the way the rest of the tool does.
+func scanError(err error) error {
+	var e *snapshot.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	se := &snapshotError{Offset: e.Offset, Record: e.Record, IO: e.IO, Err: e.Err}
+	if e.Type >= 0 {
+		se.Type = typeName(e.Type)
+	}
+	if se.Err == snapshot.ErrRecordTooLarge {
+		se.Err = errRecordTooLarge
 	}
+	return se
+}
+
+// raw returns the encoded bytes of the last record read, including the message
+// type. It is only valid until the next record is read.
+func (s *snapshotScanner) raw() []byte {
+	return s.last
+}
+
+// printStats writes a table of stats in size-order, followed by the total size.
+func printStats(w io.Writer, heading string, ss statSlice, total int) {
+	printTopStats(w, heading, ss, total, 0)
+}
 
+// printTopStats is like printStats but only lists the top largest rows,
+// folding the rest into a single row. A top of zero lists every row.
+func printTopStats(w io.Writer, heading string, ss statSlice, total, top int) {
 	// Sort the stat slice
 	sort.Sort(ss)
 
-	fmt.Printf("% 22s % 8s % 12s\n", "Record Type", "Count", "Total Size")
-	fmt.Printf("%s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 8), strings.Repeat("-", 12))
+	if top > 0 && len(ss) > top {
+		rest := typeStats{Name: fmt.Sprintf("(%d others)", len(ss)-top)}
+		for _, s := range ss[top:] {
+			rest.Sum += s.Sum
+			rest.Count += s.Count
+		}
+		ss = append(ss[:top:top], rest)
+	}
+
+	width := len(heading)
+	if width < 22 {
+		width = 22
+	}
+	for _, s := range ss {
+		if len(s.Name) > width {
+			width = len(s.Name)
+		}
+	}
+
+	fmt.Fprintf(w, "% *s % 8s % 12s\n", width, heading, "Count", "Total Size")
+	fmt.Fprintf(w, "%s %s %s\n", strings.Repeat("-", width), strings.Repeat("-", 8), strings.Repeat("-", 12))
 	for _, s := range ss {
-		fmt.Printf("% 22s % 8d % 12s\n", s.Name, s.Count, ByteSize(uint64(s.Sum)))
+		fmt.Fprintf(w, "% *s % 8d % 12s\n", width, s.Name, s.Count, ByteSize(uint64(s.Sum)))
 	}
-	fmt.Printf("%s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 8), strings.Repeat("-", 12))
-	fmt.Printf("%s % 8s % 12s\n", strings.Repeat(" ", 22), "TOTAL:", ByteSize(uint64(offset)))
+	fmt.Fprintf(w, "%s %s %s\n", strings.Repeat("-", width), strings.Repeat("-", 8), strings.Repeat("-", 12))
+	fmt.Fprintf(w, "%s % 8s % 12s\n", strings.Repeat(" ", width), "TOTAL:", ByteSize(uint64(total)))
 }
 
 const (
@@ -167,11 +259,13 @@ const (
 )
 
 // ByteSize returns a human-readable byte string of the form 10M, 12.5K, and so forth.  The following units are available:
+//
 //	T: Terabyte
 //	G: Gigabyte
 //	M: Megabyte
 //	K: Kilobyte
 //	B: Byte
+//
 // The unit that results in the smallest number greater than or equal to 1 is always chosen.
 // From https://github.com/cloudfoundry/bytefmt/blob/master/bytes.go
 func ByteSize(bytes uint64) string {
@@ -201,3 +295,42 @@ func ByteSize(bytes uint64) string {
 	result = strings.TrimSuffix(result, ".0")
 	return result + unit
 }
+
+// ParseByteSize parses a size like those returned by ByteSize, e.g. 12.5KB or
+// 2GB. A number without a unit is a number of bytes.
+func ParseByteSize(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := uint64(BYTE)
+	for _, u := range []struct {
+		suffix string
+		mult   uint64
+	}{
+		{"TB", TERABYTE}, {"GB", GIGABYTE}, {"MB", MEGABYTE}, {"KB", KILOBYTE},
+		{"T", TERABYTE}, {"G", GIGABYTE}, {"M", MEGABYTE}, {"K", KILOBYTE}, {"B", BYTE},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(value * float64(mult)), nil
+}
+
+// byteSizeFlag is a flag.Value accepting sizes parsed by ParseByteSize.
+type byteSizeFlag uint64
+
+func (f *byteSizeFlag) String() string { return ByteSize(uint64(*f)) }
+
+func (f *byteSizeFlag) Set(v string) error {
+	size, err := ParseByteSize(v)
+	if err != nil {
+		return err
+	}
+	*f = byteSizeFlag(size)
+	return nil
+}
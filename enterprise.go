@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// segmentMetaKey is the node meta key Consul Enterprise records a node's
+// network segment under.
+const segmentMetaKey = "consul-network-segment"
+
+// enterpriseTypeNames are the names the enterprise report looks for. Consul
+// Enterprise doesn't publish its type numbers, so the tool doesn't name these
+// types itself and they need naming with -type-map.
+var enterpriseTypeNames = []string{
+	"NamespaceUpsertRequestType",
+	"PartitionUpsertRequestType",
+	"LicenseRequestType",
+}
+
+// enterpriseStats summarises the state only Consul Enterprise writes: admin
+// partitions, namespaces, licenses and the network segments nodes belong to.
+type enterpriseStats struct {
+	top int
+
+	// partitions and namespaces map names to true if they're being deleted.
+	// Both are deleted in the background so can linger in snapshots.
+	partitions map[string]bool
+	namespaces map[string]bool
+	// partitionNamespaces counts the namespaces in each partition.
+	partitionNamespaces statMap
+	licenses            typeStats
+	segments            statMap
+	segmentTotal        int
+}
+
+func newEnterpriseStats(c *reportConfig) report {
+	return &enterpriseStats{
+		top:                 c.Top,
+		partitions:          make(map[string]bool),
+		namespaces:          make(map[string]bool),
+		partitionNamespaces: make(statMap),
+		segments:            make(statMap),
+	}
+}
+
+func (e *enterpriseStats) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "PartitionUpsertRequestType":
+		e.partitions[stringField(val, "Name")] = field(val, "DeletedAt") != nil
+	case "NamespaceUpsertRequestType":
+		partition := stringField(val, "Partition")
+		e.namespaces[tenantName(partition, stringField(val, "Name"))] = field(val, "DeletedAt") != nil
+		if partition == "" {
+			partition = "default"
+		}
+		e.partitionNamespaces.add(partition, size)
+	case "LicenseRequestType":
+		e.licenses.Count++
+		e.licenses.Sum += size
+	case "Register":
+		if field(val, "Service") != nil || field(val, "Check") != nil {
+			// Only count each node once.
+			return
+		}
+		segment, _ := field(val, "NodeMeta", segmentMetaKey).(string)
+		if segment == "" {
+			segment = "(default)"
+		}
+		e.segments.add(segment, size)
+		e.segmentTotal += size
+	}
+}
+
+// deleting returns the sorted names in m that are being deleted.
+func deleting(m map[string]bool) []string {
+	var names []string
+	for name, deleted := range m {
+		if deleted {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enterpriseTypesNamed returns true if -type-map names any of the types the
+// enterprise report looks for.
+func enterpriseTypesNamed() bool {
+	for _, name := range typeOverrides {
+		for _, want := range enterpriseTypeNames {
+			if name == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *enterpriseStats) print(w io.Writer) {
+	if !enterpriseTypesNamed() {
+		// Without the types there's nothing to count, rather than none.
+		fmt.Fprintf(w, "Name the Enterprise types with -type-map to count admin partitions, namespaces and licenses: %s\n", strings.Join(enterpriseTypeNames, ", "))
+	} else {
+		fmt.Fprintf(w, "Admin partitions: %d\n", len(e.partitions))
+		for _, name := range deleting(e.partitions) {
+			fmt.Fprintf(w, "  %s is being deleted\n", name)
+		}
+		fmt.Fprintf(w, "Namespaces: %d\n", len(e.namespaces))
+		for _, name := range deleting(e.namespaces) {
+			fmt.Fprintf(w, "  %s is being deleted\n", name)
+		}
+		fmt.Fprintf(w, "Licenses: %d (%s)\n", e.licenses.Count, ByteSize(uint64(e.licenses.Sum)))
+	}
+	if len(e.partitionNamespaces) > 0 {
+		fmt.Fprintln(w)
+		_, total := sumStats(e.partitionNamespaces)
+		printTopStats(w, "Partition Namespaces", e.partitionNamespaces.slice(), total, e.top)
+	}
+	if len(e.segments) > 0 {
+		fmt.Fprintln(w)
+		printTopStats(w, "Segment Nodes", e.segments.slice(), e.segmentTotal, e.top)
+	}
+}
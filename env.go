@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the environment variables that set flags, e.g.
+// DUMPSNAP_KV_DEPTH for -kv-depth.
+const envPrefix = "DUMPSNAP_"
+
+// consulEnv are the variables the Consul CLI reads that set our flags of the
+// same meaning, so operators' existing environments work unchanged. The
+// DUMPSNAP_ variable for a flag takes precedence over these.
+var consulEnv = map[string]string{
+	"http-addr":       "CONSUL_HTTP_ADDR",
+	"token":           "CONSUL_HTTP_TOKEN",
+	"token-file":      "CONSUL_HTTP_TOKEN_FILE",
+	"ca-file":         "CONSUL_CACERT",
+	"ca-path":         "CONSUL_CAPATH",
+	"client-cert":     "CONSUL_CLIENT_CERT",
+	"client-key":      "CONSUL_CLIENT_KEY",
+	"tls-server-name": "CONSUL_TLS_SERVER_NAME",
+}
+
+// envName returns the DUMPSNAP_ variable for the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// applyEnv sets the flags in fs from the environment. It's applied after the
+// config file and before the command line, so overrides the one and is
+// overridden by the other. Repeatable flags get a single value.
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		name := envName(f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok && consulEnv[f.Name] != "" {
+			name = consulEnv[f.Name]
+			v, ok = os.LookupEnv(name)
+		}
+		if !ok || v == "" {
+			return
+		}
+		if serr := fs.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("%s: invalid value %q for %s: %s", name, v, f.Name, serr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes. flag exits with 2 when given bad arguments so that's avoided.
+const (
+	exitError   = 1
+	exitIO      = 3
+	exitCorrupt = 4
+	// exitThreshold is used by check when a snapshot exceeds a limit, and by
+	// audit when it finds something sensitive.
+	exitThreshold = 5
+)
+
+// errRecordTooLarge is the error for records larger than -max-record-bytes.
+var errRecordTooLarge = errors.New("record is larger than -max-record-bytes")
+
+// snapshotError describes where reading a snapshot failed.
+type snapshotError struct {
+	// Offset is the byte offset of the start of the record that failed.
+	Offset int
+	// Record is the ordinal of the record, starting at 1, or 0 for the
+	// header.
+	Record int
+	// Type is the record's type if it was read before the failure.
+	Type string
+	// IO is true if reading the snapshot failed rather than decoding it.
+	IO  bool
+	Err error
+}
+
+func (e *snapshotError) Error() string {
+	what := "header"
+	if e.Record > 0 {
+		what = fmt.Sprintf("record %d", e.Record)
+		if e.Type != "" {
+			what += " (" + e.Type + ")"
+		}
+	}
+	problem := "failed to decode"
+	if e.IO {
+		problem = "failed to read"
+	} else if e.Err == io.ErrUnexpectedEOF || e.Err == io.EOF {
+		problem = "snapshot is truncated in"
+	}
+	return fmt.Sprintf("%s %s at offset %d: %s", problem, what, e.Offset, e.Err)
+}
+
+// exitCode returns the exit code for err, telling I/O errors apart from
+// corrupt snapshots.
+func exitCode(err error) int {
+	var snapErr *snapshotError
+	var pathErr *os.PathError
+	switch {
+	case errors.As(err, &snapErr):
+		if snapErr.IO {
+			return exitIO
+		}
+		return exitCorrupt
+	case errors.As(err, &pathErr):
+		return exitIO
+	}
+	return exitError
+}
+
+// fatal prints err and exits with the matching exit code.
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exit(exitCode(err))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// estimateReportVersion is bumped whenever a field of restoreEstimate changes
+// meaning or is removed. New fields may be added without bumping it.
+const estimateReportVersion = 1
+
+// restoreEstimate is the JSON written by `estimate -format json`. Times are in
+// seconds.
+type restoreEstimate struct {
+	Version     int    `json:"version"`
+	Path        string `json:"path"`
+	Records     int    `json:"records"`
+	Size        int    `json:"size"`
+	ArchiveSize int    `json:"archive_size"`
+	Servers     int    `json:"servers"`
+	// Phases are the steps of a restore in the order they happen.
+	Phases []restorePhase `json:"phases"`
+	// Types is the time the FSM spends restoring each type of record.
+	Types  []restoreType   `json:"types"`
+	Total  float64         `json:"total"`
+	Memory *memoryEstimate `json:"memory"`
+}
+
+type restorePhase struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Seconds     float64 `json:"seconds"`
+}
+
+type restoreType struct {
+	Name    string  `json:"name"`
+	Count   int     `json:"count"`
+	Size    int     `json:"size"`
+	Seconds float64 `json:"seconds"`
+	// Memory is the heap the type's records take once restored.
+	Memory int `json:"memory"`
+}
+
+// memoryEstimate is the memory a server needs to restore the snapshot, in
+// bytes.
+type memoryEstimate struct {
+	// Heap is the live heap once the snapshot is restored.
+	Heap int `json:"heap"`
+	// Peak is the live heap while restoring, when the state being replaced
+	// is still held until the restored state is swapped in.
+	Peak int `json:"peak"`
+	// RSS is the resident memory needed at the peak once the garbage
+	// collector's headroom is allowed for.
+	RSS  int `json:"rss"`
+	GOGC int `json:"gogc"`
+}
+
+// estimateCommand implements `estimate <snapshot>`, which estimates how long
+// restoring a backup will take so a maintenance window can be planned from
+// the backup alone. Decoding is measured by decoding every record one at a
+// time as Consul's FSM does, so the estimate reflects the snapshot's actual
+// content, and the rest is worked out from the given throughputs. The memory
+// a server needs is modeled from each type's records as memoryCosts describes.
+func estimateCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	network := byteSizeFlag(100 * MEGABYTE)
+	fs.Var(&network, "network-throughput", "bytes per second the backup can be uploaded to the leader and sent on to followers at")
+	disk := byteSizeFlag(200 * MEGABYTE)
+	fs.Var(&disk, "disk-throughput", "bytes per second servers can write snapshots to disk at")
+	servers := fs.Int("servers", 3, "number of servers in the cluster, all but the leader are sent the snapshot once it's restored")
+	indexFactor := fs.Float64("index-factor", 2, "time the FSM spends indexing each record relative to decoding it")
+	cpuFactor := fs.Float64("cpu-factor", 1, "how many times slower the servers' CPUs are than this machine's")
+	current := byteSizeFlag(0)
+	fs.Var(&current, "current-state", "heap taken by the state the restore replaces (default the same as the restored state)")
+	gogc := fs.Int("gogc", 100, "GOGC the servers run with")
+	format := formatFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool estimate [options] <snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 || *servers < 1 || *gogc < 0 || network == 0 || disk == 0 || !validFormat(*format) {
+			fs.Usage()
+			exit(exitError)
+		}
+		path := fs.Arg(0)
+
+		est, err := estimateRestore(path, *servers, float64(network), float64(disk), *indexFactor, *cpuFactor)
+		if err != nil {
+			fatal(err)
+		}
+		est.Memory = estimateMemory(est.Types, int(current), *gogc)
+		if *format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(est); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		printEstimate(os.Stdout, est)
+	}
+}
+
+// estimateRestore reads the snapshot at path, timing how long each record
+// takes to decode, and estimates each phase of restoring it.
+func estimateRestore(path string, servers int, network, disk, indexFactor, cpuFactor float64) (*restoreEstimate, error) {
+	archiveSize := 0
+	if path != "-" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		archiveSize = int(info.Size())
+	}
+	r, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	est := &restoreEstimate{Version: estimateReportVersion, Path: path, Servers: servers, Phases: []restorePhase{}}
+	decodeTimes := make(map[string]time.Duration)
+	types := make(statMap)
+	s, err := newSnapshotScanner(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for {
+		start := time.Now()
+		msgType, _, err := s.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		name := typeName(msgType)
+		decodeTimes[name] += time.Since(start)
+		types.add(name, s.size)
+	}
+	est.Size = s.offset
+	est.Records, _ = sumStats(types)
+	if archiveSize == 0 {
+		archiveSize = est.Size
+	}
+	est.ArchiveSize = archiveSize
+
+	// The FSM decodes and indexes every record.
+	var fsm float64
+	for name, t := range types {
+		secs := decodeTimes[name].Seconds() * (1 + indexFactor) * cpuFactor
+		est.Types = append(est.Types, restoreType{
+			Name:    name,
+			Count:   t.Count,
+			Size:    t.Sum,
+			Seconds: secs,
+			Memory:  memoryCostOf(name).heapBytes(t.Count, t.Sum),
+		})
+		fsm += secs
+	}
+	sort.Slice(est.Types, func(i, j int) bool {
+		if est.Types[i].Seconds != est.Types[j].Seconds {
+			return est.Types[i].Seconds > est.Types[j].Seconds
+		}
+		return est.Types[i].Name < est.Types[j].Name
+	})
+
+	size := float64(est.Size)
+	add := func(name, description string, secs float64) {
+		est.Phases = append(est.Phases, restorePhase{name, description, secs})
+		est.Total += secs
+	}
+	add("upload", fmt.Sprintf("upload the backup to the leader at %s/s", ByteSize(uint64(network))), float64(archiveSize)/network)
+	add("write", fmt.Sprintf("leader writes the snapshot to disk at %s/s", ByteSize(uint64(disk))), size/disk)
+	add("fsm-restore", "leader decodes and indexes every record", fsm)
+	if followers := servers - 1; followers > 0 {
+		// The leader sends the snapshot to every follower at once, sharing
+		// its bandwidth, and they then restore it in parallel.
+		add("install", fmt.Sprintf("send the snapshot to %d followers, which write and restore it", followers),
+			size*float64(followers)/network+size/disk+fsm)
+	}
+	return est, nil
+}
+
+// estimateMemory estimates the memory a server needs to restore records of
+// types, replacing state taking current bytes of heap, or the same as the
+// restored state if current is 0.
+func estimateMemory(types []restoreType, current, gogc int) *memoryEstimate {
+	state := 0
+	for _, t := range types {
+		state += t.Memory
+	}
+	if current == 0 {
+		current = state
+	}
+	m := &memoryEstimate{Heap: baseHeapBytes + state, Peak: baseHeapBytes + state + current, GOGC: gogc}
+	m.RSS = m.Peak + m.Peak*gogc/100
+	return m
+}
+
+// formatSeconds formats a duration given in seconds for estimates, which
+// don't deserve more precision than this.
+func formatSeconds(secs float64) string {
+	d := time.Duration(secs * float64(time.Second))
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+func printEstimate(w io.Writer, est *restoreEstimate) {
+	fmt.Fprintf(w, "Estimated restore of %s (%s, %d records) to %d servers\n\n", est.Path, ByteSize(uint64(est.Size)), est.Records, est.Servers)
+	fmt.Fprintf(w, "% 12s % 10s  %s\n", "Phase", "Time", "")
+	fmt.Fprintf(w, "%s %s\n", strings.Repeat("-", 12), strings.Repeat("-", 10))
+	for _, p := range est.Phases {
+		fmt.Fprintf(w, "% 12s % 10s  %s\n", p.Name, formatSeconds(p.Seconds), p.Description)
+	}
+	fmt.Fprintf(w, "%s %s\n", strings.Repeat("-", 12), strings.Repeat("-", 10))
+	fmt.Fprintf(w, "% 12s % 10s\n\n", "TOTAL:", formatSeconds(est.Total))
+
+	if m := est.Memory; m != nil {
+		fmt.Fprintf(w, "Heap after restore:   %s\n", ByteSize(uint64(m.Heap)))
+		fmt.Fprintf(w, "Heap while restoring: %s\n", ByteSize(uint64(m.Peak)))
+		fmt.Fprintf(w, "Memory needed:        %s (GOGC=%d)\n\n", ByteSize(uint64(m.RSS)), m.GOGC)
+	}
+
+	fmt.Fprintf(w, "% 22s % 8s % 12s % 10s % 12s\n", "Record Type", "Count", "Total Size", "Restore", "Memory")
+	fmt.Fprintf(w, "%s %s %s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 8), strings.Repeat("-", 12), strings.Repeat("-", 10), strings.Repeat("-", 12))
+	for _, t := range est.Types {
+		fmt.Fprintf(w, "% 22s % 8d % 12s % 10s % 12s\n", t.Name, t.Count, ByteSize(uint64(t.Size)), formatSeconds(t.Seconds), ByteSize(uint64(t.Memory)))
+	}
+}
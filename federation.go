@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// federationStats breaks federation states down by datacenter, showing which
+// datacenters in a WAN federated deployment push the most federation data.
+type federationStats struct {
+	dcs []federationState
+}
+
+type federationState struct {
+	Datacenter   string
+	MeshGateways int
+	UpdatedAt    time.Time
+	Size         int
+}
+
+func newFederationStats(c *reportConfig) report {
+	return &federationStats{}
+}
+
+func (f *federationStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "FederationStateRequestType" {
+		return
+	}
+	state := field(val, "State")
+	gateways, _ := field(state, "MeshGateways").([]interface{})
+	updated, _ := timeField(state, "UpdatedAt")
+	f.dcs = append(f.dcs, federationState{
+		Datacenter:   stringField(state, "Datacenter"),
+		MeshGateways: len(gateways),
+		UpdatedAt:    updated,
+		Size:         size,
+	})
+}
+
+func (f *federationStats) print(w io.Writer) {
+	sort.Slice(f.dcs, func(i, j int) bool { return f.dcs[i].Size > f.dcs[j].Size })
+
+	total := 0
+	fmt.Fprintf(w, "% 22s % 13s % 12s %s\n", "Datacenter", "Mesh Gateways", "Size", "Updated")
+	fmt.Fprintf(w, "%s %s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 13), strings.Repeat("-", 12), strings.Repeat("-", 20))
+	for _, dc := range f.dcs {
+		updated := "unknown"
+		if !dc.UpdatedAt.IsZero() {
+			updated = dc.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "% 22s % 13d % 12s %s\n", dc.Datacenter, dc.MeshGateways, ByteSize(uint64(dc.Size)), updated)
+		total += dc.Size
+	}
+	fmt.Fprintf(w, "%s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 13), strings.Repeat("-", 12))
+	fmt.Fprintf(w, "%s % 13s % 12s\n", strings.Repeat(" ", 22), "TOTAL:", ByteSize(uint64(total)))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// graphCommand implements `graph` which writes the services in the catalog and
+// the intentions between them as a Graphviz DOT graph.
+func graphCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool graph [snapshot] | dot -Tsvg > mesh.svg")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() > 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+		path := "-"
+		if fs.NArg() == 1 {
+			path = fs.Arg(0)
+		}
+
+		g := newIntentionGraph()
+		in, err := openSnapshot(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer in.Close()
+		if _, err := readSnapshot(in, g.add); err != nil {
+			fatal(err)
+		}
+		g.write(os.Stdout)
+	}
+}
+
+// intentionGraph collects the allowed and denied service-to-service
+// communication captured in a snapshot.
+type intentionGraph struct {
+	services map[string]bool
+	edges    map[[2]string]string
+}
+
+func newIntentionGraph() *intentionGraph {
+	return &intentionGraph{
+		services: make(map[string]bool),
+		edges:    make(map[[2]string]string),
+	}
+}
+
+func (g *intentionGraph) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) == "Register" {
+		svc := field(val, "Service")
+		if svc != nil && stringField(svc, "Kind") == "" {
+			g.services[intentionName(stringField(svc, "Namespace"), stringField(svc, "Service"))] = true
+		}
+	}
+
+	for _, in := range recordIntentions(msgType, val) {
+		g.edges[[2]string{in.Source, in.Destination}] = in.Action
+	}
+}
+
+func (g *intentionGraph) write(w io.Writer) {
+	fmt.Fprintln(w, "digraph intentions {")
+
+	names := make([]string, 0, len(g.services))
+	for name := range g.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s;\n", dotQuote(name))
+	}
+
+	edges := make([][2]string, 0, len(g.edges))
+	for e := range g.edges {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+	for _, e := range edges {
+		action := g.edges[e]
+		style := `color="darkgreen"`
+		switch action {
+		case "deny":
+			style = `color="red", style="dashed"`
+		case "permissions":
+			style = `color="blue"`
+		}
+		fmt.Fprintf(w, "  %s -> %s [label=%s, %s];\n", dotQuote(e[0]), dotQuote(e[1]), dotQuote(action), style)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// dotQuote quotes s as a DOT string. Unlike %q it only escapes quotes and
+// backslashes, since DOT doesn't understand Go's other escapes and would show
+// them literally.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDotQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"web":          `"web"`,
+		`say "hi"`:     `"say \"hi\""`,
+		`back\slash`:   `"back\\slash"`,
+		"café/api":     `"café/api"`,
+		"tab\tnewline": "\"tab\tnewline\"",
+	} {
+		if got := dotQuote(s); got != want {
+			t.Errorf("dotQuote(%q): got %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestIntentionGraphWrite(t *testing.T) {
+	g := newIntentionGraph()
+	g.services["café"] = true
+	g.services[`ns\web`] = true
+	g.edges[[2]string{"café", `ns\web`}] = "deny"
+
+	var out strings.Builder
+	g.write(&out)
+	for _, want := range []string{
+		`  "café";`,
+		`  "ns\\web";`,
+		`  "café" -> "ns\\web" [label="deny", color="red", style="dashed"];`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("no %s in:\n%s", want, out.String())
+		}
+	}
+}
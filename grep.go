@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// grepCommand implements `grep <pattern>` which lists the KV entries whose key
+// (and optionally value) matches pattern.
+func grepCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	fixed := fs.Bool("F", false, "treat the pattern as a fixed string rather than a regular expression")
+	ignoreCase := fs.Bool("i", false, "match case insensitively")
+	values := fs.Bool("values", false, "also search the decoded values")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool grep [options] <pattern> [snapshot]")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() < 1 || fs.NArg() > 2 {
+			fs.Usage()
+			exit(exitError)
+		}
+		path := "-"
+		if fs.NArg() == 2 {
+			path = fs.Arg(1)
+		}
+
+		pattern := fs.Arg(0)
+		if *fixed {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		if *ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid pattern: %s\n", err)
+			exit(exitError)
+		}
+
+		fmt.Printf("% 12s % 12s % 12s % 5s %s\n", "Size", "CreateIndex", "ModifyIndex", "Match", "Key")
+		matches := 0
+		in, err := openSnapshot(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer in.Close()
+		_, err = readSnapshot(in, func(msgType int, val interface{}, size int) {
+			if typeName(msgType) != "KVS" {
+				return
+			}
+			key := kvKey(val)
+			match := ""
+			if re.MatchString(key) {
+				match = "key"
+			} else if *values && re.MatchString(stringField(val, "Value")) {
+				match = "value"
+			}
+			if match == "" {
+				return
+			}
+			matches++
+			fmt.Printf("% 12s % 12d % 12d % 5s %q\n", ByteSize(uint64(size)),
+				uintField(val, "CreateIndex"), uintField(val, "ModifyIndex"), match, key)
+		})
+		if err != nil {
+			fatal(err)
+		}
+		if matches == 0 {
+			exit(1)
+		}
+	}
+}
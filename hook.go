@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// hookCommand implements `hook <backup>`, meant to be run by whatever saves
+// backups, such as a wrapper around snapshot agent or a cron job, as soon as a
+// backup is written. It analyzes the backup, appends its breakdown to a
+// rolling trend store, prints what changed since the previous backup and
+// raises alerts for any limits exceeded, so every backup is checked as part of
+// taking it.
+func hookCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	store := fs.String("store", "consul-snapshot-trend.jsonl", "file each breakdown is appended to")
+	keep := fs.Int("keep", 90, "number of breakdowns to keep in the store, 0 to keep all")
+	var kvExclude stringsFlag
+	kvDepth := fs.Int("kv-depth", 1, "number of path segments to group KV keys by in the prefix breakdown")
+	fs.Var(&kvExclude, "kv-exclude", "KV prefix to leave out of the prefix breakdown (may be repeated)")
+	limitsFlag := checkLimitFlags(fs, "alert")
+	var maxGrowth byteSizeFlag
+	fs.Var(&maxGrowth, "max-growth", "alert if the snapshot grew by more than this since the previous backup, e.g. 100MB")
+	maxPrefixGrowth := fs.Int("max-kv-prefix-growth", 0, "alert if a KV prefix, grouped by -kv-depth, grew by more than this percentage since the previous backup, 0 for no limit")
+	statsd := statsdFlags(fs)
+	alerts := alertFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool hook [options] <backup>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+		path := fs.Arg(0)
+
+		limits, err := limitsFlag()
+		if err != nil {
+			fatal(err)
+		}
+		prev, err := lastTrendPoint(*store)
+		if err != nil {
+			fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fatal(err)
+		}
+
+		// The breakdown and limits are worked out in one pass over the backup.
+		s := &snapshotSummary{Types: make(statMap), KV: newKVStats(*kvDepth, kvExclude)}
+		checker := newLimitChecker(limits)
+		r, err := openSnapshot(path)
+		if err != nil {
+			fatal(err)
+		}
+		total, err := scanSnapshot(r, func(msgType int, raw []byte, size int) {
+			s.Types.add(typeName(msgType), size)
+			if typeName(msgType) == "KVS" {
+				key, _ := snapshot.MapString(raw, "Key")
+				s.KV.add(key, size)
+			}
+			checker.add(msgType, raw, size)
+		})
+		r.Close()
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", path, err))
+		}
+
+		point := &trendPoint{Time: backupTime(info), Types: s.Types, KV: s.KV.prefixes}
+		if err := appendTrendPoint(*store, point); err != nil {
+			fatal(err)
+		}
+		if err := trimTrendStore(*store, *keep); err != nil {
+			fatal(err)
+		}
+		printWatch(os.Stdout, prev, point)
+		count, size := sumStats(point.Types)
+		statsd.send(count, size, point.Types.slice(), point.KV.slice())
+
+		report := checker.report(path, total)
+		if prev != nil && maxGrowth > 0 {
+			_, prevSize := sumStats(prev.Types)
+			if growth := size - prevSize; growth > 0 && uint64(growth) > uint64(maxGrowth) {
+				report.Violations = append(report.Violations, checkViolation{"max-growth", "", uint64(maxGrowth), uint64(growth)})
+				report.OK = false
+			}
+		}
+		if prev != nil && *maxPrefixGrowth > 0 {
+			report.Violations = append(report.Violations, prefixGrowth(prev.KV, point.KV, *maxPrefixGrowth)...)
+			report.OK = len(report.Violations) == 0
+		}
+		printAlerts(os.Stderr, report)
+		alerts.send(report)
+		if !report.OK {
+			exit(exitThreshold)
+		}
+	}
+}
+
+// prefixGrowth returns a violation for each KV prefix that grew by more than
+// limit percent from prev to cur. Prefixes that are new have no percentage so
+// are left to the size limits.
+func prefixGrowth(prev, cur statMap, limit int) []checkViolation {
+	var vs []checkViolation
+	for _, s := range cur.slice() {
+		before := prev[s.Name].Sum
+		if before == 0 || s.Sum <= before {
+			continue
+		}
+		if growth := (s.Sum - before) * 100 / before; growth > limit {
+			vs = append(vs, checkViolation{"max-kv-prefix-growth", s.Name, uint64(limit), uint64(growth)})
+		}
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i].Subject < vs[j].Subject })
+	return vs
+}
+
+// trimTrendStore drops all but the last keep points from the trend store, if
+// keep is more than 0.
+func trimTrendStore(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= keep {
+		return nil
+	}
+
+	// Write the kept points to a new file and rename it over the store so
+	// it's never left half written.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	// TempFile creates the file readable only by us.
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, line := range lines[len(lines)-keep:] {
+		w.Write(line)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// indexChecks looks for raft indexes that can't be right: records modified
+// after the snapshot was taken, or modified before they were created. Either
+// suggests corruption or state pieced together from more than one cluster.
+type indexChecks struct {
+	lastIndex uint64
+
+	afterSnapshot anomaly
+	beforeCreate  anomaly
+}
+
+func newIndexChecks(lastIndex uint64) *indexChecks {
+	return &indexChecks{lastIndex: lastIndex}
+}
+
+// add checks the indexes of the record with the given ordinal.
+func (c *indexChecks) add(record, msgType int, val interface{}) {
+	name := typeName(msgType)
+	desc := recordIdentity(msgType, val)
+	if desc == "" {
+		desc = name
+	}
+	desc = fmt.Sprintf("record %d: %s", record, desc)
+
+	switch name {
+	case "Register":
+		// The node itself has no indexes in the snapshot, only its service
+		// or check.
+		for _, f := range []string{"Service", "Check"} {
+			if v := field(val, f); v != nil {
+				c.check(desc, uintField(v, "CreateIndex"), uintField(v, "ModifyIndex"))
+			}
+		}
+	case "ConfigEntryRequestType":
+		entry := configEntry(val)
+		c.check(desc, uintField(entry, "CreateIndex"), uintField(entry, "ModifyIndex"))
+	case "Index":
+		// The last index each table was written at.
+		c.check(fmt.Sprintf("record %d: Index %s", record, stringField(val, "Key")), 0, uintField(val, "Value"))
+	default:
+		c.check(desc, uintField(val, "CreateIndex"), uintField(val, "ModifyIndex"))
+	}
+}
+
+func (c *indexChecks) check(desc string, create, modify uint64) {
+	if c.lastIndex > 0 && (create > c.lastIndex || modify > c.lastIndex) {
+		c.afterSnapshot.add(fmt.Sprintf("%s (CreateIndex %d, ModifyIndex %d)", desc, create, modify))
+	}
+	if modify > 0 && create > modify {
+		c.beforeCreate.add(fmt.Sprintf("%s (CreateIndex %d, ModifyIndex %d)", desc, create, modify))
+	}
+}
+
+// count returns the number of anomalies found.
+func (c *indexChecks) count() int {
+	return c.afterSnapshot.Count + c.beforeCreate.Count
+}
+
+func (c *indexChecks) print(w io.Writer) {
+	if c.count() == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Raft Index Anomalies")
+	c.afterSnapshot.printRecords(w, fmt.Sprintf("with indexes after the snapshot's last index %d", c.lastIndex))
+	c.beforeCreate.printRecords(w, "modified before they were created")
+}
+
+// printRecords is like print but for examples that already describe the record.
+func (a *anomaly) printRecords(w io.Writer, desc string) {
+	if a.Count == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %d records %s, e.g.:\n", a.Count, desc)
+	for _, ex := range a.Examples {
+		fmt.Fprintf(w, "    %s\n", truncate(ex, 120))
+	}
+}
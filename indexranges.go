@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// indexRange is the span of raft indexes the records of one type were created
+// and last modified at.
+type indexRange struct {
+	Name    string
+	Records int
+	// Unindexed counts records without indexes, such as catalog nodes,
+	// which are left out of the ranges.
+	Unindexed            int
+	MinCreate, MaxCreate uint64
+	MinModify, MaxModify uint64
+}
+
+func (r *indexRange) add(create, modify uint64) {
+	r.Records++
+	if create == 0 && modify == 0 {
+		r.Unindexed++
+		return
+	}
+	if create > 0 && (r.MinCreate == 0 || create < r.MinCreate) {
+		r.MinCreate = create
+	}
+	if create > r.MaxCreate {
+		r.MaxCreate = create
+	}
+	if modify > 0 && (r.MinModify == 0 || modify < r.MinModify) {
+		r.MinModify = modify
+	}
+	if modify > r.MaxModify {
+		r.MaxModify = modify
+	}
+}
+
+// indexRangeStats reports the raft index ranges of each record type, showing
+// which subsystems have been written recently and which haven't been touched
+// for millions of indexes, which helps decide what's safe to clean up.
+type indexRangeStats struct {
+	top     int
+	kvDepth int
+	times   indexTimes
+
+	types map[string]*indexRange
+	// kvPrefixes are the ranges of the KV entries under each prefix.
+	kvPrefixes map[string]*indexRange
+	// lastIndex is the highest index seen in any record.
+	lastIndex uint64
+}
+
+func newIndexRangeStats(c *reportConfig) report {
+	return &indexRangeStats{
+		top:        c.Top,
+		kvDepth:    c.KVDepth,
+		times:      c.IndexTimes,
+		types:      make(map[string]*indexRange),
+		kvPrefixes: make(map[string]*indexRange),
+	}
+}
+
+// recordIndexes returns the raft indexes a record was created and last
+// modified at, or zeros if it has none.
+func recordIndexes(msgType int, val interface{}) (create, modify uint64) {
+	switch typeName(msgType) {
+	case "Register":
+		// The node itself has no indexes in the snapshot, only its service
+		// or check.
+		for _, f := range []string{"Service", "Check"} {
+			if v := field(val, f); v != nil {
+				return uintField(v, "CreateIndex"), uintField(v, "ModifyIndex")
+			}
+		}
+		return 0, 0
+	case "ConfigEntryRequestType":
+		entry := configEntry(val)
+		return uintField(entry, "CreateIndex"), uintField(entry, "ModifyIndex")
+	case "Index":
+		return 0, uintField(val, "Value")
+	}
+	return uintField(val, "CreateIndex"), uintField(val, "ModifyIndex")
+}
+
+// rangeFor returns the range named name in m, adding it if needed.
+func rangeFor(m map[string]*indexRange, name string) *indexRange {
+	r, ok := m[name]
+	if !ok {
+		r = &indexRange{Name: name}
+		m[name] = r
+	}
+	return r
+}
+
+func (s *indexRangeStats) add(msgType int, val interface{}, size int) {
+	name := typeName(msgType)
+	create, modify := recordIndexes(msgType, val)
+	rangeFor(s.types, name).add(create, modify)
+	if name == "KVS" {
+		rangeFor(s.kvPrefixes, kvPrefix(kvKey(val), s.kvDepth)).add(create, modify)
+	}
+	if modify > s.lastIndex {
+		s.lastIndex = modify
+	}
+	if create > s.lastIndex {
+		s.lastIndex = create
+	}
+}
+
+// formatRange formats an index range, or "-" if there were no indexes.
+func formatRange(min, max uint64) string {
+	if max == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d-%d", min, max)
+}
+
+// stalest returns the ranges in m with those modified longest ago first.
+func stalest(m map[string]*indexRange) []*indexRange {
+	ranges := make([]*indexRange, 0, len(m))
+	for _, r := range m {
+		ranges = append(ranges, r)
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].MaxModify != ranges[j].MaxModify {
+			return ranges[i].MaxModify < ranges[j].MaxModify
+		}
+		return ranges[i].Name < ranges[j].Name
+	})
+	return ranges
+}
+
+// idle describes how long ago a range was last modified: its estimated age if
+// there are index samples, otherwise the number of indexes since.
+func (s *indexRangeStats) idle(r *indexRange) string {
+	if r.MaxModify == 0 {
+		return "-"
+	}
+	if s.times != nil {
+		if age := s.times.age(r.MaxModify); age != "" {
+			return age + " ago"
+		}
+	}
+	return fmt.Sprint(s.lastIndex - r.MaxModify)
+}
+
+func (s *indexRangeStats) print(w io.Writer) {
+	heading := "Idle"
+	if s.times != nil {
+		heading = "Last Modified"
+	}
+	fmt.Fprintf(w, "Raft Index Ranges (latest index %d)\n", s.lastIndex)
+	fmt.Fprintf(w, "% 28s % 8s % 23s % 23s % 16s\n", "Record Type", "Records", "CreateIndex", "ModifyIndex", heading)
+	fmt.Fprintf(w, "%s %s %s %s %s\n", strings.Repeat("-", 28), strings.Repeat("-", 8), strings.Repeat("-", 23), strings.Repeat("-", 23), strings.Repeat("-", 16))
+	unindexed := 0
+	for _, r := range stalest(s.types) {
+		fmt.Fprintf(w, "% 28s % 8d % 23s % 23s % 16s\n", r.Name, r.Records,
+			formatRange(r.MinCreate, r.MaxCreate), formatRange(r.MinModify, r.MaxModify), s.idle(r))
+		unindexed += r.Unindexed
+	}
+	if s.times == nil {
+		fmt.Fprintln(w, "Idle is the number of indexes since a record of the type was last modified.")
+	}
+	if unindexed > 0 {
+		fmt.Fprintf(w, "%d records without indexes, such as catalog nodes, aren't included in the ranges.\n", unindexed)
+	}
+
+	if len(s.kvPrefixes) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "KV Prefixes Modified Longest Ago")
+	fmt.Fprintf(w, "% 40s % 8s % 23s % 16s\n", "Prefix", "Keys", "ModifyIndex", heading)
+	fmt.Fprintf(w, "%s %s %s %s\n", strings.Repeat("-", 40), strings.Repeat("-", 8), strings.Repeat("-", 23), strings.Repeat("-", 16))
+	for i, r := range stalest(s.kvPrefixes) {
+		if s.top > 0 && i == s.top {
+			fmt.Fprintf(w, "% 40s\n", fmt.Sprintf("(%d others)", len(s.kvPrefixes)-s.top))
+			break
+		}
+		fmt.Fprintf(w, "% 40s % 8d % 23s % 16s\n", truncate(r.Name, 40), r.Records, formatRange(r.MinModify, r.MaxModify), s.idle(r))
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexTime is a sample of the raft index a cluster had reached at a time.
+type indexTime struct {
+	Index uint64
+	Time  time.Time
+}
+
+// indexTimes maps raft indexes to the times they were written at, estimated
+// from samples. Raft indexes say nothing about time on their own so without
+// samples all that can be said of a record is how many writes ago it changed.
+type indexTimes []indexTime
+
+// loadIndexTimes reads samples from a file with one "<index> <RFC 3339 time>"
+// pair per line, as written by `index-sample`. Blank lines and lines starting
+// with # are ignored.
+func loadIndexTimes(path string) (indexTimes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var times indexTimes
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.Fields(text)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<index> <time>\"", path, line)
+		}
+		index, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid index %q", path, line, parts[0])
+		}
+		t, err := time.Parse(time.RFC3339, parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid time %q, expected RFC 3339 e.g. 2024-01-02T15:04:05Z", path, line, parts[1])
+		}
+		times = append(times, indexTime{index, t})
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Index < times[j].Index })
+	if len(times) < 2 || times[0].Index == times[len(times)-1].Index {
+		return nil, fmt.Errorf("%s: at least two samples at different indexes are needed to estimate times", path)
+	}
+	return times, nil
+}
+
+// timeAt estimates when index was written by interpolating between the
+// samples either side of it, or extrapolating from the nearest two if it's
+// outside them. It returns false if there aren't enough samples.
+func (ts indexTimes) timeAt(index uint64) (time.Time, bool) {
+	if len(ts) < 2 {
+		return time.Time{}, false
+	}
+	i := sort.Search(len(ts), func(i int) bool { return ts[i].Index >= index })
+	if i < len(ts) && ts[i].Index == index {
+		return ts[i].Time, true
+	}
+	// Find the samples to draw a line through, skipping duplicated indexes.
+	lo, hi := i-1, i
+	if lo < 0 {
+		lo, hi = 0, 1
+	} else if hi >= len(ts) {
+		lo, hi = len(ts)-2, len(ts)-1
+	}
+	for lo > 0 && ts[lo].Index == ts[hi].Index {
+		lo--
+	}
+	for hi < len(ts)-1 && ts[lo].Index == ts[hi].Index {
+		hi++
+	}
+	a, b := ts[lo], ts[hi]
+	if a.Index == b.Index {
+		return time.Time{}, false
+	}
+	perIndex := float64(b.Time.Sub(a.Time)) / float64(b.Index-a.Index)
+	offset := float64(index) - float64(a.Index)
+	return a.Time.Add(time.Duration(offset * perIndex)), true
+}
+
+// age returns how long ago index was written formatted like "~14 months", or
+// "" if it can't be estimated.
+func (ts indexTimes) age(index uint64) string {
+	if index == 0 {
+		return ""
+	}
+	t, ok := ts.timeAt(index)
+	if !ok {
+		return ""
+	}
+	return formatAge(time.Since(t))
+}
+
+// formatAge formats a duration in the single largest unit that fits, since
+// estimates from samples are too rough for more.
+func formatAge(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	day := 24 * time.Hour
+	n, unit := 0, ""
+	switch {
+	case d >= 365*day:
+		n, unit = int(d/(365*day)), "year"
+	case d >= 60*day:
+		n, unit = int(d/(30*day)), "month"
+	case d >= 14*day:
+		n, unit = int(d/(7*day)), "week"
+	case d >= day:
+		n, unit = int(d/day), "day"
+	case d >= time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	default:
+		n, unit = int(d/time.Minute), "minute"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("~%d %s", n, unit)
+}
+
+// indexSampleCommand implements `index-sample <file>`, which appends the raft
+// index the cluster has reached now to a file for -index-times. Running it
+// regularly, e.g. from cron, builds up the samples ages are estimated from.
+func indexSampleCommand(fs *flag.FlagSet) func() {
+	api := consulAPIFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool index-sample [options] <file>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		index, err := fetchLastIndex(ctx, api)
+		if err != nil {
+			fatal(err)
+		}
+		f, err := os.OpenFile(fs.Arg(0), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fatal(err)
+		}
+		_, err = fmt.Fprintf(f, "%d %s\n", index, time.Now().UTC().Format(time.RFC3339))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// fetchLastIndex returns the last raft index the leader has written, from
+// autopilot's view of the servers. The token needs operator:read.
+func fetchLastIndex(ctx context.Context, api *consulAPI) (uint64, error) {
+	resp, err := api.get(ctx, "/v1/operator/autopilot/state")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var state struct {
+		Leader  string
+		Servers map[string]struct{ LastIndex uint64 }
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return 0, fmt.Errorf("invalid autopilot state: %s", err)
+	}
+	leader, ok := state.Servers[state.Leader]
+	if !ok || leader.LastIndex == 0 {
+		return 0, fmt.Errorf("autopilot state has no last index for the leader")
+	}
+	return leader.LastIndex, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// intention is a single source to destination rule, from either a legacy
+// intention record or a source in a service-intentions config entry.
+type intention struct {
+	Source, Destination string
+	Action              string
+}
+
+// intentionName qualifies a service name with its namespace when it's not the
+// default.
+func intentionName(namespace, name string) string {
+	if namespace == "" || namespace == "default" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// recordIntentions returns the intentions defined by a record, if any.
+func recordIntentions(msgType int, val interface{}) []intention {
+	switch typeName(msgType) {
+	case "Intention":
+		return []intention{{
+			Source:      intentionName(stringField(val, "SourceNS"), stringField(val, "SourceName")),
+			Destination: intentionName(stringField(val, "DestinationNS"), stringField(val, "DestinationName")),
+			Action:      stringField(val, "Action"),
+		}}
+
+	case "ConfigEntryRequestType":
+		entry := configEntry(val)
+		if stringField(entry, "Kind") != "service-intentions" {
+			return nil
+		}
+		dst := intentionName(stringField(entry, "Namespace"), stringField(entry, "Name"))
+		sources, _ := field(entry, "Sources").([]interface{})
+		is := make([]intention, 0, len(sources))
+		for _, s := range sources {
+			action := stringField(s, "Action")
+			if action == "" {
+				// L7 intentions have per-request permissions instead
+				action = "permissions"
+			}
+			is = append(is, intention{
+				Source:      intentionName(stringField(s, "Namespace"), stringField(s, "Name")),
+				Destination: dst,
+				Action:      action,
+			})
+		}
+		return is
+	}
+	return nil
+}
+
+// intentionStats breaks intentions down by destination service.
+type intentionStats struct {
+	top int
+
+	// sources counts the intentions for each destination.
+	sources map[string]int
+	// sizes tracks the record bytes used by each destination's intentions.
+	sizes statMap
+	total int
+
+	intentions, wildcardSources, wildcardDestinations int
+	actions                                           map[string]int
+}
+
+func newIntentionStats(c *reportConfig) report {
+	return &intentionStats{
+		top:     c.Top,
+		sources: make(map[string]int),
+		sizes:   make(statMap),
+		actions: make(map[string]int),
+	}
+}
+
+func (i *intentionStats) add(msgType int, val interface{}, size int) {
+	is := recordIntentions(msgType, val)
+	if len(is) == 0 {
+		return
+	}
+	// Config entries hold every source for a destination in one record.
+	i.sizes.add(is[0].Destination, size)
+	i.total += size
+	for _, in := range is {
+		i.intentions++
+		i.sources[in.Destination]++
+		i.actions[in.Action]++
+		if in.Source == "*" {
+			i.wildcardSources++
+		}
+		if in.Destination == "*" {
+			i.wildcardDestinations++
+		}
+	}
+}
+
+func (i *intentionStats) print(w io.Writer) {
+	actions := make([]string, 0, len(i.actions))
+	for a := range i.actions {
+		actions = append(actions, a)
+	}
+	sort.Strings(actions)
+
+	fmt.Fprintf(w, "Intentions: %d (%s) for %d destinations\n", i.intentions, ByteSize(uint64(i.total)), len(i.sources))
+	for _, a := range actions {
+		fmt.Fprintf(w, "  %s: %d\n", a, i.actions[a])
+	}
+	fmt.Fprintf(w, "  wildcard sources: %d\n", i.wildcardSources)
+	fmt.Fprintf(w, "  wildcard destinations: %d\n", i.wildcardDestinations)
+
+	fmt.Fprintln(w)
+	printCounts(w, "Destination", "Sources", i.sources, nil, i.top)
+
+	fmt.Fprintln(w)
+	printTopStats(w, "Destination", i.sizes.slice(), i.total, i.top)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// jsonlRecord is a line of the JSONL form of a snapshot. The first line holds
+// the header and every other line a record.
+//
+// Values are written as plain JSON where that's lossless. Everything else is
+// wrapped in an object with a single key so it can be encoded back exactly:
+//
+//	{"$binary": "<base64>"}  strings that aren't valid UTF-8, e.g. times
+//	{"$map": [[key, value]]} maps with keys that aren't strings
+//	{"$float": "NaN"}        floats JSON can't represent
+//	{"$configEntry": {"kind": "<kind>", "request": {...}}}
+//	                         config entry records, which Consul writes in
+//	                         ConfigEntryRequest's binary form
+//
+// Floats always have a decimal point or exponent so they're told apart from
+// integers.
+type jsonlRecord struct {
+	Header *snapshotHeader `json:"header,omitempty"`
+	Type   *int            `json:"type,omitempty"`
+	Name   string          `json:"name,omitempty"`
+	Value  interface{}     `json:"value"`
+}
+
+// recordJSON converts the decoded value of a record into its JSONL form. Config
+// entries are decoded from their binary form into a $configEntry so they can
+// be read and edited, and are left as $binary if that fails.
+func recordJSON(msgType int, val interface{}) interface{} {
+	if typeName(msgType) != "ConfigEntryRequestType" {
+		return toJSON(val)
+	}
+	var raw []byte
+	switch v := val.(type) {
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return toJSON(val)
+	}
+	kind, req, err := snapshot.DecodeConfigEntryRequest(raw)
+	if err != nil {
+		return toJSON(val)
+	}
+	return map[string]interface{}{"$configEntry": map[string]interface{}{"kind": kind, "request": toJSON(req)}}
+}
+
+// toJSON converts a decoded msgpack value into its JSONL form.
+func toJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if !utf8.ValidString(v) {
+			return map[string]interface{}{"$binary": base64.StdEncoding.EncodeToString([]byte(v))}
+		}
+		return v
+	case []byte:
+		return map[string]interface{}{"$binary": base64.StdEncoding.EncodeToString(v)}
+	case float64:
+		return jsonFloat(v)
+	case float32:
+		return jsonFloat(float64(v))
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = toJSON(e)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		plain := true
+		for k, e := range v {
+			s, ok := k.(string)
+			if !ok || !utf8.ValidString(s) {
+				plain = false
+				break
+			}
+			out[s] = toJSON(e)
+		}
+		// A map with a single $ key would be mistaken for one of the
+		// wrappers so it's written as a $map too.
+		if plain && len(out) == 1 {
+			for k := range out {
+				plain = !strings.HasPrefix(k, "$")
+			}
+		}
+		if plain {
+			return out
+		}
+		pairs := make([][2]interface{}, 0, len(v))
+		for k, e := range v {
+			pairs = append(pairs, [2]interface{}{toJSON(k), toJSON(e)})
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return fmt.Sprint(pairs[i][0]) < fmt.Sprint(pairs[j][0])
+		})
+		return map[string]interface{}{"$map": pairs}
+	}
+	return v
+}
+
+func jsonFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return map[string]interface{}{"$float": strconv.FormatFloat(f, 'g', -1, 64)}
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return json.Number(s)
+}
+
+// fromJSON converts a value decoded from JSONL, with numbers decoded as
+// json.Number, back into a value that encodes as the original msgpack.
+func fromJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		s := v.String()
+		if strings.ContainsAny(s, ".eE") {
+			return strconv.ParseFloat(s, 64)
+		}
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+		return strconv.ParseUint(s, 10, 64)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			var err error
+			if out[i], err = fromJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if b, ok := v["$binary"].(string); ok {
+				raw, err := base64.StdEncoding.DecodeString(b)
+				return string(raw), err
+			}
+			if f, ok := v["$float"].(string); ok {
+				return strconv.ParseFloat(f, 64)
+			}
+			if pairs, ok := v["$map"].([]interface{}); ok {
+				return fromJSONPairs(pairs)
+			}
+			if entry, ok := v["$configEntry"].(map[string]interface{}); ok {
+				return fromJSONConfigEntry(entry)
+			}
+		}
+		out := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			var err error
+			if out[k], err = fromJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func fromJSONPairs(pairs []interface{}) (interface{}, error) {
+	out := make(map[interface{}]interface{}, len(pairs))
+	for _, p := range pairs {
+		kv, ok := p.([]interface{})
+		if !ok || len(kv) != 2 {
+			return nil, fmt.Errorf("$map entries must be [key, value] pairs")
+		}
+		k, err := fromJSON(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		if out[k], err = fromJSON(kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// fromJSONConfigEntry encodes a $configEntry back into ConfigEntryRequest's
+// binary form. The request's fields may come out in a different order but
+// Consul decodes them the same.
+func fromJSONConfigEntry(entry map[string]interface{}) (interface{}, error) {
+	kind, ok := entry["kind"].(string)
+	if !ok {
+		return nil, fmt.Errorf("$configEntry needs a kind")
+	}
+	v, err := fromJSON(entry["request"])
+	if err != nil {
+		return nil, err
+	}
+	req, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("$configEntry needs a request object")
+	}
+	return snapshot.EncodeConfigEntryRequest(kind, req)
+}
+
+// decodeSnapshot writes the JSONL form of the snapshot read from r to w.
+func decodeSnapshot(r io.Reader, w io.Writer) error {
+	s, err := newSnapshotScanner(r)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	header := struct {
+		Header snapshotHeader `json:"header"`
+	}{s.header}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	for {
+		msgType, val, err := s.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rec := jsonlRecord{Type: &msgType, Name: typeName(msgType), Value: recordJSON(msgType, val)}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// encodeSnapshot writes the snapshot whose JSONL form is read from r to w.
+func encodeSnapshot(r io.Reader, w io.Writer) error {
+	sw := newSnapshotWriter(w)
+	// Records can be as large as the raft log allows, and larger again once
+	// written as JSON, so lines are read whole however long they are.
+	br := bufio.NewReaderSize(r, 64*KILOBYTE)
+	line, wroteHeader := 0, false
+	for eof := false; !eof; {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			eof = true
+		} else if err != nil {
+			return fmt.Errorf("line %d: %s", line+1, err)
+		}
+		line++
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var rec jsonlRecord
+		if err := dec.Decode(&rec); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+
+		switch {
+		case !wroteHeader:
+			if rec.Header == nil {
+				return fmt.Errorf("line %d: expected the snapshot header", line)
+			}
+			if err := sw.writeHeader(*rec.Header); err != nil {
+				return err
+			}
+			wroteHeader = true
+		case rec.Type == nil || *rec.Type < 0 || *rec.Type > 255:
+			return fmt.Errorf("line %d: missing or invalid record type", line)
+		default:
+			val, err := fromJSON(rec.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", line, err)
+			}
+			if err := sw.write(*rec.Type, val); err != nil {
+				return err
+			}
+		}
+	}
+	if !wroteHeader {
+		return fmt.Errorf("empty input")
+	}
+	return nil
+}
+
+// decodeCommand implements `decode <snapshot>` which converts a snapshot to
+// JSONL, one record per line.
+func decodeCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	out := fs.String("o", "-", "file to write the JSONL to, - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool decode [options] <snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		r, err := openSnapshot(fs.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		defer r.Close()
+		convertFile(fs.Arg(0), r, *out, decodeSnapshot)
+	}
+}
+
+// encodeCommand implements `encode <jsonl>` which converts the output of
+// decode back into a state.bin.
+func encodeCommand(fs *flag.FlagSet) func() {
+	out := outputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool encode [options] <jsonl file>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		var r io.Reader = os.Stdin
+		if fs.Arg(0) != "-" {
+			f, err := os.Open(fs.Arg(0))
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		out.write(fs.Arg(0), r, encodeSnapshot)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxKeyExamples is the number of example keys listed for each anomaly.
+const maxKeyExamples = 5
+
+// keyAnomalies collects keys that are likely the result of an application bug
+// and that tend to break tooling which assumes sane key names.
+type keyAnomalies struct {
+	maxLen int
+
+	invalidUTF8 anomaly
+	control     anomaly
+	long        anomaly
+}
+
+type anomaly struct {
+	Count    int
+	Examples []string
+}
+
+func (a *anomaly) add(key string) {
+	a.Count++
+	if len(a.Examples) < maxKeyExamples {
+		a.Examples = append(a.Examples, key)
+	}
+}
+
+func newKeyAnomalies(maxLen int) *keyAnomalies {
+	return &keyAnomalies{maxLen: maxLen}
+}
+
+func (k *keyAnomalies) add(key string) {
+	if !utf8.ValidString(key) {
+		k.invalidUTF8.add(key)
+	} else if hasControl(key) {
+		k.control.add(key)
+	}
+	if k.maxLen > 0 && len(key) > k.maxLen {
+		k.long.add(key)
+	}
+}
+
+func hasControl(s string) bool {
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *keyAnomalies) print(w io.Writer) {
+	if k.invalidUTF8.Count+k.control.Count+k.long.Count == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Anomalous Keys")
+	k.invalidUTF8.print(w, "containing invalid UTF-8")
+	k.control.print(w, "containing control characters")
+	k.long.print(w, fmt.Sprintf("longer than %d bytes", k.maxLen))
+}
+
+func (a *anomaly) print(w io.Writer, desc string) {
+	if a.Count == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %d keys %s, e.g.:\n", a.Count, desc)
+	for _, key := range a.Examples {
+		fmt.Fprintf(w, "    %q (%d bytes)\n", truncate(key, 80), len(key))
+	}
+}
+
+// truncate shortens s to at most n bytes, marking that it has been cut.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// keyLengths tracks the distribution of key name lengths per KV prefix, since
+// for some schemas the key names themselves make up a significant fraction of
+// the snapshot.
+type keyLengths struct {
+	depth    int
+	prefixes map[string][]int
+}
+
+func newKeyLengths(depth int) *keyLengths {
+	return &keyLengths{
+		depth:    depth,
+		prefixes: make(map[string][]int),
+	}
+}
+
+func (k *keyLengths) add(key string) {
+	prefix := kvPrefix(key, k.depth)
+	k.prefixes[prefix] = append(k.prefixes[prefix], len(key))
+}
+
+type keyLengthStats struct {
+	Prefix               string
+	Count, Min, P95, Max int
+	Mean                 float64
+	Total                int
+}
+
+func (k *keyLengths) stats() []keyLengthStats {
+	stats := make([]keyLengthStats, 0, len(k.prefixes))
+	for prefix, lens := range k.prefixes {
+		sort.Ints(lens)
+		s := keyLengthStats{
+			Prefix: prefix,
+			Count:  len(lens),
+			Min:    lens[0],
+			P95:    lens[(len(lens)*95+99)/100-1],
+			Max:    lens[len(lens)-1],
+		}
+		for _, l := range lens {
+			s.Total += l
+		}
+		s.Mean = float64(s.Total) / float64(s.Count)
+		stats = append(stats, s)
+	}
+	// Largest total key bytes first
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Total > stats[j].Total })
+	return stats
+}
+
+func (k *keyLengths) print(w io.Writer) {
+	if len(k.prefixes) == 0 {
+		return
+	}
+
+	stats := k.stats()
+	width := 22
+	for _, s := range stats {
+		if len(s.Prefix) > width {
+			width = len(s.Prefix)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "% *s % 8s % 6s % 8s % 6s % 6s % 12s\n", width, "KV Prefix", "Count", "Min", "Mean", "P95", "Max", "Key Bytes")
+	fmt.Fprintf(w, "%s %s %s %s %s %s %s\n", strings.Repeat("-", width), strings.Repeat("-", 8),
+		strings.Repeat("-", 6), strings.Repeat("-", 8), strings.Repeat("-", 6), strings.Repeat("-", 6), strings.Repeat("-", 12))
+	for _, s := range stats {
+		fmt.Fprintf(w, "% *s % 8d % 6d % 8.1f % 6d % 6d % 12s\n", width, s.Prefix, s.Count, s.Min, s.Mean, s.P95, s.Max, ByteSize(uint64(s.Total)))
+	}
+}
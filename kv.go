@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// kvStats breaks down KVS records by key prefix.
+type kvStats struct {
+	depth   int
+	exclude []string
+
+	prefixes statMap
+	total    int
+
+	excluded typeStats
+
+	// sample is N when only 1 in N entries is added, each standing in for
+	// N entries like it, and samples counts the entries added per prefix.
+	sample  int
+	samples map[string]int
+}
+
+func newKVStats(depth int, exclude []string) *kvStats {
+	return &kvStats{
+		depth:    depth,
+		exclude:  exclude,
+		prefixes: make(statMap),
+	}
+}
+
+// setSample sets the breakdown to be estimated from 1 in n entries.
+func (k *kvStats) setSample(n int) {
+	k.sample = n
+	k.samples = make(map[string]int)
+}
+
+// add records a KVS entry of the given encoded size.
+func (k *kvStats) add(key string, size int) {
+	weight := 1
+	if k.sample > 1 {
+		weight = k.sample
+	}
+	for _, p := range k.exclude {
+		if strings.HasPrefix(key, p) {
+			k.excluded.Sum += size * weight
+			k.excluded.Count += weight
+			return
+		}
+	}
+
+	prefix := kvPrefix(key, k.depth)
+	s := k.prefixes[prefix]
+	s.Name = prefix
+	s.Sum += size * weight
+	s.Count += weight
+	k.prefixes[prefix] = s
+	k.total += size * weight
+	if k.samples != nil {
+		k.samples[prefix]++
+	}
+}
+
+func (k *kvStats) print(w io.Writer) {
+	if len(k.prefixes) == 0 && k.excluded.Count == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	if k.sample > 1 {
+		printSampledStats(w, "KV Prefix", k.prefixes.slice(), k.samples, k.sample, k.total)
+	} else {
+		printStats(w, "KV Prefix", k.prefixes.slice(), k.total)
+	}
+	if k.excluded.Count > 0 {
+		fmt.Fprintf(w, "\nExcluded %d keys (%s) matching %s\n", k.excluded.Count,
+			ByteSize(uint64(k.excluded.Sum)), strings.Join(k.exclude, ", "))
+	}
+}
+
+// kvKey returns the Key of a decoded DirEntry or "" if it doesn't have one.
+func kvKey(val interface{}) string {
+	return stringField(val, "Key")
+}
+
+// kvPrefix returns the first depth segments of key. Keys that are nested
+// deeper keep a trailing slash so they read like a directory.
+func kvPrefix(key string, depth int) string {
+	if depth <= 0 {
+		return key
+	}
+	parts := strings.SplitN(key, "/", depth+1)
+	if len(parts) <= depth {
+		return key
+	}
+	return strings.Join(parts[:depth], "/") + "/"
+}
+
+// kvCommand implements `kv ls <prefix>` which lists the immediate children of a
+// prefix with their size and count, like `consul kv get -keys` does against a
+// live cluster.
+func kvCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool kv ls [options] [prefix [snapshot]]")
+		fs.PrintDefaults()
+	}
+	return func() {
+		prefix := fs.Arg(0)
+		path := "-"
+		if fs.NArg() > 1 {
+			path = fs.Arg(1)
+		}
+
+		children := make(statMap)
+		total := 0
+		in, err := openSnapshot(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer in.Close()
+		_, err = scanSnapshot(in, func(msgType int, raw []byte, size int) {
+			if typeName(msgType) != "KVS" {
+				return
+			}
+			key, _ := snapshot.MapString(raw, "Key")
+			if !strings.HasPrefix(key, prefix) {
+				return
+			}
+			children.add(kvChild(key, prefix), size)
+			total += size
+		})
+		if err != nil {
+			fatal(err)
+		}
+
+		printStats(os.Stdout, "Key", children.slice(), total)
+	}
+}
+
+// kvChild returns the immediate child of prefix that key falls under, keeping
+// the trailing separator for keys nested further down.
+func kvChild(key, prefix string) string {
+	rest := strings.TrimPrefix(key, prefix)
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return prefix + rest[:i+1]
+	}
+	return key
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// defaultMaxRecordSize is the largest raft entry Consul suggests applying in
+// one go (raft.SuggestedMaxDataSize). Larger writes are rejected or have to
+// be chunked so a record this big may not survive being written again.
+const defaultMaxRecordSize = 512 * KILOBYTE
+
+// maxRecordBytes is the largest record that will be read into memory, set with
+// -max-record-bytes. It's far larger than any record Consul could have written
+// so anything bigger is almost certainly a corrupt length.
+var maxRecordBytes = byteSizeFlag(snapshot.DefaultMaxRecordBytes)
+
+// largeRecords collects records whose encoded size is close to or over the
+// raft entry size limit. Snapshots are restored in bulk so they can hold
+// records that could never be written through a live cluster, such as config
+// entries or registrations that have grown with every update.
+type largeRecords struct {
+	limit   int
+	records int
+
+	near, over int
+	largest    []largeRecord
+}
+
+type largeRecord struct {
+	Record int
+	Type   string
+	ID     string
+	Size   int
+}
+
+func newLargeRecords(limit int) *largeRecords {
+	return &largeRecords{limit: limit}
+}
+
+// nearLimit returns the size above which a record is considered close to the
+// limit.
+func (l *largeRecords) nearLimit() int {
+	return l.limit * 9 / 10
+}
+
+// count is called with the size of every record in turn and returns true if
+// it should be passed to add.
+func (l *largeRecords) count(size int) bool {
+	l.records++
+	return l.limit > 0 && size >= l.nearLimit()
+}
+
+// add records the last record counted.
+func (l *largeRecords) add(msgType int, val interface{}, size int) {
+	if size > l.limit {
+		l.over++
+	} else {
+		l.near++
+	}
+	r := largeRecord{Record: l.records, Type: typeName(msgType), Size: size}
+	// The value may not have been decoded.
+	if val != nil {
+		r.ID = recordIdentity(msgType, val)
+	}
+	l.largest = append(l.largest, r)
+}
+
+func (l *largeRecords) print(w io.Writer) {
+	if len(l.largest) == 0 {
+		return
+	}
+
+	sort.SliceStable(l.largest, func(i, j int) bool { return l.largest[i].Size > l.largest[j].Size })
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Large Records")
+	fmt.Fprintf(w, "  %d records over the %s raft entry limit and %d within 10%% of it, e.g.:\n",
+		l.over, ByteSize(uint64(l.limit)), l.near)
+	for i, r := range l.largest {
+		if i == maxKeyExamples {
+			break
+		}
+		desc := r.ID
+		if desc == "" {
+			desc = r.Type
+		}
+		fmt.Fprintf(w, "    record %d: %s (%s)\n", r.Record, truncate(desc, 80), ByteSize(uint64(r.Size)))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+// Rough costs of holding restored state in Consul's in-memory database, used
+// by estimate to size servers. They're approximations measured against heap
+// profiles of servers, not exact accounting, and are intentionally on the
+// generous side.
+const (
+	// indexEntryBytes is what each memdb index entry costs: the radix tree
+	// node, its edge and the key it stores.
+	indexEntryBytes = 128
+	// baseHeapBytes is the heap a Consul server uses with no state.
+	baseHeapBytes = 64 * MEGABYTE
+)
+
+// memoryCost models how a record type is held in memory once restored.
+type memoryCost struct {
+	// objectFactor is the size of the decoded object relative to its
+	// encoded size in the snapshot.
+	objectFactor float64
+	// indexes is how many memdb indexes each record is entered into.
+	indexes int
+}
+
+// defaultMemoryCost is used for types without an entry in memoryCosts.
+var defaultMemoryCost = memoryCost{3, 2}
+
+// memoryCosts are the costs of the types that make up most snapshots, keyed
+// by type name. Catalog registrations are split across the nodes, services
+// and checks tables, so their indexes are an average of the three.
+var memoryCosts = map[string]memoryCost{
+	"Register":                     {3, 4},
+	"KVS":                          {1.5, 1},
+	"Session":                      {3, 3},
+	"ACL (Deprecated)":             {2, 1},
+	"Tombstone":                    {1.5, 1},
+	"CoordinateBatchUpdate":        {2, 2},
+	"PreparedQuery":                {3, 3},
+	"Autopilot":                    {2, 0},
+	"Intention":                    {3, 4},
+	"ConnectCA":                    {2, 2},
+	"ConnectCAProviderState":       {2, 1},
+	"ConnectCAConfig":              {2, 0},
+	"Index":                        {2, 1},
+	"ACLTokenSet":                  {3, 7},
+	"ACLPolicySet":                 {2, 2},
+	"ConfigEntryRequestType":       {3, 4},
+	"ACLRoleSetRequestType":        {2, 3},
+	"ACLBindingRuleSetRequestType": {2, 2},
+	"ACLAuthMethodSetRequestType":  {2, 1},
+	"FederationStateRequestType":   {2, 1},
+	"SystemMetadataRequestType":    {2, 1},
+	"ServiceVirtualIPRequestType":  {2, 1},
+	"FreeVirtualIPRequestType":     {2, 1},
+	"KindServiceNamesType":         {2, 2},
+	"PeeringWriteType":             {3, 3},
+	"PeeringTrustBundleWriteType":  {2, 1},
+	"PeeringSecretsWriteType":      {2, 1},
+}
+
+// memoryCostOf returns the cost model for a type.
+func memoryCostOf(name string) memoryCost {
+	if c, ok := memoryCosts[name]; ok {
+		return c
+	}
+	return defaultMemoryCost
+}
+
+// heapBytes estimates the heap that count records of a type totalling size
+// bytes take once restored.
+func (c memoryCost) heapBytes(count, size int) int {
+	return int(float64(size)*c.objectFactor) + count*c.indexes*indexEntryBytes
+}
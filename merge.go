@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// mergeCommand implements `merge <base> <overlay>` which writes a copy of base
+// with its KV entries under the given prefixes replaced by those in overlay.
+func mergeCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	var prefixes stringsFlag
+	fs.Var(&prefixes, "prefix", "KV prefix to take from the overlay snapshot (may be repeated, default the whole KV tree)")
+	out := outputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool merge [options] <base snapshot> <overlay snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 2 {
+			fs.Usage()
+			exit(exitError)
+		}
+		if len(prefixes) == 0 {
+			prefixes = stringsFlag{""}
+		}
+
+		overlay, err := overlayKV(fs.Arg(1), prefixes)
+		if err != nil {
+			fatal(err)
+		}
+
+		var lastIndex uint64
+		stats := writeSnapshotFile(fs.Arg(0), out, func(msgType int, val interface{}) (recordEdit, interface{}) {
+			if index := uintField(val, "ModifyIndex"); index > lastIndex {
+				lastIndex = index
+			}
+			if isKVRecord(msgType) && hasAnyPrefix(kvKey(val), prefixes) {
+				return dropRecord, nil
+			}
+			return keepRecord, nil
+		}, func(sw *snapshotWriter) error {
+			for _, rec := range overlay.records {
+				if err := sw.writeRaw(rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		printRewrite(os.Stderr, stats)
+		fmt.Fprintf(os.Stderr, "Merged %d KV entries (%s) from %s", len(overlay.records), ByteSize(uint64(overlay.size)), fs.Arg(1))
+		if overlay.unlocked > 0 {
+			fmt.Fprintf(os.Stderr, ", releasing %d locks", overlay.unlocked)
+		}
+		fmt.Fprintln(os.Stderr)
+		if overlay.maxIndex > lastIndex {
+			fmt.Fprintf(os.Stderr, "\nWarning: merged entries were modified at index %d, after anything in the base snapshot (%d). "+
+				"Blocking queries on those keys may not see later changes until they're written again.\n", overlay.maxIndex, lastIndex)
+		}
+	}
+}
+
+// overlayRecords holds the encoded KV entries taken from the overlay snapshot.
+type overlayRecords struct {
+	records  [][]byte
+	size     int
+	maxIndex uint64
+	// unlocked counts the entries whose lock was released because the
+	// session holding it is from the overlay snapshot.
+	unlocked int
+}
+
+// overlayKV reads the KV entries under prefixes from the snapshot at path.
+func overlayKV(path string, prefixes []string) (*overlayRecords, error) {
+	r, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	s, err := newSnapshotScanner(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	o := &overlayRecords{}
+	for {
+		msgType, val, err := s.next()
+		if err == io.EOF {
+			return o, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if typeName(msgType) != "KVS" || !hasAnyPrefix(kvKey(val), prefixes) {
+			continue
+		}
+
+		var rec []byte
+		if stringField(val, "Session") != "" {
+			// The session won't exist in the base snapshot so release the
+			// lock rather than restore a key locked by nobody.
+			setField(val, "", "Session")
+			var buf bytes.Buffer
+			if err := newSnapshotWriter(&buf).write(msgType, val); err != nil {
+				return nil, err
+			}
+			rec = buf.Bytes()
+			o.unlocked++
+		} else {
+			rec = append([]byte(nil), s.raw()...)
+		}
+		o.records = append(o.records, rec)
+		o.size += len(rec)
+		if index := uintField(val, "ModifyIndex"); index > o.maxIndex {
+			o.maxIndex = index
+		}
+	}
+}
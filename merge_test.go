@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+func TestMergeCommand(t *testing.T) {
+	base := writeTestSnapshot(t, testSnapshot(t, 100,
+		testRecord{snapshot.RegisterType, map[string]interface{}{"Node": "node-1", "Address": "10.0.0.1"}},
+		testKV("app/a", "base", 5, 5),
+		testKV("app/gone", "base", 6, 6),
+		testTombstone("app/deleted", 7),
+		testKV("other/c", "base", 8, 8),
+	))
+	overlay := writeTestSnapshot(t, testSnapshot(t, 200,
+		testRecord{snapshot.RegisterType, map[string]interface{}{"Node": "node-2", "Address": "10.0.0.2"}},
+		testKV("app/a", "overlay", 5, 150),
+		testLockedKV("app/lock", "s1", 160),
+		testKV("other/c", "overlay", 8, 170),
+	))
+	out := filepath.Join(t.TempDir(), "out.bin")
+	runCommand(t, "merge", "-prefix", "app/", "-o", out, base, overlay)
+
+	var got []string
+	for _, rec := range readTestSnapshot(t, out) {
+		name := typeName(rec.msgType)
+		switch name {
+		case "Register":
+			name += " " + stringField(rec.val, "Node")
+		case "KVS":
+			name += " " + kvKey(rec.val) + "=" + stringField(rec.val, "Value")
+			if s := stringField(rec.val, "Session"); s != "" {
+				t.Errorf("%s is still locked by %q", kvKey(rec.val), s)
+			}
+		}
+		got = append(got, name)
+	}
+	// The base's entries under app/, including tombstones, are dropped and
+	// the overlay's appended.
+	want := []string{"Register node-1", "KVS other/c=base", "KVS app/a=overlay", "KVS app/lock="}
+	if len(got) != len(want) {
+		t.Fatalf("got records %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got records %q, want %q", got, want)
+		}
+	}
+}
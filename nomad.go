@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// nomadTypeNames are the names of the record types Nomad writes to its
+// snapshots, indexed by type, with gaps for numbers it doesn't use. Nomad's
+// snapshots are framed exactly like Consul's, a msgpack header followed by
+// type-prefixed msgpack records, but with their own types. These mirror the
+// SnapshotType consts in
+// https://github.com/hashicorp/nomad/blob/main/nomad/fsm.go without the
+// Snapshot suffix.
+var nomadTypeNames = func() []string {
+	names := []string{
+		"Node",
+		"Job",
+		"Index",
+		"Eval",
+		"Alloc",
+		"TimeTable",
+		"PeriodicLaunch",
+		"JobSummary",
+		"VaultAccessor",
+		"JobVersion",
+		"Deployment",
+		"ACLPolicy",
+		"ACLToken",
+		"SchedulerConfig",
+		"ClusterMetadata",
+		"ServiceIdentityTokenAccessor",
+		"ScalingPolicy",
+		"CSIPlugin",
+		"CSIVolume",
+		"ScalingEvents",
+		"EventSink",
+		"ServiceRegistration",
+		"Variables",
+		"VariablesQuota",
+		"RootKeyMeta",
+		"ACLRole",
+		"ACLAuthMethod",
+		"ACLBindingRule",
+		"NodePool",
+		"JobSubmission",
+	}
+	// Namespaces moved from Nomad Enterprise so are numbered after the gap
+	// it reserves.
+	names = append(names, make([]string, 64-len(names))...)
+	return append(names, "Namespace")
+}()
+
+// products maps the names accepted by -product to their type tables. Vault
+// snapshots have no record types, only storage entries, so only stats can read
+// them.
+var products = map[string][]string{
+	"consul": snapshot.TypeNames,
+	"nomad":  nomadTypeNames,
+	"vault":  {"StorageEntry"},
+}
+
+// product is the product that wrote the snapshot, chosen with -product.
+var product = "consul"
+
+// productList returns the sorted names of the products.
+func productList() []string {
+	names := make([]string, 0, len(products))
+	for name := range products {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// productValue is a flag.Value for -product. Setting it only checks the
+// product exists; applyInputFlags selects its type table.
+type productValue string
+
+func (v *productValue) String() string { return string(*v) }
+
+func (v *productValue) Set(s string) error {
+	if _, ok := products[s]; !ok {
+		return fmt.Errorf("unknown product %q, must be one of: %s", s, strings.Join(productList(), ", "))
+	}
+	*v = productValue(s)
+	return nil
+}
+
+// setProduct selects the type table of the product s.
+func setProduct(s string) {
+	typeNames = append([]string(nil), products[s]...)
+	product = s
+}
+
+// nomadStats breaks down the jobs, allocations and evaluations in a Nomad
+// snapshot, which are what usually make its state store grow.
+type nomadStats struct {
+	jobs        statMap
+	allocs      statMap
+	allocJobs   statMap
+	evals       statMap
+	evalTrigger statMap
+}
+
+func newNomadStats() *nomadStats {
+	return &nomadStats{
+		jobs:        make(statMap),
+		allocs:      make(statMap),
+		allocJobs:   make(statMap),
+		evals:       make(statMap),
+		evalTrigger: make(statMap),
+	}
+}
+
+// add counts a record, reading its top level string fields with field.
+func (n *nomadStats) add(msgType int, field func(name string) string, size int) {
+	switch typeName(msgType) {
+	case "Job", "JobVersion":
+		n.jobs.add(orNone(field("Namespace"))+" "+orNone(field("Type")), size)
+	case "Alloc":
+		n.allocs.add(orNone(field("ClientStatus")), size)
+		n.allocJobs.add(orNone(field("Namespace"))+"/"+orNone(field("JobID")), size)
+	case "Eval":
+		n.evals.add(orNone(field("Status")), size)
+		n.evalTrigger.add(orNone(field("TriggeredBy")), size)
+	}
+}
+
+// orNone returns s, or (none) if it's empty.
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+func (n *nomadStats) print(w io.Writer, top int) {
+	for _, t := range []struct {
+		heading string
+		stats   statMap
+	}{
+		{"Job Namespace, Type", n.jobs},
+		{"Alloc Status", n.allocs},
+		{"Alloc Job", n.allocJobs},
+		{"Eval Status", n.evals},
+		{"Eval Trigger", n.evalTrigger},
+	} {
+		if len(t.stats) == 0 {
+			continue
+		}
+		_, total := sumStats(t.stats)
+		fmt.Fprintln(w)
+		printTopStats(w, t.heading, t.stats.slice(), total, top)
+	}
+}
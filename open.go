@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// inputFlags registers the flags shared by every command that reads
+// snapshots with fs.
+func inputFlags(fs *flag.FlagSet) {
+	fs.String("cpuprofile", "", "write a CPU profile of the command to this file")
+	fs.StringVar(&memProfile, "memprofile", "", "write a heap profile to this file once the command finishes")
+	fs.BoolVar(&strictTypes, "strict", false, "fail on records of unknown types, and on other problems that are otherwise only warned about")
+	fs.Var(new(productValue), "product", "product that wrote the snapshot, which sets the record types and breakdowns: "+strings.Join(productList(), ", ")+" (default consul)")
+	fs.Var(new(consulVersionValue), "consul-version", "Consul version that wrote the snapshot, e.g. 1.17, used to name record types (default newest)")
+	fs.String("type-map", "", "JSON file naming record types the tool doesn't know, mapping their numbers to names")
+	fs.Var(&maxRecordBytes, "max-record-bytes", "skip, or where every record is needed fail on, records larger than this rather than read them into memory")
+	fs.BoolVar(&showProgress, "progress", true, "show progress while reading a snapshot file when stderr is a terminal")
+}
+
+// applyInputFlags acts on the input flags of fs once they've been parsed.
+// Flags can be set by the config file, the environment and the command line
+// in turn, so their values only record what was given and it's the last
+// value that takes effect here.
+func applyInputFlags(fs *flag.FlagSet) error {
+	value := func(name string) string {
+		if f := fs.Lookup(name); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	}
+	if p := value("product"); p != "" {
+		setProduct(p)
+	}
+	if v := value("consul-version"); v != "" {
+		if err := setConsulVersion(v); err != nil {
+			return err
+		}
+	}
+	if path := value("type-map"); path != "" {
+		if err := loadTypeMap(path); err != nil {
+			return err
+		}
+	}
+	if path := value("cpuprofile"); path != "" {
+		return startCPUProfile(path)
+	}
+	return nil
+}
+
+// openSnapshot opens the raw snapshot state at path, or stdin if path is "-".
+// Backup archives written by `consul snapshot save` are gzipped tarballs so
+// those are unpacked to find the state.bin inside.
+func openSnapshot(path string) (io.ReadCloser, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+
+	in := watchProgress(f)
+	r, err := snapshotReader(in)
+	if err != nil {
+		in.Close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return readCloser{r, in}, nil
+}
+
+// snapshotReader returns a reader for the raw snapshot state in r, unpacking
+// it from a backup archive if needed.
+func snapshotReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		// Not gzipped so assume it's a raw state.bin
+		return br, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no state.bin in snapshot archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == "state.bin" {
+			return tr, nil
+		}
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// peeringStates are the names of pbpeering.PeeringState values.
+var peeringStates = []string{
+	"UNDEFINED",
+	"PENDING",
+	"ESTABLISHING",
+	"ACTIVE",
+	"FAILING",
+	"DELETING",
+	"TERMINATED",
+}
+
+// peeringStats summarises cluster peerings, their trust bundles and secrets.
+type peeringStats struct {
+	peers   []peer
+	bundles map[string]int
+	secrets int
+	size    int
+}
+
+type peer struct {
+	ID, Name, Partition, State string
+	Size                       int
+}
+
+func newPeeringStats(c *reportConfig) report {
+	return &peeringStats{bundles: make(map[string]int)}
+}
+
+func (p *peeringStats) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "PeeringWriteType":
+		state := "UNDEFINED"
+		if s := uintField(val, "State"); s < uint64(len(peeringStates)) {
+			state = peeringStates[s]
+		}
+		p.peers = append(p.peers, peer{
+			ID:        stringField(val, "ID"),
+			Name:      stringField(val, "Name"),
+			Partition: stringField(val, "Partition"),
+			State:     state,
+			Size:      size,
+		})
+	case "PeeringTrustBundleWriteType":
+		p.bundles[stringField(val, "PeerName")] += size
+	case "PeeringSecretsWriteType":
+		// Only count these, they're credentials.
+		p.secrets++
+	default:
+		return
+	}
+	p.size += size
+}
+
+func (p *peeringStats) print(w io.Writer) {
+	fmt.Fprintf(w, "Peerings: %d, %d trust bundles, %d secrets (%s)\n\n", len(p.peers), len(p.bundles), p.secrets, ByteSize(uint64(p.size)))
+	sort.Slice(p.peers, func(i, j int) bool { return p.peers[i].Name < p.peers[j].Name })
+
+	fmt.Fprintf(w, "% 22s % 14s % 12s % 12s\n", "Peer", "State", "Size", "Trust Bundle")
+	fmt.Fprintf(w, "%s %s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 14), strings.Repeat("-", 12), strings.Repeat("-", 12))
+	for _, peer := range p.peers {
+		name := peer.Name
+		if peer.Partition != "" && peer.Partition != "default" {
+			name = peer.Partition + "/" + name
+		}
+		fmt.Fprintf(w, "% 22s % 14s % 12s % 12s\n", name, peer.State, ByteSize(uint64(peer.Size)), ByteSize(uint64(p.bundles[peer.Name])))
+	}
+}
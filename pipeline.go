@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"io"
+	"runtime"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// decodeBatchSize is the number of records handed to a decoder worker at a
+// time, enough that passing them around costs little next to decoding them.
+const decodeBatchSize = 256
+
+// decodeBatch is a run of consecutive records decoded by one worker.
+type decodeBatch struct {
+	types []int
+	raw   [][]byte
+	sizes []int
+	vals  []interface{}
+	// first is the ordinal of the first record in the batch and offset the
+	// byte offset of its start.
+	first, offset int
+	// err is the error decoding a record in the batch, and readErr the error
+	// reading the snapshot that cut the batch short. Records before either
+	// are still valid.
+	err, readErr error
+	// end is the number of bytes read once the batch was complete.
+	end  int
+	done chan struct{}
+}
+
+// decode decodes the records in the batch, stopping at the first that fails.
+func (b *decodeBatch) decode() {
+	defer close(b.done)
+	b.vals = make([]interface{}, 0, len(b.raw))
+	offset := b.offset
+	for i, raw := range b.raw {
+		if raw == nil {
+			// Skipped for being too large.
+			b.vals = append(b.vals, nil)
+			offset += b.sizes[i]
+			continue
+		}
+		val, err := snapshot.Decode(raw)
+		if err != nil {
+			b.err = &snapshotError{Offset: offset, Record: b.first + i, Type: typeName(b.types[i]), Err: err}
+			return
+		}
+		b.vals = append(b.vals, val)
+		offset += b.sizes[i]
+	}
+}
+
+// readSnapshot decodes every record in the snapshot read from r, calling fn
+// with the message type, decoded value and encoded size of each in the order
+// they appear. Records too large to read are passed with a nil value. It
+// returns the total number of bytes read, or a *snapshotError
+// saying where reading failed.
+//
+// One goroutine reads and frames records while the rest of the CPUs decode
+// them in batches, since decoding is most of the work for large snapshots.
+// fn is only ever called from the calling goroutine.
+func readSnapshot(r io.Reader, fn func(msgType int, val interface{}, size int)) (int, error) {
+	s, err := newSnapshotScanner(r)
+	if err != nil {
+		return 0, err
+	}
+	s.skipLarge = true
+
+	workers := runtime.GOMAXPROCS(0)
+	jobs := make(chan *decodeBatch, workers)
+	// ordered receives the same batches as jobs but in the order they were
+	// read, so their records can be passed to fn in order as they complete.
+	ordered := make(chan *decodeBatch, 2*workers)
+	quit := make(chan struct{})
+	defer close(quit)
+	total := s.offset
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for b := range jobs {
+				b.decode()
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		defer close(ordered)
+		for {
+			b := &decodeBatch{first: s.records + 1, offset: s.offset, done: make(chan struct{})}
+			for len(b.raw) < decodeBatchSize {
+				msgType, raw, err := s.nextRaw()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					b.readErr = err
+					break
+				}
+				b.types = append(b.types, msgType)
+				// The scanner reuses its buffer.
+				if raw != nil {
+					raw = append([]byte(nil), raw...)
+				}
+				b.raw = append(b.raw, raw)
+				b.sizes = append(b.sizes, s.size)
+			}
+			b.end = s.offset
+			select {
+			case ordered <- b:
+			case <-quit:
+				return
+			}
+			select {
+			case jobs <- b:
+			case <-quit:
+				return
+			}
+			if len(b.raw) < decodeBatchSize {
+				return
+			}
+		}
+	}()
+
+	for b := range ordered {
+		<-b.done
+		for i, val := range b.vals {
+			fn(b.types[i], val, b.sizes[i])
+		}
+		if b.err != nil {
+			return b.offset, b.err
+		}
+		if b.readErr != nil {
+			return b.end, b.readErr
+		}
+		total = b.end
+	}
+	return total, nil
+}
+
+// scanSnapshot calls fn with the message type, encoding and size of every
+// record in the snapshot read from r without decoding them, or a nil encoding
+// for records too large to read, for callers that
+// only need sizes and the odd field, which they can pick out with
+// snapshot.MapString. Decoding every record into maps is by far the most
+// expensive part of reading a snapshot. The encoding is only valid until fn
+// returns.
+func scanSnapshot(r io.Reader, fn func(msgType int, raw []byte, size int)) (int, error) {
+	return scanSnapshotContext(context.Background(), r, fn)
+}
+
+// scanSnapshotContext is like scanSnapshot but stops with an error once ctx
+// is done.
+func scanSnapshotContext(ctx context.Context, r io.Reader, fn func(msgType int, raw []byte, size int)) (int, error) {
+	s, err := newSnapshotScannerContext(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+	s.skipLarge = true
+	for {
+		msgType, raw, err := s.nextRaw()
+		if err == io.EOF {
+			return s.offset, nil
+		} else if err != nil {
+			return s.offset, err
+		}
+		fn(msgType, raw, s.size)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// pluginRecord is a line written to a plugin for each record.
+type pluginRecord struct {
+	Type  int         `json:"type"`
+	Name  string      `json:"name"`
+	Size  int         `json:"size"`
+	Value interface{} `json:"value"`
+}
+
+// pluginReport is a report from an external command, given with -plugin, so
+// custom analyses can be added without changing the tool. The command is sent
+// every record as a line of JSON on stdin, in the same form as decode writes
+// with its encoded size added, and once stdin is closed whatever it writes to
+// stdout is printed as its section of the report.
+type pluginReport struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	w       *bufio.Writer
+	enc     *json.Encoder
+	out     bytes.Buffer
+	// err is the first error sending records to the plugin, after which it's
+	// sent no more.
+	err error
+}
+
+// newPluginReport starts the plugin command, which is split into arguments on
+// spaces.
+func newPluginReport(command string) (*pluginReport, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty -plugin command")
+	}
+	p := &pluginReport{command: command, cmd: exec.Command(args[0], args[1:]...)}
+	p.cmd.Stdout = &p.out
+	p.cmd.Stderr = os.Stderr
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %q: %w", command, err)
+	}
+	p.stdin = stdin
+	p.w = bufio.NewWriter(stdin)
+	p.enc = json.NewEncoder(p.w)
+	p.enc.SetEscapeHTML(false)
+	return p, nil
+}
+
+func (p *pluginReport) add(msgType int, val interface{}, size int) {
+	if p.err != nil {
+		return
+	}
+	p.err = p.enc.Encode(pluginRecord{Type: msgType, Name: typeName(msgType), Size: size, Value: recordJSON(msgType, val)})
+}
+
+func (p *pluginReport) print(w io.Writer) {
+	err := p.err
+	if ferr := p.w.Flush(); err == nil {
+		err = ferr
+	}
+	p.stdin.Close()
+	if werr := p.cmd.Wait(); werr != nil {
+		// The plugin exiting explains any error writing to it.
+		err = werr
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Plugin %q failed: %s\n", p.command, err)
+		return
+	}
+	w.Write(p.out.Bytes())
+}
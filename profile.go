@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// cpuProfile is the file the CPU profile is being written to, if any.
+var cpuProfile *os.File
+
+// memProfile is the path to write a heap profile to on exit, if any.
+var memProfile string
+
+// startCPUProfile starts writing a CPU profile to path. It's called as soon
+// as the flags are parsed so the whole command is profiled.
+func startCPUProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	cpuProfile = f
+	return nil
+}
+
+// stopProfiling finishes the CPU profile and writes the heap profile, if they
+// were asked for. It must be called before exiting.
+func stopProfiling() {
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		cpuProfile.Close()
+		cpuProfile = nil
+	}
+	if memProfile != "" {
+		path := memProfile
+		memProfile = ""
+		f, err := os.Create(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// exit stops profiling and exits with code.
+func exit(code int) {
+	stopProfiling()
+	os.Exit(code)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// showProgress enables the progress bar, set with -progress.
+var showProgress = true
+
+// progressInterval is how often the progress bar is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// progressReader draws a progress bar on stderr as a file of known size is
+// read through it.
+type progressReader struct {
+	f     *os.File
+	size  int64
+	read  int64
+	start time.Time
+	drawn time.Time
+	done  bool
+}
+
+// watchProgress returns f, wrapped to show progress reading it if that's
+// enabled, stderr is a terminal and f is a regular file whose size is known.
+// For archives this is progress through the compressed file, which tracks the
+// snapshot closely enough.
+func watchProgress(f *os.File) io.ReadCloser {
+	if !showProgress || !isTerminal(os.Stderr) {
+		return f
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 {
+		return f
+	}
+	return &progressReader{f: f, size: fi.Size(), start: time.Now()}
+}
+
+// isTerminal returns true if f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.f.Read(b)
+	p.read += int64(n)
+	if err != nil {
+		p.finish()
+	} else if now := time.Now(); now.Sub(p.drawn) >= progressInterval {
+		p.drawn = now
+		p.draw(now)
+	}
+	return n, err
+}
+
+// Close clears the progress bar and closes the file.
+func (p *progressReader) Close() error {
+	p.finish()
+	return p.f.Close()
+}
+
+// draw writes the progress bar over the current line of stderr, e.g.
+//
+//	[=========>          ]  45% 1.2GB/2.6GB 85.3MB/s ETA 17s
+func (p *progressReader) draw(now time.Time) {
+	const width = 30
+	frac := float64(p.read) / float64(p.size)
+	if frac > 1 {
+		frac = 1
+	}
+	filled := int(frac * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+
+	elapsed := now.Sub(p.start).Seconds()
+	rate, eta := 0.0, "?"
+	if elapsed > 0 && p.read > 0 {
+		rate = float64(p.read) / elapsed
+		left := time.Duration(float64(p.size-p.read) / rate * float64(time.Second))
+		eta = left.Round(time.Second).String()
+	}
+	fmt.Fprintf(os.Stderr, "\r[%s] %3.0f%% %s/%s %s/s ETA %s\033[K", bar, 100*frac,
+		ByteSize(uint64(p.read)), ByteSize(uint64(p.size)), ByteSize(uint64(rate)), eta)
+}
+
+// finish clears the progress bar, if it was ever drawn, so it doesn't mix
+// with whatever is printed next.
+func (p *progressReader) finish() {
+	if p.done {
+		return
+	}
+	p.done = true
+	if !p.drawn.IsZero() {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
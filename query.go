@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// preparedQueries lists the prepared queries in a snapshot. Queries left
+// behind by old tooling are a recurring source of mystery state.
+type preparedQueries struct {
+	top int
+
+	queries  []preparedQuery
+	sessions map[string]bool
+	total    int
+}
+
+type preparedQuery struct {
+	ID, Name, Service, Session string
+	Template                   string
+	Size                       int
+}
+
+func newPreparedQueries(c *reportConfig) report {
+	return &preparedQueries{top: c.Top, sessions: make(map[string]bool)}
+}
+
+func (p *preparedQueries) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "Session":
+		p.sessions[stringField(val, "ID")] = true
+
+	case "PreparedQuery":
+		p.queries = append(p.queries, preparedQuery{
+			ID:       stringField(val, "ID"),
+			Name:     stringField(val, "Name"),
+			Service:  stringField(val, "Service", "Service"),
+			Session:  stringField(val, "Session"),
+			Template: stringField(val, "Template", "Type"),
+			Size:     size,
+		})
+		p.total += size
+	}
+}
+
+func (p *preparedQueries) print(w io.Writer) {
+	sort.Slice(p.queries, func(i, j int) bool { return p.queries[i].Size > p.queries[j].Size })
+
+	templates, orphaned := 0, 0
+	for _, q := range p.queries {
+		if q.Template != "" {
+			templates++
+		}
+		if q.Session != "" && !p.sessions[q.Session] {
+			orphaned++
+		}
+	}
+	fmt.Fprintf(w, "Prepared Queries: %d (%s), %d templates, %d with missing sessions\n\n",
+		len(p.queries), ByteSize(uint64(p.total)), templates, orphaned)
+
+	fmt.Fprintf(w, "% 10s %-36s %-22s %-22s %s\n", "Size", "ID", "Name", "Service", "Notes")
+	fmt.Fprintf(w, "%s %s %s %s %s\n", strings.Repeat("-", 10), strings.Repeat("-", 36),
+		strings.Repeat("-", 22), strings.Repeat("-", 22), strings.Repeat("-", 22))
+	for i, q := range p.queries {
+		if p.top > 0 && i >= p.top {
+			break
+		}
+		var notes []string
+		if q.Template != "" {
+			notes = append(notes, "template="+q.Template)
+		}
+		if q.Session != "" && !p.sessions[q.Session] {
+			notes = append(notes, "missing session "+q.Session)
+		}
+		fmt.Fprintf(w, "% 10s %-36s %-22s %-22s %s\n", ByteSize(uint64(q.Size)), q.ID, q.Name, q.Service, strings.Join(notes, ", "))
+	}
+}
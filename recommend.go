@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Thresholds above which recommendations are made. Below them the state in
+// question is too small a part of the snapshot to be worth acting on.
+const (
+	// recommendMinShare is the share of the snapshot a finding about size
+	// must account for.
+	recommendMinShare = 0.1
+	// recommendCheckOutputShare is the share of catalog bytes check Output
+	// must account for.
+	recommendCheckOutputShare = 0.25
+	// recommendMinTombstones is the number of tombstones worth tuning GC for
+	// whatever their size.
+	recommendMinTombstones = 100000
+	// recommendLargeValue is the KV value size worth flagging, half Consul's
+	// default kv_max_value_size.
+	recommendLargeValue = 256 * KILOBYTE
+	// recommendMinPrefix is the size a KV prefix must reach before it's
+	// worth questioning, however much of a small snapshot it is.
+	recommendMinPrefix = MEGABYTE
+)
+
+// recommendation is an action to take along with the evidence for it.
+type recommendation struct {
+	// Evidence is what was found in the snapshot.
+	Evidence string
+	// Action is what to consider doing about it.
+	Action string
+	// Size is the bytes the finding concerns, used to list the findings
+	// that matter most first.
+	Size int
+}
+
+// recommendations turns what's found in a snapshot into concrete advice for
+// operators who don't know what to look for in the other reports.
+type recommendations struct {
+	vaultPath string
+
+	total, catalog, checkOutput int
+	types                       statMap
+	kvPrefixes                  statMap
+	vault                       int
+	tombstones                  typeStats
+	largeValues                 typeStats
+	// unboundedTokens are tokens created by auth methods that never expire.
+	unboundedTokens typeStats
+	unknown         typeStats
+}
+
+func newRecommendations(c *reportConfig) report {
+	return &recommendations{
+		vaultPath:  c.VaultPath,
+		types:      make(statMap),
+		kvPrefixes: make(statMap),
+	}
+}
+
+func (r *recommendations) add(msgType int, val interface{}, size int) {
+	name := typeName(msgType)
+	r.total += size
+	r.types.add(name, size)
+	if !knownType(msgType) {
+		r.unknown.Count++
+		r.unknown.Sum += size
+	}
+
+	switch name {
+	case "Register":
+		r.catalog += size
+		if check := field(val, "Check"); check != nil {
+			r.checkOutput += len(stringField(check, "Output"))
+		}
+	case "KVS":
+		key := kvKey(val)
+		r.kvPrefixes.add(kvPrefix(key, 1), size)
+		if r.vaultPath != "" && strings.HasPrefix(key, r.vaultPath) {
+			r.vault += size
+		}
+		if len(stringField(val, "Value")) >= recommendLargeValue {
+			r.largeValues.Count++
+			r.largeValues.Sum += size
+		}
+	case "Tombstone":
+		r.tombstones.Count++
+		r.tombstones.Sum += size
+	case "ACLTokenSet":
+		if stringField(val, "AuthMethod") != "" && field(val, "ExpirationTime") == nil {
+			r.unboundedTokens.Count++
+			r.unboundedTokens.Sum += size
+		}
+	}
+}
+
+// share returns n as a fraction of the snapshot.
+func (r *recommendations) share(n int) float64 {
+	if r.total == 0 {
+		return 0
+	}
+	return float64(n) / float64(r.total)
+}
+
+// findings returns the recommendations for the snapshot, most significant
+// first.
+func (r *recommendations) findings() []recommendation {
+	var recs []recommendation
+	if r.catalog > 0 && float64(r.checkOutput)/float64(r.catalog) >= recommendCheckOutputShare {
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("Health check Output accounts for %.0f%% of catalog bytes (%s)",
+				100*float64(r.checkOutput)/float64(r.catalog), ByteSize(uint64(r.checkOutput))),
+			Action: "consider enabling discard_check_output on agents, or making verbose checks print less (see -report check-output)",
+			Size:   r.checkOutput,
+		})
+	}
+	if r.tombstones.Count >= recommendMinTombstones || r.share(r.tombstones.Sum) >= recommendMinShare {
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("%d KV tombstones take %s (%.0f%% of the snapshot)",
+				r.tombstones.Count, ByteSize(uint64(r.tombstones.Sum)), 100*r.share(r.tombstones.Sum)),
+			Action: "check tombstone GC is keeping up and consider lowering tombstone_ttl (see -report tombstones)",
+			Size:   r.tombstones.Sum,
+		})
+	}
+	if r.share(r.vault) >= recommendMinShare {
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("%s is %.0f%% of the snapshot (%s)", r.vaultPath, 100*r.share(r.vault), ByteSize(uint64(r.vault))),
+			Action:   "consider migrating Vault to integrated storage so it no longer shares Consul's raft log",
+			Size:     r.vault,
+		})
+	}
+	for _, p := range r.kvPrefixes.slice() {
+		if p.Name == r.vaultPath || p.Sum < recommendMinPrefix || r.share(p.Sum) < 2*recommendMinShare {
+			continue
+		}
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("KV prefix %s is %.0f%% of the snapshot (%s in %d keys)", p.Name, 100*r.share(p.Sum), ByteSize(uint64(p.Sum)), p.Count),
+			Action:   "check whether this data belongs in Consul or can be cleaned up (see the kv command and -report index-ranges)",
+			Size:     p.Sum,
+		})
+	}
+	if r.largeValues.Count > 0 {
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("%d KV values are larger than %s (%s in total)", r.largeValues.Count, ByteSize(recommendLargeValue), ByteSize(uint64(r.largeValues.Sum))),
+			Action:   "large values slow down raft and every watcher of them; consider storing them elsewhere and keeping a reference in KV",
+			Size:     r.largeValues.Sum,
+		})
+	}
+	if r.unboundedTokens.Count > 0 && r.share(r.unboundedTokens.Sum) >= recommendMinShare {
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("%d ACL tokens created by auth methods never expire (%s)", r.unboundedTokens.Count, ByteSize(uint64(r.unboundedTokens.Sum))),
+			Action:   "set MaxTokenTTL on auth methods so login tokens are reaped if clients don't log out (see -report acl-auth-methods)",
+			Size:     r.unboundedTokens.Sum,
+		})
+	}
+	if legacy := r.types["ACL (Deprecated)"]; legacy.Count > 0 {
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("%d legacy ACL records remain (%s)", legacy.Count, ByteSize(uint64(legacy.Sum))),
+			Action:   "migrate legacy ACL tokens before upgrading to Consul 1.11 or later, which no longer supports them (see -report acl-legacy)",
+			Size:     legacy.Sum,
+		})
+	}
+	if chunks := r.types["ChunkingStateType"]; chunks.Count > 0 {
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("%d chunked writes were only partially applied (%s)", chunks.Count, ByteSize(uint64(chunks.Sum))),
+			Action:   "the writes that left them failed; retry them if they're still needed (see -report chunking)",
+			Size:     chunks.Sum,
+		})
+	}
+	if r.unknown.Count > 0 {
+		recs = append(recs, recommendation{
+			Evidence: fmt.Sprintf("%d records are of types this tool doesn't know (%s)", r.unknown.Count, ByteSize(uint64(r.unknown.Sum))),
+			Action:   "upgrade the tool, or name the types with -consul-version or -type-map, so they can be analyzed",
+			Size:     r.unknown.Sum,
+		})
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].Size > recs[j].Size })
+	return recs
+}
+
+func (r *recommendations) print(w io.Writer) {
+	recs := r.findings()
+	fmt.Fprintln(w, "Recommendations")
+	if len(recs) == 0 {
+		fmt.Fprintln(w, "  Nothing stands out in this snapshot.")
+		return
+	}
+	for i, rec := range recs {
+		fmt.Fprintf(w, "  %d. %s: %s.\n", i+1, rec.Evidence, rec.Action)
+	}
+}
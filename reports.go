@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// report is an optional section of output, enabled with -report, that is
+// built up from the records in a snapshot.
+type report interface {
+	// add is called with every record in the snapshot.
+	add(msgType int, val interface{}, size int)
+	print(w io.Writer)
+}
+
+// reportConfig holds the options shared by all reports.
+type reportConfig struct {
+	// Top limits the number of rows listed in tables.
+	Top int
+	// MaxPolicyRules is the size above which ACL policy rules are flagged.
+	MaxPolicyRules byteSizeFlag
+	// VaultPath is the KV prefix Vault's Consul storage backend writes under.
+	VaultPath string
+	// KVDepth is the number of path segments KV keys are grouped by.
+	KVDepth int
+	// IndexTimes estimates when raft indexes were written, if samples were
+	// given with -index-times.
+	IndexTimes indexTimes
+}
+
+// reports maps the names accepted by -report to their constructors.
+var reports = map[string]func(c *reportConfig) report{
+	"acl":               newACLSummary,
+	"acl-auth-methods":  newTokenAuthMethods,
+	"acl-legacy":        newLegacyACLs,
+	"acl-rules":         newPolicyRules,
+	"areas":             newNetworkAreas,
+	"autopilot":         newAutopilotConfig,
+	"catalog":           newCatalogSummary,
+	"check-output":      newCheckOutputStats,
+	"chunking":          newChunkingState,
+	"config-entries":    newConfigEntryStats,
+	"connect-ca":        newConnectCAStats,
+	"coordinates":       newCoordinateStats,
+	"duplicate-nodes":   newDuplicateNodes,
+	"enterprise":        newEnterpriseStats,
+	"federation-states": newFederationStats,
+	"index-ranges":      newIndexRangeStats,
+	"intentions":        newIntentionStats,
+	"node-meta":         newNodeMetaStats,
+	"nodes":             newNodeStats,
+	"peering":           newPeeringStats,
+	"prepared-queries":  newPreparedQueries,
+	"proxy-config":      newProxyConfigStats,
+	"recommendations":   newRecommendations,
+	"resources":         newResourceStats,
+	"service-kinds":     newServiceKindStats,
+	"services":          newServiceStats,
+	"sessions":          newSessionStats,
+	"system-metadata":   newSystemMetadata,
+	"tenants":           newTenantStats,
+	"tombstones":        newTombstoneStats,
+	"virtual-ips":       newVirtualIPStats,
+}
+
+// reportList returns the sorted names of all reports.
+func reportList() []string {
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newReports constructs the reports named in names, each of which may be a
+// comma separated list.
+func newReports(names []string, c *reportConfig) ([]report, error) {
+	var rs []report
+	for _, list := range names {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			fn, ok := reports[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown report %q, must be one of: %s", name, strings.Join(reportList(), ", "))
+			}
+			rs = append(rs, fn(c))
+		}
+	}
+	return rs, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// resourceStats breaks down the generic v2 resources written by newer Consul
+// versions by resource type and tenancy.
+type resourceStats struct {
+	top int
+
+	types   statMap
+	tenancy statMap
+	total   int
+}
+
+func newResourceStats(c *reportConfig) report {
+	return &resourceStats{top: c.Top, types: make(statMap), tenancy: make(statMap)}
+}
+
+func (r *resourceStats) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "ResourceOperationType" {
+		return
+	}
+	r.total += size
+
+	id := field(val, "Id")
+	if id == nil {
+		// Some versions write the resource as opaque protobuf.
+		r.types.add("(opaque)", size)
+		r.tenancy.add("(opaque)", size)
+		return
+	}
+	t := field(id, "Type")
+	r.types.add(fmt.Sprintf("%s.%s.%s", stringField(t, "Group"), stringField(t, "GroupVersion"), stringField(t, "Kind")), size)
+
+	tenancy := tenantName(stringField(id, "Tenancy", "Partition"), stringField(id, "Tenancy", "Namespace"))
+	if peer := stringField(id, "Tenancy", "PeerName"); peer != "" && peer != "local" {
+		tenancy += " (peer " + peer + ")"
+	}
+	r.tenancy.add(tenancy, size)
+}
+
+func (r *resourceStats) print(w io.Writer) {
+	printTopStats(w, "Resource Type", r.types.slice(), r.total, r.top)
+	fmt.Fprintln(w)
+	printTopStats(w, "Resource Tenancy", r.tenancy.slice(), r.total, r.top)
+}
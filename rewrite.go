@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// recordEdit is what a rewriteFunc decides to do with a record.
+type recordEdit int
+
+const (
+	// keepRecord copies the record to the output unchanged.
+	keepRecord recordEdit = iota
+	// dropRecord leaves the record out of the output.
+	dropRecord
+	// replaceRecord writes the value returned by the rewriteFunc instead.
+	replaceRecord
+)
+
+// rewriteFunc decides what to do with each record while rewriting a snapshot.
+// The value it returns is only used for replaceRecord.
+type rewriteFunc func(msgType int, val interface{}) (recordEdit, interface{})
+
+// rewriteStats counts what happened to the records while rewriting a
+// snapshot, by record type.
+type rewriteStats struct {
+	Kept, Dropped, Replaced statMap
+}
+
+// snapshotWriter writes a snapshot in the same format readSnapshot reads.
+type snapshotWriter struct {
+	w io.Writer
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	return &snapshotWriter{w: w}
+}
+
+// writeHeader writes the snapshot header, which must come before any records.
+func (s *snapshotWriter) writeHeader(header snapshotHeader) error {
+	raw, err := snapshot.Encode(&header)
+	if err != nil {
+		return err
+	}
+	return s.writeRaw(raw)
+}
+
+// write encodes a record.
+func (s *snapshotWriter) write(msgType int, val interface{}) error {
+	raw, err := snapshot.Encode(val)
+	if err != nil {
+		return err
+	}
+	return s.writeRaw(append([]byte{byte(msgType)}, raw...))
+}
+
+// writeRaw copies an already encoded record, including its message type.
+func (s *snapshotWriter) writeRaw(raw []byte) error {
+	_, err := s.w.Write(raw)
+	return err
+}
+
+// rewriteSnapshot copies the snapshot read from r to w, passing every record
+// through fn. Records that are kept are copied byte for byte so only the
+// records fn replaces are re-encoded. extra, if not nil, is called after the
+// last record to append more.
+func rewriteSnapshot(r io.Reader, w io.Writer, fn rewriteFunc, extra func(*snapshotWriter) error) (*rewriteStats, error) {
+	s, err := newSnapshotScanner(r)
+	if err != nil {
+		return nil, err
+	}
+	sw := newSnapshotWriter(w)
+	if err := sw.writeRaw(s.headerRaw); err != nil {
+		return nil, err
+	}
+
+	stats := &rewriteStats{Kept: make(statMap), Dropped: make(statMap), Replaced: make(statMap)}
+	for {
+		msgType, val, err := s.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		name := typeName(msgType)
+		switch edit, newVal := fn(msgType, val); edit {
+		case dropRecord:
+			stats.Dropped.add(name, s.size)
+		case replaceRecord:
+			if err := sw.write(msgType, newVal); err != nil {
+				return nil, err
+			}
+			stats.Replaced.add(name, s.size)
+		default:
+			if err := sw.writeRaw(s.raw()); err != nil {
+				return nil, err
+			}
+			stats.Kept.add(name, s.size)
+		}
+	}
+
+	if extra != nil {
+		if err := extra(sw); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// createOutput creates the file at path for writing, or returns stdout if path
+// is "-".
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+// writeSnapshotFile rewrites the snapshot at in to out, reporting what was
+// dropped or replaced on stderr.
+func writeSnapshotFile(in string, out *snapshotOutput, fn rewriteFunc, extra func(*snapshotWriter) error) *rewriteStats {
+	r, err := openSnapshot(in)
+	if err != nil {
+		fatal(err)
+	}
+	defer r.Close()
+
+	var stats *rewriteStats
+	out.write(in, r, func(r io.Reader, w io.Writer) error {
+		var err error
+		stats, err = rewriteSnapshot(r, w, fn, extra)
+		return err
+	})
+	return stats
+}
+
+// convertFile runs fn from r to the file at out, exiting on failure.
+func convertFile(in string, r io.Reader, out string, fn func(io.Reader, io.Writer) error) {
+	f, err := createOutput(out)
+	if err != nil {
+		fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	err = fn(r, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if f != os.Stdout {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fatal(fmt.Errorf("%s: %w", in, err))
+	}
+}
+
+// printRewrite writes a summary of a rewrite.
+func printRewrite(w io.Writer, stats *rewriteStats) {
+	keptCount, keptSize := sumStats(stats.Kept)
+	droppedCount, droppedSize := sumStats(stats.Dropped)
+	replacedCount, replacedSize := sumStats(stats.Replaced)
+	fmt.Fprintf(w, "Kept %d records (%s), replaced %d (%s), dropped %d (%s)\n",
+		keptCount, ByteSize(uint64(keptSize)), replacedCount, ByteSize(uint64(replacedSize)),
+		droppedCount, ByteSize(uint64(droppedSize)))
+	if droppedCount > 0 {
+		fmt.Fprintln(w)
+		printStats(w, "Dropped", stats.Dropped.slice(), droppedSize)
+	}
+}
+
+// rewriteCommand implements `rewrite` which writes a copy of a snapshot without
+// the KV prefixes or record types given.
+func rewriteCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	var dropPrefixes, dropTypes stringsFlag
+	fs.Var(&dropPrefixes, "drop-prefix", "drop KV entries and tombstones with keys under this prefix (may be repeated)")
+	fs.Var(&dropTypes, "drop-type", "drop records of this type, by name or number (may be repeated)")
+	pruneTombstones := fs.Bool("prune-tombstones", false, "drop every KV tombstone")
+	redactSecretIDs := fs.Bool("redact-secret-ids", false, "replace the secret of every ACL token, leaving everything else as it is")
+	renameDC := fs.String("rename-datacenter", "", "rename a datacenter everywhere it's mentioned, given as old=new")
+	var maxCheckOutput byteSizeFlag
+	fs.Var(&maxCheckOutput, "max-check-output", "truncate health check output longer than this")
+	dropSessions := fs.String("drop-sessions", "", "drop sessions and release the locks they hold, either all or orphaned (those for nodes that aren't in the catalog)")
+	out := outputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool rewrite [options] <snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if *pruneTombstones {
+			dropTypes = append(dropTypes, "Tombstone")
+		}
+		if fs.NArg() != 1 || len(dropPrefixes)+len(dropTypes) == 0 && *dropSessions == "" && !*redactSecretIDs && *renameDC == "" && maxCheckOutput == 0 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		// sessions holds the IDs of the sessions to drop, or is nil to drop all
+		// of them.
+		var sessions map[string]bool
+		switch *dropSessions {
+		case "", "all":
+		case "orphaned":
+			var err error
+			if sessions, err = orphanedSessions(fs.Arg(0)); err != nil {
+				fatal(err)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "-drop-sessions must be all or orphaned, not %q\n", *dropSessions)
+			exit(exitError)
+		}
+		dropSession := func(id string) bool {
+			return *dropSessions != "" && id != "" && (sessions == nil || sessions[id])
+		}
+		released := 0
+		secrets := &sanitizer{}
+
+		var fromDC, toDC string
+		if *renameDC != "" {
+			parts := strings.SplitN(*renameDC, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				fmt.Fprintf(os.Stderr, "-rename-datacenter must be old=new, not %q\n", *renameDC)
+				exit(exitError)
+			}
+			fromDC, toDC = parts[0], parts[1]
+		}
+		renamed := 0
+		maxOutput, truncated := int(maxCheckOutput), 0
+
+		types := make(map[int]bool)
+		for _, t := range dropTypes {
+			msgType, err := parseMsgType(t)
+			if err != nil {
+				fatal(err)
+			}
+			types[msgType] = true
+		}
+
+		stats := writeSnapshotFile(fs.Arg(0), out, func(msgType int, val interface{}) (recordEdit, interface{}) {
+			if types[msgType] {
+				return dropRecord, nil
+			}
+			if isKVRecord(msgType) && hasAnyPrefix(kvKey(val), dropPrefixes) {
+				return dropRecord, nil
+			}
+			if typeName(msgType) == "Session" && dropSession(stringField(val, "ID")) {
+				return dropRecord, nil
+			}
+
+			changed := false
+			switch typeName(msgType) {
+			case "KVS":
+				if dropSession(stringField(val, "Session")) {
+					setField(val, "", "Session")
+					released++
+					changed = true
+				}
+			case "ACLTokenSet", "ACL (Deprecated)":
+				if *redactSecretIDs && secrets.replaceSecrets(val, secretFields[typeName(msgType)]) {
+					changed = true
+				}
+			case "Register":
+				if output := stringField(val, "Check", "Output"); maxOutput > 0 && len(output) > maxOutput {
+					setField(val, truncateOutput(output, maxOutput), "Check", "Output")
+					truncated++
+					changed = true
+				}
+			}
+			if fromDC != "" && typeName(msgType) != "KVS" && renameDatacenter(val, fromDC, toDC) {
+				renamed++
+				changed = true
+			}
+			if changed {
+				return replaceRecord, val
+			}
+			return keepRecord, nil
+		}, nil)
+		printRewrite(os.Stderr, stats)
+		if released > 0 {
+			fmt.Fprintf(os.Stderr, "\nReleased %d locks held by dropped sessions\n", released)
+		}
+		if *redactSecretIDs {
+			fmt.Fprintf(os.Stderr, "\nRedacted %d ACL token secrets\n", secrets.secrets)
+		}
+		if maxOutput > 0 {
+			fmt.Fprintf(os.Stderr, "\nTruncated the output of %d health checks to %s\n", truncated, ByteSize(uint64(maxOutput)))
+		}
+		if *renameDC != "" {
+			fmt.Fprintf(os.Stderr, "\nRenamed datacenter %q to %q in %d records\n", fromDC, toDC, renamed)
+		}
+		if *pruneTombstones {
+			t := stats.Dropped["Tombstone"]
+			fmt.Fprintf(os.Stderr, "\nReclaimed %s by pruning %d tombstones\n", ByteSize(uint64(t.Sum)), t.Count)
+		}
+	}
+}
+
+// parseMsgType parses a record type given by name, e.g. Session, or number.
+func parseMsgType(s string) (int, error) {
+	for i, name := range typeOverrides {
+		if strings.EqualFold(name, s) {
+			return i, nil
+		}
+	}
+	for i := range typeNames {
+		if strings.EqualFold(typeName(i), s) {
+			return i, nil
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < 256 {
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+// isKVRecord returns true for KV entries and their tombstones.
+func isKVRecord(msgType int) bool {
+	name := typeName(msgType)
+	return name == "KVS" || name == "Tombstone"
+}
+
+// hasAnyPrefix returns true if key starts with any of prefixes.
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateOutput cuts check output down to at most max bytes, including a
+// marker so it's obvious it was truncated. Cuts are made on a UTF-8 boundary.
+func truncateOutput(output string, max int) string {
+	const marker = "\n... (truncated)"
+	n := max - len(marker)
+	if n < 0 {
+		return output[:max]
+	}
+	for n > 0 && !utf8.RuneStart(output[n]) {
+		n--
+	}
+	return output[:n] + marker
+}
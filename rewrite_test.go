@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// testLockedKV returns a KV entry locked by the given session.
+func testLockedKV(key, session string, index uint64) testRecord {
+	return testRecord{snapshot.KVSType, &testDirEntry{Key: key, Session: session, LockIndex: 1, RaftIndex: RaftIndex{CreateIndex: index, ModifyIndex: index}}}
+}
+
+func TestRewriteSnapshot(t *testing.T) {
+	in := testSnapshot(t, 100,
+		testKV("app/a", "1", 5, 5),
+		testKV("app/b", "2", 6, 6),
+		testKV("app/c", "3", 7, 7),
+	)
+	var out bytes.Buffer
+	stats, err := rewriteSnapshot(bytes.NewReader(in), &out, func(msgType int, val interface{}) (recordEdit, interface{}) {
+		switch kvKey(val) {
+		case "app/a":
+			return dropRecord, nil
+		case "app/b":
+			setField(val, "replaced", "Value")
+			return replaceRecord, val
+		}
+		return keepRecord, nil
+	}, func(sw *snapshotWriter) error {
+		return sw.write(snapshot.KVSType, map[string]interface{}{"Key": "app/extra"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Dropped["KVS"].Count != 1 || stats.Replaced["KVS"].Count != 1 || stats.Kept["KVS"].Count != 1 {
+		t.Errorf("got stats %+v, want one record dropped, replaced and kept", stats)
+	}
+
+	records := readTestSnapshot(t, writeTestSnapshot(t, out.Bytes()))
+	var keys []string
+	for _, rec := range records {
+		keys = append(keys, kvKey(rec.val))
+	}
+	if len(keys) != 3 || keys[0] != "app/b" || keys[1] != "app/c" || keys[2] != "app/extra" {
+		t.Fatalf("got keys %q, want app/b, app/c and app/extra", keys)
+	}
+	if got := stringField(records[0].val, "Value"); got != "replaced" {
+		t.Errorf("got app/b value %q, want it replaced", got)
+	}
+	// Kept records are copied byte for byte, header included.
+	h, err := snapshot.Open(bytes.NewReader(out.Bytes()))
+	if err != nil || h.Header().LastIndex != 100 {
+		t.Errorf("got header %+v, %v, want LastIndex 100", h, err)
+	}
+	last := testSnapshot(t, 100, testKV("app/c", "3", 7, 7))
+	head := testSnapshot(t, 100)
+	if !bytes.Contains(out.Bytes(), last[len(head):]) {
+		t.Error("app/c wasn't copied unchanged")
+	}
+}
+
+func TestRewriteCommand(t *testing.T) {
+	in := writeTestSnapshot(t, testSnapshot(t, 100,
+		testRecord{snapshot.RegisterType, map[string]interface{}{"Node": "node-1", "Address": "10.0.0.1"}},
+		testRecord{sessionType, map[string]interface{}{"ID": "s1", "Node": "node-1", "CreateIndex": 8, "ModifyIndex": 8}},
+		testLockedKV("app/lock", "s1", 9),
+		testKV("app/config", "v", 10, 10),
+		testKV("old/x", "v", 11, 11),
+		testTombstone("old/y", 12),
+	))
+	out := filepath.Join(t.TempDir(), "out.bin")
+	runCommand(t, "rewrite", "-drop-prefix", "old/", "-drop-sessions", "all", "-o", out, in)
+
+	var names []string
+	for _, rec := range readTestSnapshot(t, out) {
+		name := typeName(rec.msgType)
+		switch name {
+		case "Session":
+			t.Error("session wasn't dropped")
+		case "KVS", "Tombstone":
+			name += " " + kvKey(rec.val)
+		}
+		if kvKey(rec.val) == "app/lock" {
+			if s := stringField(rec.val, "Session"); s != "" {
+				t.Errorf("app/lock is still locked by %q", s)
+			}
+		}
+		names = append(names, name)
+	}
+	want := []string{"Register", "KVS app/lock", "KVS app/config"}
+	if len(names) != len(want) {
+		t.Fatalf("got records %q, want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got records %q, want %q", names, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sampleFlag is the N of a -sample 1/N flag. 1 means every record is used.
+type sampleFlag int
+
+func (f *sampleFlag) String() string {
+	if *f <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("1/%d", int(*f))
+}
+
+func (f *sampleFlag) Set(v string) error {
+	n, err := strconv.Atoi(strings.TrimPrefix(v, "1/"))
+	if err != nil || n < 1 {
+		return fmt.Errorf("expected 1/N with N a positive whole number")
+	}
+	*f = sampleFlag(n)
+	return nil
+}
+
+// sampler picks 1 in every n records of each type. Picking systematically
+// rather than at random spreads the sample evenly across the snapshot, and
+// since records of a type are written in key order, across the keyspace too.
+type sampler struct {
+	n    int
+	seen map[int]int
+}
+
+func newSampler(n int) *sampler {
+	return &sampler{n: n, seen: make(map[int]int)}
+}
+
+// take returns true if the next record of msgType is in the sample.
+func (s *sampler) take(msgType int) bool {
+	i := s.seen[msgType]
+	s.seen[msgType] = i + 1
+	return i%s.n == 0
+}
+
+// sampleError returns the relative margin of error, at 95% confidence, of a
+// count estimated from k of the records sampled at 1 in n.
+func sampleError(k, n int) float64 {
+	if k == 0 || n <= 1 {
+		return 0
+	}
+	return 1.96 * math.Sqrt((1-1/float64(n))/float64(k))
+}
+
+// printSampledStats is printStats for breakdowns estimated from a sample,
+// adding the margin of error of each row given the samples it came from.
+func printSampledStats(w io.Writer, heading string, ss statSlice, samples map[string]int, n, total int) {
+	width := len(heading)
+	if width < 22 {
+		width = 22
+	}
+	for _, s := range ss {
+		if len(s.Name) > width {
+			width = len(s.Name)
+		}
+	}
+	fmt.Fprintf(w, "% *s % 8s % 12s % 8s\n", width, heading, "~Count", "~Total Size", "+/-95%")
+	fmt.Fprintf(w, "%s %s %s %s\n", strings.Repeat("-", width), strings.Repeat("-", 8), strings.Repeat("-", 12), strings.Repeat("-", 8))
+	sort.Sort(ss)
+	for _, s := range ss {
+		margin := fmt.Sprintf("%.0f%%", 100*sampleError(samples[s.Name], n))
+		fmt.Fprintf(w, "% *s % 8d % 12s % 8s\n", width, s.Name, s.Count, ByteSize(uint64(s.Sum)), margin)
+	}
+	fmt.Fprintf(w, "%s %s %s\n", strings.Repeat("-", width), strings.Repeat("-", 8), strings.Repeat("-", 12))
+	fmt.Fprintf(w, "%s % 8s % 12s\n", strings.Repeat(" ", width), "TOTAL:", ByteSize(uint64(total)))
+	fmt.Fprintf(w, "Estimated from 1 in %d entries. Prefixes with few entries in the sample have wide margins.\n", n)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// secretFields lists the fields holding credentials or private key material
+// in each record type, as paths through the decoded record.
+var secretFields = map[string][][]string{
+	"ACL (Deprecated)":            {{"ID"}},
+	"ACLTokenSet":                 {{"SecretID"}},
+	"ConnectCA":                   {{"SigningKey"}},
+	"ConnectCAProviderState":      {{"PrivateKey"}},
+	"ConnectCAConfig":             {{"Config", "PrivateKey"}, {"Config", "Token"}, {"Config", "SecretAccessKey"}, {"State", "PrivateKey"}},
+	"ACLAuthMethodSetRequestType": {{"Config", "OIDCClientSecret"}, {"Config", "ServiceAccountJWT"}},
+	"PreparedQuery":               {{"Token"}},
+	"PeeringSecretsWriteType":     {{"Establishment", "SecretID"}, {"Stream", "ActiveSecretID"}, {"Stream", "PendingSecretID"}},
+}
+
+// sanitizer replaces KV values and secrets in records with placeholders of
+// the same length, so the sanitized snapshot has the same structure and
+// sizes as the original.
+type sanitizer struct {
+	// secrets counts the secrets replaced so each gets a distinct
+	// placeholder. Secrets like token SecretIDs are uniquely indexed so they
+	// can't all be replaced with the same value.
+	secrets int
+
+	// anon, if set, also replaces node, service and key names.
+	anon *anonymizer
+}
+
+func (s *sanitizer) sanitize(msgType int, val interface{}) (recordEdit, interface{}) {
+	name := typeName(msgType)
+	changed := false
+	if name == "KVS" {
+		if v := stringField(val, "Value"); v != "" {
+			changed = setField(val, strings.Repeat("x", len(v)), "Value")
+		}
+	}
+	if s.replaceSecrets(val, secretFields[name]) {
+		changed = true
+	}
+	if s.anon != nil {
+		if v, ok := s.anon.anonymize(msgType, val); ok {
+			val, changed = v, true
+		}
+	}
+	if !changed {
+		return keepRecord, nil
+	}
+	return replaceRecord, val
+}
+
+// replaceSecrets replaces the strings at each path in val with a distinct
+// placeholder, returning true if any were replaced.
+func (s *sanitizer) replaceSecrets(val interface{}, paths [][]string) bool {
+	changed := false
+	for _, path := range paths {
+		v := stringField(val, path...)
+		if v == "" {
+			continue
+		}
+		s.secrets++
+		changed = setField(val, placeholder(v, s.secrets), path...) || changed
+	}
+	return changed
+}
+
+// placeholder returns a string the same length as s made up of the digits of
+// n, keeping any dashes where they are so UUIDs still look like UUIDs.
+func placeholder(s string, n int) string {
+	digits := strconv.Itoa(n)
+	b := []byte(s)
+	j := len(digits) - 1
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] == '-' {
+			continue
+		}
+		if j >= 0 {
+			b[i] = digits[j]
+			j--
+		} else {
+			b[i] = '0'
+		}
+	}
+	return string(b)
+}
+
+// sanitizeCommand implements `sanitize` which writes a copy of a snapshot with
+// every KV value and secret replaced, suitable for sharing with support.
+func sanitizeCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	out := outputFlags(fs)
+	anonymize := fs.Bool("anonymize", false, "also replace node names, service names, check names and KV key segments with pseudonyms, including those in config entries")
+	salt := fs.String("salt", "", "secret used to derive pseudonyms, use the same salt to get the same pseudonyms for other snapshots (default random)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool sanitize [options] <snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		s := &sanitizer{}
+		if *anonymize {
+			if *salt == "" {
+				b := make([]byte, 16)
+				if _, err := rand.Read(b); err != nil {
+					fatal(err)
+				}
+				*salt = hex.EncodeToString(b)
+			}
+			s.anon = newAnonymizer(*salt)
+		}
+		stats := writeSnapshotFile(fs.Arg(0), out, s.sanitize, nil)
+		printRewrite(os.Stderr, stats)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// testSanitizeSnapshot returns a snapshot with a KV value and a token secret
+// to sanitize.
+func testSanitizeSnapshot(t *testing.T) string {
+	return writeTestSnapshot(t, testSnapshot(t, 100,
+		testRecord{snapshot.RegisterType, map[string]interface{}{"Node": "node-1", "Address": "10.0.0.1"}},
+		testKV("app/config", "password=hunter2", 5, 5),
+		testKV("app/empty", "", 6, 6),
+		testRecord{aclTokenSetType, map[string]interface{}{
+			"AccessorID": "6a1253d2-1785-24fd-91c2-f8e78c745511",
+			"SecretID":   "45a3bd52-07c7-47a4-52fd-0745e0cfe967",
+		}},
+	))
+}
+
+func TestSanitizeCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.bin")
+	runCommand(t, "sanitize", "-o", out, testSanitizeSnapshot(t))
+
+	records := readTestSnapshot(t, out)
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want all 4", len(records))
+	}
+	if got := stringField(records[0].val, "Node"); got != "node-1" {
+		t.Errorf("got node %q, want names left alone without -anonymize", got)
+	}
+	if got := stringField(records[1].val, "Value"); got != strings.Repeat("x", len("password=hunter2")) {
+		t.Errorf("got value %q, want it replaced with as many x's", got)
+	}
+	if got := kvKey(records[1].val); got != "app/config" {
+		t.Errorf("got key %q, want it unchanged", got)
+	}
+	if got := stringField(records[2].val, "Value"); got != "" {
+		t.Errorf("got empty value replaced with %q", got)
+	}
+	secret := stringField(records[3].val, "SecretID")
+	if secret == "45a3bd52-07c7-47a4-52fd-0745e0cfe967" || len(secret) != 36 || strings.Count(secret, "-") != 4 {
+		t.Errorf("got SecretID %q, want a placeholder shaped like a UUID", secret)
+	}
+	if got := stringField(records[3].val, "AccessorID"); got != "6a1253d2-1785-24fd-91c2-f8e78c745511" {
+		t.Errorf("got AccessorID %q, want it unchanged", got)
+	}
+}
+
+func TestSanitizeAnonymize(t *testing.T) {
+	in := testSanitizeSnapshot(t)
+	dir := t.TempDir()
+	for _, out := range []string{"a.bin", "b.bin"} {
+		runCommand(t, "sanitize", "-anonymize", "-salt", "s3cret", "-o", filepath.Join(dir, out), in)
+	}
+
+	a := newAnonymizer("s3cret")
+	records := readTestSnapshot(t, filepath.Join(dir, "a.bin"))
+	if got := stringField(records[0].val, "Node"); got != a.name("node-1") {
+		t.Errorf("got node %q, want %q", got, a.name("node-1"))
+	}
+	if got := kvKey(records[1].val); got != a.key("app/config") || strings.Contains(got, "config") {
+		t.Errorf("got key %q, want %q", got, a.key("app/config"))
+	}
+	if got := stringField(records[1].val, "Value"); got != strings.Repeat("x", len("password=hunter2")) {
+		t.Errorf("got value %q, want it replaced", got)
+	}
+
+	// The same salt gives the same pseudonyms.
+	other := readTestSnapshot(t, filepath.Join(dir, "b.bin"))
+	if kvKey(other[1].val) != kvKey(records[1].val) {
+		t.Errorf("got keys %q and %q from the same salt", kvKey(records[1].val), kvKey(other[1].val))
+	}
+}
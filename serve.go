@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotServer serves `serve`, analyzing snapshots uploaded to it or fetched
+// from URLs it's allowed to fetch from.
+type snapshotServer struct {
+	// maxUpload is the largest snapshot accepted, 0 for no limit.
+	maxUpload int64
+	// timeout limits how long fetching and analyzing a snapshot can take, 0
+	// for no limit.
+	timeout time.Duration
+	// allowURLs are the prefixes of URLs snapshots may be fetched from. URLs
+	// are only fetched if there are any so the server can't be used to make
+	// requests to arbitrary hosts.
+	allowURLs []string
+}
+
+// serveCommand implements `serve`, an HTTP server that analyzes snapshots
+// posted to /analyze so a team can share one instance of the tool.
+func serveCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	maxUpload := byteSizeFlag(0)
+	fs.Var(&maxUpload, "max-upload", "largest snapshot to accept, 0 for no limit")
+	timeout := fs.Duration("timeout", 10*time.Minute, "longest to spend fetching and analyzing a snapshot, 0 for no limit")
+	var allowURLs stringsFlag
+	fs.Var(&allowURLs, "allow-url", "URL prefix snapshots may be fetched from with ?url=, e.g. s3://backups/consul/ (may be repeated)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool serve [options]")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 0 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		s := &snapshotServer{maxUpload: int64(maxUpload), timeout: *timeout, allowURLs: allowURLs}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", s.handleIndex)
+		mux.HandleFunc("/analyze", s.handleAnalyze)
+		fmt.Fprintf(os.Stderr, "Serving on http://%s/\n", *addr)
+		if err := http.ListenAndServe(*addr, mux); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// handleAnalyze analyzes the snapshot in the request body, or at the URL given
+// with ?url=, and responds with its report as JSON. The snapshot may be a raw
+// state.bin or a backup archive.
+func (s *snapshotServer) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+	depth := 1
+	if v := r.URL.Query().Get("kv_depth"); v != "" {
+		var err error
+		if depth, err = strconv.Atoi(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid kv_depth: %s", err))
+			return
+		}
+	}
+
+	// The request's context is cancelled if the client goes away.
+	ctx := r.Context()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		// Reads from the upload don't watch ctx so they need a deadline of
+		// their own.
+		http.NewResponseController(w).SetReadDeadline(time.Now().Add(s.timeout))
+	}
+
+	body := r.Body
+	if u := r.URL.Query().Get("url"); u != "" {
+		resp, status, err := s.fetch(ctx, u)
+		if err != nil {
+			writeJSONError(w, status, err)
+			return
+		}
+		defer resp.Body.Close()
+		body = resp.Body
+	}
+	if s.maxUpload > 0 {
+		body = http.MaxBytesReader(w, body, s.maxUpload)
+	}
+
+	sr, err := snapshotReader(body)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	rep, err := analyzeSnapshot(ctx, sr, depth)
+	if err != nil {
+		status := http.StatusBadRequest
+		var snapErr *snapshotError
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusServiceUnavailable
+		} else if errors.As(err, &snapErr) && !snapErr.IO {
+			status = http.StatusUnprocessableEntity
+		}
+		writeJSONError(w, status, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+}
+
+// fetch starts downloading the snapshot at u, which must start with one of
+// the allowed prefixes. s3://bucket/key URLs are fetched from the bucket's
+// public endpoint so only work for objects readable without credentials;
+// pre-signed https URLs work for anything. On failure it returns the HTTP
+// status to respond with.
+func (s *snapshotServer) fetch(ctx context.Context, u string) (*http.Response, int, error) {
+	if !hasAnyPrefix(u, s.allowURLs) {
+		return nil, http.StatusForbidden, fmt.Errorf("fetching %s isn't allowed, see -allow-url", u)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	switch parsed.Scheme {
+	case "s3":
+		u = "https://" + parsed.Host + ".s3.amazonaws.com/" + strings.TrimPrefix(parsed.Path, "/")
+	case "http", "https":
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("unsupported URL scheme %q", parsed.Scheme)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, http.StatusBadGateway, fmt.Errorf("fetching %s: %s: %s", u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, 0, nil
+}
+
+// writeJSONError responds with err as a JSON object with the given status.
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func (s *snapshotServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, indexHTML)
+}
+
+// indexHTML is a minimal page for uploading a snapshot, or giving its URL, and
+// showing the breakdown.
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Consul Snapshot Inspection Tool</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.2em 1em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+tr:nth-child(even) { background: #f4f4f4; }
+#error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Consul Snapshot Inspection Tool</h1>
+<form id="form">
+<p><input type="file" id="file"> or URL <input type="text" id="url" size="60"></p>
+<p>KV depth <input type="number" id="depth" value="1" min="0"> <button type="submit">Analyze</button></p>
+</form>
+<p id="error"></p>
+<div id="report"></div>
+<script>
+function size(b) {
+  var units = ["B", "KB", "MB", "GB", "TB"], i = 0;
+  while (b >= 1024 && i < units.length - 1) { b /= 1024; i++; }
+  return (i ? b.toFixed(1) : b) + units[i];
+}
+function table(heading, rows, name) {
+  var t = document.createElement("table");
+  var h = t.insertRow();
+  [heading, "Count", "Total Size"].forEach(function (s) {
+    var th = document.createElement("th"); th.textContent = s; h.appendChild(th);
+  });
+  rows.forEach(function (r) {
+    var tr = t.insertRow();
+    [r[name], r.count, size(r.size)].forEach(function (s) { tr.insertCell().textContent = s; });
+  });
+  return t;
+}
+document.getElementById("form").onsubmit = function (e) {
+  e.preventDefault();
+  var file = document.getElementById("file").files[0];
+  var u = document.getElementById("url").value;
+  var q = "?kv_depth=" + encodeURIComponent(document.getElementById("depth").value);
+  if (u) { q += "&url=" + encodeURIComponent(u); }
+  var err = document.getElementById("error"), out = document.getElementById("report");
+  err.textContent = "Analyzing...";
+  out.innerHTML = "";
+  fetch("/analyze" + q, {method: "POST", body: u ? "" : file}).then(function (resp) {
+    return resp.json();
+  }).then(function (rep) {
+    if (rep.error) { err.textContent = rep.error; return; }
+    err.textContent = rep.records + " records, " + size(rep.size);
+    out.appendChild(table("Record Type", rep.types, "name"));
+    out.appendChild(table("KV Prefix", rep.kv_prefixes, "prefix"));
+  }).catch(function (e) { err.textContent = e; });
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// sessionStats summarises sessions. Leaked sessions are a classic cause of
+// lock problems that are only visible in the state store.
+type sessionStats struct {
+	top int
+
+	nodes     statMap
+	ttls      map[string]int
+	behaviors map[string]int
+	total     int
+
+	// checks holds the node/checkID of every registered check.
+	checks   map[string]bool
+	catalog  map[string]bool
+	sessions []session
+}
+
+type session struct {
+	ID, Node string
+	Checks   []string
+}
+
+func newSessionStats(c *reportConfig) report {
+	return &sessionStats{
+		top:       c.Top,
+		nodes:     make(statMap),
+		ttls:      make(map[string]int),
+		behaviors: make(map[string]int),
+		checks:    make(map[string]bool),
+		catalog:   make(map[string]bool),
+	}
+}
+
+// stringList returns the strings in a decoded list.
+func stringList(v interface{}) []string {
+	l, _ := v.([]interface{})
+	ss := make([]string, 0, len(l))
+	for _, s := range l {
+		if s, ok := s.(string); ok {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+func (s *sessionStats) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "Register":
+		node := stringField(val, "Node")
+		s.catalog[node] = true
+		if check := field(val, "Check"); check != nil {
+			s.checks[node+"/"+stringField(check, "CheckID")] = true
+		}
+
+	case "Session":
+		node := stringField(val, "Node")
+		s.nodes.add(node, size)
+		s.total += size
+
+		ttl := stringField(val, "TTL")
+		if ttl == "" {
+			ttl = "(none)"
+		}
+		s.ttls[ttl]++
+		behavior := stringField(val, "Behavior")
+		if behavior == "" {
+			behavior = "release"
+		}
+		s.behaviors[behavior]++
+
+		// Sessions written by older versions have Checks rather than
+		// NodeChecks and ServiceChecks.
+		checks := append(stringList(field(val, "Checks")), stringList(field(val, "NodeChecks"))...)
+		services, _ := field(val, "ServiceChecks").([]interface{})
+		for _, sc := range services {
+			checks = append(checks, stringField(sc, "ID"))
+		}
+		s.sessions = append(s.sessions, session{ID: stringField(val, "ID"), Node: node, Checks: checks})
+	}
+}
+
+func (s *sessionStats) print(w io.Writer) {
+	fmt.Fprintf(w, "Sessions: %d (%s)\n", len(s.sessions), ByteSize(uint64(s.total)))
+	for _, b := range sortedKeys(s.behaviors) {
+		fmt.Fprintf(w, "  behavior %s: %d\n", b, s.behaviors[b])
+	}
+
+	fmt.Fprintln(w)
+	printTopStats(w, "Node", s.nodes.slice(), s.total, s.top)
+
+	fmt.Fprintln(w)
+	printCounts(w, "TTL", "Sessions", s.ttls, nil, s.top)
+
+	var problems []string
+	for _, sess := range s.sessions {
+		if !s.catalog[sess.Node] {
+			problems = append(problems, fmt.Sprintf("%s: node %q is not registered", sess.ID, sess.Node))
+		}
+		var missing []string
+		for _, c := range sess.Checks {
+			if !s.checks[sess.Node+"/"+c] {
+				missing = append(missing, c)
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: missing checks %s", sess.ID, strings.Join(missing, ", ")))
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Sessions referencing missing nodes or checks: %d\n", len(problems))
+	for i, p := range problems {
+		if s.top > 0 && i >= s.top {
+			fmt.Fprintf(w, "  ... and %d more\n", len(problems)-i)
+			break
+		}
+		fmt.Fprintf(w, "  %s\n", p)
+	}
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// orphanedSessions returns the IDs of the sessions in the snapshot at path
+// that belong to nodes that aren't in the catalog. Such sessions can never be
+// invalidated by their node's health checks so their locks are held forever.
+func orphanedSessions(path string) (map[string]bool, error) {
+	if path == "-" {
+		return nil, fmt.Errorf("finding orphaned sessions needs to read the snapshot twice so it can't be read from STDIN")
+	}
+	r, err := openSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	s, err := newSnapshotScanner(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	catalog := make(map[string]bool)
+	nodes := make(map[string]string)
+	for {
+		msgType, val, err := s.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		switch typeName(msgType) {
+		case "Register":
+			catalog[stringField(val, "Node")] = true
+		case "Session":
+			nodes[stringField(val, "ID")] = stringField(val, "Node")
+		}
+	}
+
+	orphaned := make(map[string]bool)
+	for id, node := range nodes {
+		if !catalog[node] {
+			orphaned[id] = true
+		}
+	}
+	return orphaned, nil
+}
@@ -0,0 +1,34 @@
+package snapshot
+
+import (
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// DecodeConfigEntryRequest decodes a config entry record that was decoded
+// to bytes or a string, as they are since Consul writes them with
+// ConfigEntryRequest's MarshalBinary: the entry's Kind followed by the whole
+// request, with its Op, Datacenter and Entry.
+func DecodeConfigEntryRequest(b []byte) (kind string, req map[interface{}]interface{}, err error) {
+	dec := codec.NewDecoderBytes(b, msgpackHandle)
+	if err := dec.Decode(&kind); err != nil {
+		return "", nil, err
+	}
+	if err := dec.Decode(&req); err != nil {
+		return "", nil, err
+	}
+	return kind, req, nil
+}
+
+// EncodeConfigEntryRequest is the inverse of DecodeConfigEntryRequest,
+// encoding the Kind and request the way MarshalBinary does.
+func EncodeConfigEntryRequest(kind string, req map[interface{}]interface{}) ([]byte, error) {
+	var b []byte
+	enc := codec.NewEncoderBytes(&b, msgpackHandle)
+	if err := enc.Encode(kind); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(req); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
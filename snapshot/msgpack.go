@@ -0,0 +1,267 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// byteReader is what readMsgpackValue needs to read from.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readChunkSize limits how much is read into memory at once for a single
+// string or binary value, so a corrupt length near the end of a truncated
+// snapshot fails with io.ErrUnexpectedEOF rather than a huge allocation.
+const readChunkSize = 64 << 10
+
+// msgpackKind says what follows the type byte of a msgpack value.
+type msgpackKind int
+
+const (
+	// msgpackData is followed by n bytes of data, such as a string.
+	msgpackData msgpackKind = iota
+	// msgpackArray is followed by n values.
+	msgpackArray
+	// msgpackMap is followed by n keys and values.
+	msgpackMap
+)
+
+// msgpackHead describes the value with type byte b. If lenSize isn't zero the
+// type byte is followed by a big endian length of that many bytes, which is
+// added to n.
+func msgpackHead(b byte) (kind msgpackKind, lenSize int, n uint64, err error) {
+	switch {
+	case b <= 0x7f, b >= 0xe0, b == 0xc0, b == 0xc2, b == 0xc3:
+		// Fixed ints, nil and bools are just the type byte.
+		return msgpackData, 0, 0, nil
+	case b >= 0x80 && b <= 0x8f:
+		return msgpackMap, 0, uint64(b & 0x0f), nil
+	case b >= 0x90 && b <= 0x9f:
+		return msgpackArray, 0, uint64(b & 0x0f), nil
+	case b >= 0xa0 && b <= 0xbf:
+		return msgpackData, 0, uint64(b & 0x1f), nil
+	case b == 0xc4, b == 0xd9:
+		return msgpackData, 1, 0, nil
+	case b == 0xc5, b == 0xda:
+		return msgpackData, 2, 0, nil
+	case b == 0xc6, b == 0xdb:
+		return msgpackData, 4, 0, nil
+	case b >= 0xc7 && b <= 0xc9:
+		// Extensions have a type byte after the length.
+		return msgpackData, 1 << (b - 0xc7), 1, nil
+	case b == 0xca:
+		return msgpackData, 0, 4, nil
+	case b == 0xcb:
+		return msgpackData, 0, 8, nil
+	case b >= 0xcc && b <= 0xcf:
+		return msgpackData, 0, 1 << (b - 0xcc), nil
+	case b >= 0xd0 && b <= 0xd3:
+		return msgpackData, 0, 1 << (b - 0xd0), nil
+	case b >= 0xd4 && b <= 0xd8:
+		return msgpackData, 0, 1<<(b-0xd4) + 1, nil
+	case b == 0xdc, b == 0xdd:
+		return msgpackArray, 2 << (b - 0xdc), 0, nil
+	case b == 0xde, b == 0xdf:
+		return msgpackMap, 2 << (b - 0xde), 0, nil
+	}
+	return 0, 0, 0, fmt.Errorf("invalid msgpack type byte 0x%02x", b)
+}
+
+// readMsgpackValue reads the encoding of a single msgpack value from r,
+// appending it to buf, without decoding it. This frames the records in a
+// snapshot so they can be decoded elsewhere, or not at all.
+//
+// If max isn't zero and the value is longer than max bytes the rest of it is
+// read and thrown away, and ok is false, so a huge or corrupt value doesn't
+// have to fit in memory.
+func readMsgpackValue(r byteReader, buf []byte, max int) (out []byte, ok bool, err error) {
+	start := len(buf)
+	over := false
+	// Rather than recursing into maps and arrays just keep track of how
+	// many more values there are to read.
+	for pending := uint64(1); pending > 0; pending-- {
+		if over {
+			// Only keep the header of the current value around.
+			buf = buf[:start]
+		}
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return buf, false, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return buf, false, err
+		}
+		buf = append(buf, b)
+
+		kind, lenSize, n, err := msgpackHead(b)
+		if err != nil {
+			return buf, false, err
+		}
+		if lenSize > 0 {
+			l := len(buf)
+			if buf, err = readBytes(r, buf, uint64(lenSize)); err != nil {
+				return buf, false, err
+			}
+			n += bigEndian(buf[l:])
+		}
+		switch kind {
+		case msgpackArray:
+			pending += n
+		case msgpackMap:
+			pending += 2 * n
+		default:
+			if max > 0 && uint64(len(buf)-start)+n > uint64(max) {
+				over = true
+			}
+			if over {
+				if _, err := io.CopyN(ioutil.Discard, r, int64(n)); err != nil {
+					if err == io.EOF {
+						err = io.ErrUnexpectedEOF
+					}
+					return buf, false, err
+				}
+			} else if buf, err = readBytes(r, buf, n); err != nil {
+				return buf, false, err
+			}
+		}
+		if max > 0 && len(buf)-start > max {
+			over = true
+		}
+	}
+	if over {
+		return buf[:start], false, nil
+	}
+	return buf, true, nil
+}
+
+// skipMsgpackValue returns the length of the msgpack value at the start of b.
+func skipMsgpackValue(b []byte) (int, error) {
+	i := 0
+	for pending := uint64(1); pending > 0; pending-- {
+		if i >= len(b) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		kind, lenSize, n, err := msgpackHead(b[i])
+		if err != nil {
+			return 0, err
+		}
+		i++
+		if lenSize > 0 {
+			if len(b)-i < lenSize {
+				return 0, io.ErrUnexpectedEOF
+			}
+			n += bigEndian(b[i : i+lenSize])
+			i += lenSize
+		}
+		switch kind {
+		case msgpackArray:
+			pending += n
+		case msgpackMap:
+			pending += 2 * n
+		default:
+			if uint64(len(b)-i) < n {
+				return 0, io.ErrUnexpectedEOF
+			}
+			i += int(n)
+		}
+	}
+	return i, nil
+}
+
+// msgpackString returns the string at the start of b, which must be a msgpack
+// string or binary value, without copying it.
+func msgpackString(b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	t := b[0]
+	if !(t >= 0xa0 && t <= 0xbf || t >= 0xc4 && t <= 0xc6 || t >= 0xd9 && t <= 0xdb) {
+		return nil, false
+	}
+	_, lenSize, n, _ := msgpackHead(t)
+	b = b[1:]
+	if lenSize > 0 {
+		if len(b) < lenSize {
+			return nil, false
+		}
+		n = bigEndian(b[:lenSize])
+		b = b[lenSize:]
+	}
+	if uint64(len(b)) < n {
+		return nil, false
+	}
+	return b[:n], true
+}
+
+// MapString returns the string value of the given key in the msgpack map
+// encoded in b, such as the Body of a record, skipping over everything else
+// without decoding it. This is much cheaper than decoding a whole record just
+// to read one field of it.
+func MapString(b []byte, key string) (string, bool) {
+	if len(b) == 0 {
+		return "", false
+	}
+	kind, lenSize, n, err := msgpackHead(b[0])
+	if err != nil || kind != msgpackMap {
+		return "", false
+	}
+	b = b[1:]
+	if lenSize > 0 {
+		if len(b) < lenSize {
+			return "", false
+		}
+		n = bigEndian(b[:lenSize])
+		b = b[lenSize:]
+	}
+	for ; n > 0; n-- {
+		k, ok := msgpackString(b)
+		l, err := skipMsgpackValue(b)
+		if err != nil {
+			return "", false
+		}
+		b = b[l:]
+		if ok && string(k) == key {
+			v, ok := msgpackString(b)
+			return string(v), ok
+		}
+		if l, err = skipMsgpackValue(b); err != nil {
+			return "", false
+		}
+		b = b[l:]
+	}
+	return "", false
+}
+
+// bigEndian decodes a big endian length of 1, 2 or 4 bytes.
+func bigEndian(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b))
+	}
+	return uint64(binary.BigEndian.Uint32(b))
+}
+
+// readBytes appends exactly n bytes from r to buf.
+func readBytes(r io.Reader, buf []byte, n uint64) ([]byte, error) {
+	for n > 0 {
+		chunk := n
+		if chunk > readChunkSize {
+			chunk = readChunkSize
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return buf[:start], err
+		}
+		n -= chunk
+	}
+	return buf, nil
+}
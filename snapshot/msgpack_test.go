@@ -0,0 +1,197 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// withLen returns the type byte t followed by a big endian length of size
+// bytes and then the data.
+func withLen(t byte, size int, data []byte) []byte {
+	return append(head(t, size, len(data)), data...)
+}
+
+// head returns the type byte t followed by n as a big endian length of size
+// bytes.
+func head(t byte, size, n int) []byte {
+	b := []byte{t}
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(n>>(8*i)))
+	}
+	return b
+}
+
+// ext returns an extension, which has a type byte between its length and
+// data.
+func ext(t byte, size int, data []byte) []byte {
+	return append(append(head(t, size, len(data)), 7), data...)
+}
+
+// elems returns an array or map of n elements, or pairs for a map, which
+// count values rather than bytes.
+func elems(t byte, size, n int, values ...byte) []byte {
+	return append(head(t, size, n), values...)
+}
+
+// msgpackValues are encodings of every kind of msgpack value, by the type
+// byte they start with.
+var msgpackValues = []struct {
+	name string
+	b    []byte
+}{
+	{"positive fixint", []byte{0x05}},
+	{"negative fixint", []byte{0xe0}},
+	{"nil", []byte{0xc0}},
+	{"false", []byte{0xc2}},
+	{"true", []byte{0xc3}},
+	{"fixmap", []byte{0x81, 0xa1, 'k', 0x01}},
+	{"fixarray", []byte{0x92, 0x01, 0xa1, 'x'}},
+	{"fixstr", []byte{0xa3, 'a', 'b', 'c'}},
+	{"bin8", withLen(0xc4, 1, []byte("bin"))},
+	{"bin16", withLen(0xc5, 2, bytes.Repeat([]byte{1}, 300))},
+	{"bin32", withLen(0xc6, 4, bytes.Repeat([]byte{1}, 70000))},
+	{"ext8", ext(0xc7, 1, []byte("ext"))},
+	{"ext16", ext(0xc8, 2, bytes.Repeat([]byte{2}, 300))},
+	{"ext32", ext(0xc9, 4, bytes.Repeat([]byte{2}, 70000))},
+	{"float32", []byte{0xca, 0, 0, 0, 0}},
+	{"float64", []byte{0xcb, 0, 0, 0, 0, 0, 0, 0, 0}},
+	{"uint8", []byte{0xcc, 1}},
+	{"uint16", []byte{0xcd, 1, 2}},
+	{"uint32", []byte{0xce, 1, 2, 3, 4}},
+	{"uint64", []byte{0xcf, 1, 2, 3, 4, 5, 6, 7, 8}},
+	{"int8", []byte{0xd0, 1}},
+	{"int16", []byte{0xd1, 1, 2}},
+	{"int32", []byte{0xd2, 1, 2, 3, 4}},
+	{"int64", []byte{0xd3, 1, 2, 3, 4, 5, 6, 7, 8}},
+	{"fixext1", []byte{0xd4, 1, 0xaa}},
+	{"fixext2", []byte{0xd5, 1, 0xaa, 0xbb}},
+	{"fixext4", append([]byte{0xd6, 1}, make([]byte, 4)...)},
+	{"fixext8", append([]byte{0xd7, 1}, make([]byte, 8)...)},
+	{"fixext16", append([]byte{0xd8, 1}, make([]byte, 16)...)},
+	{"str8", withLen(0xd9, 1, []byte(strings.Repeat("s", 40)))},
+	{"str16", withLen(0xda, 2, []byte(strings.Repeat("s", 300)))},
+	{"str32", withLen(0xdb, 4, []byte(strings.Repeat("s", 70000)))},
+	{"array16", elems(0xdc, 2, 2, 0x01, 0xc0)},
+	{"array32", elems(0xdd, 4, 3, 0x01, 0x02, 0x91, 0x03)},
+	{"map16", elems(0xde, 2, 1, 0xa1, 'k', 0x92, 0x01, 0x02)},
+	{"map32", elems(0xdf, 4, 2, 0xa1, 'a', 0x01, 0xa1, 'b', 0x81, 0xa1, 'c', 0xc3)},
+}
+
+func TestReadMsgpackValue(t *testing.T) {
+	for _, v := range msgpackValues {
+		// Anything after the value must be left unread.
+		in := bufio.NewReader(bytes.NewReader(append(append([]byte(nil), v.b...), 0xc3)))
+		out, ok, err := readMsgpackValue(in, nil, 0)
+		if err != nil || !ok {
+			t.Errorf("%s: got ok %v, error %v", v.name, ok, err)
+			continue
+		}
+		if !bytes.Equal(out, v.b) {
+			t.Errorf("%s: read %d bytes, want %d", v.name, len(out), len(v.b))
+		}
+		if b, _ := in.ReadByte(); b != 0xc3 {
+			t.Errorf("%s: read past the end of the value", v.name)
+		}
+
+		if n, err := skipMsgpackValue(append(append([]byte(nil), v.b...), 0xc3)); err != nil || n != len(v.b) {
+			t.Errorf("%s: skipMsgpackValue got %d, %v, want %d", v.name, n, err, len(v.b))
+		}
+	}
+}
+
+func TestReadMsgpackValueTruncated(t *testing.T) {
+	for _, v := range msgpackValues {
+		// Cut inside the head, and at the very end, of long values.
+		var cuts []int
+		for i := 0; i < len(v.b) && i < 16; i++ {
+			cuts = append(cuts, i)
+		}
+		cuts = append(cuts, len(v.b)-1)
+		for _, i := range cuts {
+			_, _, err := readMsgpackValue(bufio.NewReader(bytes.NewReader(v.b[:i])), nil, 0)
+			if err != io.ErrUnexpectedEOF {
+				t.Errorf("%s cut to %d bytes: got %v, want io.ErrUnexpectedEOF", v.name, i, err)
+				break
+			}
+			if _, err := skipMsgpackValue(v.b[:i]); err != io.ErrUnexpectedEOF {
+				t.Errorf("%s cut to %d bytes: skipMsgpackValue got %v, want io.ErrUnexpectedEOF", v.name, i, err)
+				break
+			}
+		}
+	}
+}
+
+func TestReadMsgpackValueInvalid(t *testing.T) {
+	if _, _, err := readMsgpackValue(bufio.NewReader(bytes.NewReader([]byte{0xc1})), nil, 0); err == nil {
+		t.Error("expected an error for the never used type byte 0xc1")
+	}
+	if _, err := skipMsgpackValue([]byte{0x91, 0xc1}); err == nil {
+		t.Error("expected an error for 0xc1 inside an array")
+	}
+}
+
+func TestReadMsgpackValueMax(t *testing.T) {
+	for _, v := range msgpackValues {
+		if len(v.b) < 100 {
+			continue
+		}
+		in := bufio.NewReader(bytes.NewReader(append(append([]byte(nil), v.b...), 0xc3)))
+		out, ok, err := readMsgpackValue(in, []byte{0x02}, 50)
+		if err != nil || ok {
+			t.Errorf("%s: got ok %v, error %v, want it skipped", v.name, ok, err)
+			continue
+		}
+		if !bytes.Equal(out, []byte{0x02}) {
+			t.Errorf("%s: got %d bytes, want only what was in buf", v.name, len(out))
+		}
+		// The rest of the value is still read past.
+		if b, _ := in.ReadByte(); b != 0xc3 {
+			t.Errorf("%s: the skipped value wasn't read to its end", v.name)
+		}
+	}
+}
+
+func TestMapString(t *testing.T) {
+	record, err := Encode(map[string]interface{}{
+		"Flags": 0,
+		"Key":   "app/config",
+		"Nested": map[string]interface{}{
+			"Inner": "deep",
+		},
+		"List":  []interface{}{"Key", 1},
+		"Value": 12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		b    []byte
+		key  string
+		want string
+		ok   bool
+	}{
+		{record, "Key", "app/config", true},
+		// Only keys at the top level are looked up.
+		{record, "Inner", "", false},
+		// Values that aren't strings aren't returned.
+		{record, "Value", "", false},
+		{record, "Nested", "", false},
+		{record, "Missing", "", false},
+		// A map16, with a bin8 key and value.
+		{elems(0xde, 2, 1, 0xc4, 1, 'K', 0xc4, 2, 'o', 'k'), "K", "ok", true},
+		// Values that aren't maps.
+		{[]byte{0xa3, 'K', 'e', 'y'}, "Key", "", false},
+		{[]byte{0x91, 0xa3, 'K', 'e', 'y'}, "Key", "", false},
+		{nil, "Key", "", false},
+		// A map cut short.
+		{record[:len(record)/2], "Value", "", false},
+	} {
+		got, ok := MapString(tc.b, tc.key)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("MapString(% x, %q) = %q, %v, want %q, %v", tc.b, tc.key, got, ok, tc.want, tc.ok)
+		}
+	}
+}
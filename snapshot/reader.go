@@ -0,0 +1,325 @@
+// Package snapshot reads the state store snapshots written by Consul servers,
+// as found in state.bin inside the archive `consul snapshot save` writes.
+//
+// A snapshot is a msgpack encoded Header followed by records, each a message
+// type byte and a msgpack encoded value:
+//
+//	r, err := snapshot.Open(f)
+//	if err != nil {
+//		return err
+//	}
+//	for {
+//		rec, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		} else if err != nil {
+//			return err
+//		}
+//		fmt.Println(rec.Name(), rec.Size)
+//	}
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// DefaultMaxRecordBytes is the largest record read into memory by default.
+// It's far larger than any record Consul could have written so anything
+// bigger is almost certainly a corrupt length.
+const DefaultMaxRecordBytes = 256 << 20
+
+// readBufferSize is the size of the buffer snapshots are read through. The
+// decoder makes many small reads, down to a byte at a time, which would
+// otherwise each be a syscall when reading straight from a file or stdin.
+const readBufferSize = 256 << 10
+
+// ErrRecordTooLarge is the error for records larger than MaxRecordBytes.
+var ErrRecordTooLarge = errors.New("record is larger than the maximum record size")
+
+// msgpackHandle decodes records the same way Consul does.
+var msgpackHandle = &codec.MsgpackHandle{
+	RawToString: true,
+}
+
+// Header is the first entry in a snapshot.
+type Header struct {
+	// LastIndex is the last index that affects the data.
+	// This is used when we do the restore for watchers.
+	LastIndex uint64
+}
+
+// Record is a single record read from a snapshot.
+type Record struct {
+	// Type is the record's message type.
+	Type int
+	// Ordinal is the position of the record in the snapshot, starting at 1.
+	Ordinal int
+	// Offset is the byte offset of the start of the record and Size its
+	// encoded size, including the message type.
+	Offset, Size int
+	// Raw is the encoding of the record including the message type, or nil
+	// if the record was skipped for being too large. It's only valid until
+	// Next is called again.
+	Raw []byte
+}
+
+// Name returns the name of the record's message type.
+func (r Record) Name() string {
+	return TypeName(r.Type)
+}
+
+// Skipped returns true if the record was too large to read.
+func (r Record) Skipped() bool {
+	return r.Raw == nil
+}
+
+// Body returns the msgpack encoded value of the record, without the message
+// type, which MapString can pick fields out of.
+func (r Record) Body() []byte {
+	if r.Raw == nil {
+		return nil
+	}
+	return r.Raw[1:]
+}
+
+// Decode decodes the record's value into maps, slices and scalars.
+func (r Record) Decode() (interface{}, error) {
+	if r.Raw == nil {
+		return nil, ErrRecordTooLarge
+	}
+	return Decode(r.Body())
+}
+
+// Decode decodes the msgpack encoded value of a record, as returned by
+// Record.Body, into maps, slices and scalars.
+func Decode(body []byte) (interface{}, error) {
+	var val interface{}
+	err := codec.NewDecoderBytes(body, msgpackHandle).Decode(&val)
+	return val, err
+}
+
+// Encode encodes a value, such as a record's value from Decode or a Header,
+// as it's written to a snapshot.
+func Encode(v interface{}) ([]byte, error) {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(v)
+	return b, err
+}
+
+// Error describes where reading a snapshot failed.
+type Error struct {
+	// Offset is the byte offset of the start of the record that failed.
+	Offset int
+	// Record is the ordinal of the record, starting at 1, or 0 for the
+	// header.
+	Record int
+	// Type is the record's message type, or -1 if it wasn't read before the
+	// failure.
+	Type int
+	// IO is true if reading the snapshot failed rather than decoding it.
+	IO  bool
+	Err error
+}
+
+func (e *Error) Error() string {
+	what := "header"
+	if e.Record > 0 {
+		what = fmt.Sprintf("record %d", e.Record)
+		if e.Type >= 0 {
+			what += " (" + TypeName(e.Type) + ")"
+		}
+	}
+	problem := "failed to decode"
+	if e.IO {
+		problem = "failed to read"
+	} else if e.Err == io.ErrUnexpectedEOF || e.Err == io.EOF {
+		problem = "snapshot is truncated in"
+	}
+	return fmt.Sprintf("%s %s at offset %d: %s", problem, what, e.Offset, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads the records of a snapshot one at a time.
+type Reader struct {
+	// MaxRecordBytes is the largest record that will be read into memory.
+	// Zero means no limit.
+	MaxRecordBytes int
+	// SkipLarge returns records larger than MaxRecordBytes from Next without
+	// their encoding, rather than failing. Only callers that can make do
+	// without some records should set it.
+	SkipLarge bool
+
+	cr *countingReader
+
+	header    Header
+	headerRaw []byte
+
+	// last is the encoding of the last record read, including its type.
+	last []byte
+	// offset is the total number of bytes read so far and records the
+	// number of records.
+	offset, records int
+}
+
+// Open reads the header of the snapshot in r, ready for its records to be
+// read with Next.
+func Open(r io.Reader) (*Reader, error) {
+	return OpenContext(context.Background(), r)
+}
+
+// OpenContext is like Open but reading stops with an *Error wrapping ctx's
+// error once ctx is done. Reads already blocked on r aren't interrupted, so
+// readers such as HTTP response bodies should be tied to ctx too.
+func OpenContext(ctx context.Context, r io.Reader) (*Reader, error) {
+	if ctx.Done() != nil {
+		r = contextReader{ctx, r}
+	}
+	s := &Reader{MaxRecordBytes: DefaultMaxRecordBytes, cr: newCountingReader(r)}
+
+	// Read in the header
+	raw, ok, err := readMsgpackValue(s.cr, nil, s.MaxRecordBytes)
+	if err != nil {
+		return nil, s.error(0, -1, err)
+	} else if !ok {
+		return nil, s.error(0, -1, ErrRecordTooLarge)
+	}
+	if err := codec.NewDecoderBytes(raw, msgpackHandle).Decode(&s.header); err != nil {
+		return nil, s.error(0, -1, err)
+	}
+	s.headerRaw = raw
+	s.offset = s.cr.read
+	return s, nil
+}
+
+// Header returns the snapshot's header.
+func (s *Reader) Header() Header {
+	return s.header
+}
+
+// RawHeader returns the encoding of the snapshot's header.
+func (s *Reader) RawHeader() []byte {
+	return s.headerRaw
+}
+
+// Offset returns the number of bytes read so far, which is the offset of the
+// next record.
+func (s *Reader) Offset() int {
+	return s.offset
+}
+
+// Records returns the number of records read so far.
+func (s *Reader) Records() int {
+	return s.records
+}
+
+// Next reads the next record without decoding it, or returns io.EOF once
+// there are no more. Other errors are *Errors.
+func (s *Reader) Next() (Record, error) {
+	// Read the message type
+	b, err := s.cr.ReadByte()
+	if err == io.EOF {
+		return Record{}, err
+	} else if err != nil {
+		return Record{}, s.error(s.records+1, -1, err)
+	}
+	msgType := int(b)
+
+	var ok bool
+	s.last, ok, err = readMsgpackValue(s.cr, append(s.last[:0], b), s.MaxRecordBytes)
+	if err != nil {
+		return Record{}, s.error(s.records+1, msgType, err)
+	}
+	if !ok && !s.SkipLarge {
+		return Record{}, s.error(s.records+1, msgType, ErrRecordTooLarge)
+	}
+
+	// See how big it was
+	rec := Record{Type: msgType, Offset: s.offset, Size: s.cr.read - s.offset}
+	s.offset += rec.Size
+	s.records++
+	rec.Ordinal = s.records
+	if ok {
+		rec.Raw = s.last
+	}
+	return rec, nil
+}
+
+// error wraps err with the position of the record being read, which has the
+// given type if it's known.
+func (s *Reader) error(record, msgType int, err error) error {
+	e := &Error{Offset: s.offset, Record: record, Type: msgType, Err: err}
+	if s.cr.err != nil {
+		e.IO, e.Err = true, s.cr.err
+	}
+	return e
+}
+
+// countingReader buffers reads from the underlying reader, counting the bytes
+// read through it. Counts are of bytes handed to the caller, not of bytes
+// buffered ahead, so they're offsets into the snapshot. It also remembers the
+// last error from the underlying reader so failures reading the snapshot can
+// be told apart from corrupt data.
+type countingReader struct {
+	r    *bufio.Reader
+	read int
+	err  error
+}
+
+func newCountingReader(r io.Reader) *countingReader {
+	cr := &countingReader{}
+	cr.r = bufio.NewReaderSize(errorReader{r, cr}, readBufferSize)
+	return cr
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	// Readers may return the last bytes along with io.EOF so always count n.
+	r.read += n
+	return n, err
+}
+
+// ReadByte lets single bytes be read without going through Read.
+func (r *countingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.read++
+	return b, nil
+}
+
+// contextReader fails reads once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// errorReader records errors from the reader behind a countingReader's buffer.
+type errorReader struct {
+	r  io.Reader
+	cr *countingReader
+}
+
+func (e errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.cr.err = err
+	}
+	return n, err
+}
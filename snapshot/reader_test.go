@@ -0,0 +1,212 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testSnapshot returns a snapshot of the given records, each a type and value.
+func testSnapshot(t *testing.T, records ...interface{}) []byte {
+	b, err := Encode(Header{LastIndex: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(records); i += 2 {
+		body, err := Encode(records[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(append(b, byte(records[i].(int))), body...)
+	}
+	return b
+}
+
+func TestReader(t *testing.T) {
+	snap := testSnapshot(t,
+		RegisterType, map[string]interface{}{"Node": "node-1"},
+		KVSType, map[string]interface{}{"Key": "app/config", "Value": "x"},
+	)
+	r, err := Open(bytes.NewReader(snap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Header().LastIndex != 100 {
+		t.Errorf("got LastIndex %d, want 100", r.Header().LastIndex)
+	}
+	offset := r.Offset()
+	for i, want := range []int{RegisterType, KVSType} {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Type != want || rec.Ordinal != i+1 || rec.Offset != offset {
+			t.Errorf("got record %d of type %d at %d, want %d of type %d at %d", rec.Ordinal, rec.Type, rec.Offset, i+1, want, offset)
+		}
+		offset += rec.Size
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("got %v after the last record, want io.EOF", err)
+	}
+	if offset != len(snap) || r.Offset() != len(snap) {
+		t.Errorf("records end at %d, Offset is %d, want %d", offset, r.Offset(), len(snap))
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	snap := testSnapshot(t,
+		RegisterType, map[string]interface{}{"Node": "node-1"},
+		KVSType, map[string]interface{}{"Key": "app/config", "Value": "x"},
+	)
+	r, err := Open(bytes.NewReader(snap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := r.Offset()
+	first, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		cut            int
+		offset, record int
+		msgType        int
+	}{
+		{"header", header - 1, 0, 0, -1},
+		{"first record", header + 3, header, 1, RegisterType},
+		{"second record", len(snap) - 1, header + first.Size, 2, KVSType},
+	} {
+		var rerr error
+		r, err := Open(bytes.NewReader(snap[:tc.cut]))
+		if err != nil {
+			rerr = err
+		} else {
+			for rerr == nil {
+				_, rerr = r.Next()
+			}
+		}
+		var e *Error
+		if !errors.As(rerr, &e) {
+			t.Errorf("%s: got %v, want an *Error", tc.name, rerr)
+			continue
+		}
+		if e.Offset != tc.offset || e.Record != tc.record || e.Type != tc.msgType || e.Err != io.ErrUnexpectedEOF || e.IO {
+			t.Errorf("%s: got %+v, want offset %d, record %d, type %d", tc.name, e, tc.offset, tc.record, tc.msgType)
+		}
+	}
+}
+
+func TestReaderLarge(t *testing.T) {
+	snap := testSnapshot(t,
+		KVSType, map[string]interface{}{"Key": "big", "Value": string(make([]byte, 1000))},
+		KVSType, map[string]interface{}{"Key": "small"},
+	)
+	r, err := Open(bytes.NewReader(snap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxRecordBytes = 100
+	var e *Error
+	if _, err := r.Next(); !errors.As(err, &e) || e.Err != ErrRecordTooLarge || e.Record != 1 {
+		t.Fatalf("got %v, want ErrRecordTooLarge for record 1", err)
+	}
+
+	r, _ = Open(bytes.NewReader(snap))
+	r.MaxRecordBytes = 100
+	r.SkipLarge = true
+	rec, err := r.Next()
+	if err != nil || !rec.Skipped() || rec.Size < 1000 {
+		t.Fatalf("got %+v, %v, want the large record skipped", rec, err)
+	}
+	rec, err = r.Next()
+	if err != nil || rec.Skipped() {
+		t.Fatalf("got %+v, %v, want the small record after it", rec, err)
+	}
+	if key, _ := MapString(rec.Body(), "Key"); key != "small" {
+		t.Errorf("got key %q, want small", key)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	snap := testSnapshot(t,
+		RegisterType, map[string]interface{}{"Node": "node-1"},
+		KVSType, map[string]interface{}{"Key": "app/config"},
+		KVSType, map[string]interface{}{"Key": "big", "Value": string(make([]byte, 1000))},
+		5, map[string]interface{}{"Key": "tombstone"},
+		200, map[string]interface{}{"New": true},
+	)
+	r, err := Open(bytes.NewReader(snap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxRecordBytes = 100
+	r.SkipLarge = true
+	var seen []string
+	err = r.Walk(context.Background(), Visitor{
+		OnKV: func(rec Record, val interface{}) error {
+			if val == nil {
+				seen = append(seen, "skipped KV")
+				return nil
+			}
+			seen = append(seen, "KV "+val.(map[interface{}]interface{})["Key"].(string))
+			return nil
+		},
+		OnRegister: func(rec Record, val interface{}) error {
+			seen = append(seen, "Register "+val.(map[interface{}]interface{})["Node"].(string))
+			return nil
+		},
+		OnUnknown: func(rec Record) error {
+			seen = append(seen, rec.Name())
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Register node-1", "KV app/config", "skipped KV", "Unknown(200)"}
+	if len(seen) != len(want) {
+		t.Fatalf("got %q, want %q", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Errorf("got %q, want %q", seen, want)
+			break
+		}
+	}
+}
+
+func TestWalkStops(t *testing.T) {
+	snap := testSnapshot(t,
+		KVSType, map[string]interface{}{"Key": "a"},
+		KVSType, map[string]interface{}{"Key": "b"},
+	)
+	stop := errors.New("stop")
+	r, _ := Open(bytes.NewReader(snap))
+	calls := 0
+	err := r.Walk(context.Background(), Visitor{OnKV: func(Record, interface{}) error {
+		calls++
+		return stop
+	}})
+	if err != stop || calls != 1 {
+		t.Errorf("got %v after %d calls, want the callback's error after 1", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, _ = Open(bytes.NewReader(snap))
+	if err := r.Walk(ctx, Visitor{}); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+
+	// Records that can't be read fail with their position.
+	bad := append(testSnapshot(t), KVSType, 0x81, 0xa1, 'K', 0xc1)
+	r, _ = Open(bytes.NewReader(bad))
+	var e *Error
+	if err := r.Walk(context.Background(), Visitor{OnKV: func(Record, interface{}) error { return nil }}); !errors.As(err, &e) || e.Record != 1 {
+		t.Errorf("got %v, want an *Error for record 1", err)
+	}
+}
@@ -0,0 +1,61 @@
+package snapshot
+
+import "sort"
+
+// ReportVersion is bumped whenever a field of Report, TypeStat or PrefixStat
+// changes meaning or is removed. Adding fields doesn't change it.
+const ReportVersion = 1
+
+// Report is the breakdown of a snapshot by message type and KV prefix, as
+// written by `consul-snapshot-tool -format json`. Sizes are encoded sizes in
+// bytes.
+type Report struct {
+	Version int `json:"version"`
+	// Records is the number of records and Size the total size of the
+	// snapshot, including the header.
+	Records int `json:"records"`
+	Size    int `json:"size"`
+	// Types and KVPrefixes are sorted largest first.
+	Types      []TypeStat   `json:"types"`
+	KVPrefixes []PrefixStat `json:"kv_prefixes"`
+	// Sample is N if only 1 in N records was decoded, in which case the
+	// types are still exact but KVPrefixes are estimates.
+	Sample int `json:"sample,omitempty"`
+}
+
+// TypeStat is the count and size of the records of one message type.
+type TypeStat struct {
+	Type  int    `json:"type"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Size  int    `json:"size"`
+}
+
+// PrefixStat is the count and size of the KV entries under one prefix.
+type PrefixStat struct {
+	Prefix string `json:"prefix"`
+	Count  int    `json:"count"`
+	Size   int    `json:"size"`
+	// Samples is the number of entries the estimate was made from when
+	// the report was sampled.
+	Samples int `json:"samples,omitempty"`
+}
+
+// Sort sorts the report's rows largest first, by name for rows of the same
+// size so the order is stable.
+func (r *Report) Sort() {
+	sort.Slice(r.Types, func(i, j int) bool {
+		a, b := r.Types[i], r.Types[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Type < b.Type
+	})
+	sort.Slice(r.KVPrefixes, func(i, j int) bool {
+		a, b := r.KVPrefixes[i], r.KVPrefixes[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Prefix < b.Prefix
+	})
+}
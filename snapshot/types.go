@@ -0,0 +1,71 @@
+package snapshot
+
+import "fmt"
+
+// Message types that have callbacks in Visitor.
+const (
+	RegisterType    = 0
+	KVSType         = 2
+	ConfigEntryType = 22
+)
+
+// TypeNames are the names of the message types Consul writes to snapshots,
+// indexed by type. These mirror the const values from
+// https://github.com/hashicorp/consul/blob/master/agent/structs/structs.go#L37-L70
+// (line numbers may change but I want to link to master so it shows most recent
+// constants).
+var TypeNames = []string{
+	"Register",
+	"Deregister",
+	"KVS",
+	"Session",
+	"ACL (Deprecated)",
+	"Tombstone",
+	"CoordinateBatchUpdate",
+	"PreparedQuery",
+	"Txn",
+	"Autopilot",
+	"Area",
+	"ACLBootstrap",
+	"Intention",
+	"ConnectCA",
+	"ConnectCAProviderState",
+	"ConnectCAConfig",
+	"Index",
+	"ACLTokenSet",
+	"ACLTokenDelete",
+	"ACLPolicySet",
+	"ACLPolicyDelete",
+	"ConnectCALeafRequestType",
+	"ConfigEntryRequestType",
+	"ACLRoleSetRequestType",
+	"ACLRoleDeleteRequestType",
+	"ACLBindingRuleSetRequestType",
+	"ACLBindingRuleDeleteRequestType",
+	"ACLAuthMethodSetRequestType",
+	"ACLAuthMethodDeleteRequestType",
+	"ChunkingStateType",
+	"FederationStateRequestType",
+	"SystemMetadataRequestType",
+	"ServiceVirtualIPRequestType",
+	"FreeVirtualIPRequestType",
+	"KindServiceNamesType",
+	"PeeringWriteType",
+	"PeeringDeleteType",
+	"PeeringTerminateByIDType",
+	"PeeringTrustBundleWriteType",
+	"PeeringTrustBundleDeleteType",
+	"PeeringSecretsWriteType",
+	"RaftLogVerifierCheckpoint",
+	"ResourceOperationType",
+	"UpdateVirtualIPRequestType",
+}
+
+// TypeName returns the name of a message type, or Unknown(<N>) for types
+// added by Consul versions newer than TypeNames.
+func TypeName(msgType int) string {
+	if msgType >= 0 && msgType < len(TypeNames) {
+		return TypeNames[msgType]
+	}
+	return fmt.Sprintf("Unknown(%d)", msgType)
+}
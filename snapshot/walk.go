@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"context"
+	"io"
+)
+
+// Visitor has callbacks for the records of a snapshot, by message type, for
+// Walk. Each is called with the record and its decoded value, which is nil if
+// the record was skipped for being too large. Records are only decoded if
+// they have a callback, and returning an error from one stops the walk.
+type Visitor struct {
+	// OnKV is called for each KVS record, a KV entry.
+	OnKV func(rec Record, val interface{}) error
+	// OnRegister is called for each Register record, a node with its
+	// services and checks.
+	OnRegister func(rec Record, val interface{}) error
+	// OnConfigEntry is called for each config entry.
+	OnConfigEntry func(rec Record, val interface{}) error
+	// OnUnknown is called, without decoding it, for each record with a
+	// type not in TypeNames.
+	OnUnknown func(rec Record) error
+}
+
+// Walk reads the rest of the snapshot, calling v's callback for each record
+// that has one. It returns nil once every record has been read, the error
+// from a callback, ctx's error if it's cancelled or an *Error if reading
+// fails.
+func (s *Reader) Walk(ctx context.Context, v Visitor) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, err := s.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var fn func(Record, interface{}) error
+		switch {
+		case rec.Type >= len(TypeNames):
+			if v.OnUnknown != nil {
+				err = v.OnUnknown(rec)
+			}
+		case rec.Type == KVSType:
+			fn = v.OnKV
+		case rec.Type == RegisterType:
+			fn = v.OnRegister
+		case rec.Type == ConfigEntryType:
+			fn = v.OnConfigEntry
+		}
+		if fn != nil {
+			err = visit(rec, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// visit decodes rec, unless it was skipped, and passes it to fn.
+func visit(rec Record, fn func(Record, interface{}) error) error {
+	var val interface{}
+	if !rec.Skipped() {
+		var err error
+		if val, err = rec.Decode(); err != nil {
+			return &Error{Offset: rec.Offset, Record: rec.Ordinal, Type: rec.Type, Err: err}
+		}
+	}
+	return fn(rec, val)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// statsCommand implements `stats [snapshot]`, the default command, which
+// prints the breakdown of a snapshot by record type and KV prefix followed by
+// any additional reports.
+func statsCommand(fs *flag.FlagSet) func() {
+	var kvExclude stringsFlag
+	kvDepth := fs.Int("kv-depth", 1, "number of path segments to group KV keys by in the prefix breakdown")
+	fs.Var(&kvExclude, "kv-exclude", "KV prefix to leave out of the prefix breakdown (may be repeated)")
+	vaultPath := fs.String("vault-path", "vault/", "KV prefix Vault's Consul storage backend writes under")
+	vaultMounts := fs.String("vault-mounts", "", "file containing the JSON output of 'vault secrets list -format=json' (or 'vault auth list') used to name mount UUIDs")
+	maxKeyLen := fs.Int("max-key-length", 512, "report KV keys longer than this many bytes as anomalous")
+	showKeyLengths := fs.Bool("key-lengths", false, "report the distribution of key name lengths per KV prefix")
+	fast := fs.Bool("fast", false, "only frame records and read the keys of KV entries, without decoding large records or Nomad's breakdown, for the quickest pass over a huge snapshot; can't be used with -report")
+	var sample sampleFlag
+	fs.Var(&sample, "sample", "only decode 1 in N records, given as 1/N, estimating the KV prefix breakdown from them for a quick look at a huge snapshot")
+	maxRecordSize := byteSizeFlag(defaultMaxRecordSize)
+	fs.Var(&maxRecordSize, "max-record-size", "report records larger than, or within 10% of, this raft entry size limit; 0 to disable")
+	var reportNames stringsFlag
+	fs.Var(&reportNames, "report", "comma separated list of additional reports to print (may be repeated): "+strings.Join(reportList(), ", "))
+	var plugins stringsFlag
+	fs.Var(&plugins, "plugin", "command to send every record to as JSON lines, printing its output as an extra report section (may be repeated)")
+	var cfg reportConfig
+	fs.IntVar(&cfg.Top, "top", 20, "maximum number of rows to list in each additional report, 0 for all")
+	cfg.MaxPolicyRules = 64 * KILOBYTE
+	fs.Var(&cfg.MaxPolicyRules, "max-policy-rules", "flag ACL policies with rules larger than this in the acl-rules report")
+	indexTimesPath := fs.String("index-times", "", "file of raft index samples written by index-sample, used to estimate how long ago records were modified in the index-ranges report")
+	format := formatFlag(fs)
+	statsd := statsdFlags(fs)
+	inputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool stats [options] [snapshot]")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() > 1 || !validFormat(*format) {
+			fs.Usage()
+			exit(exitError)
+		}
+		path := "-"
+		if fs.NArg() == 1 {
+			path = fs.Arg(0)
+		}
+
+		cfg.KVDepth = *kvDepth
+		cfg.VaultPath = *vaultPath
+		if *indexTimesPath != "" {
+			times, err := loadIndexTimes(*indexTimesPath)
+			if err != nil {
+				fatal(err)
+			}
+			cfg.IndexTimes = times
+		}
+		enabled, err := newReports(reportNames, &cfg)
+		if err != nil {
+			fatal(err)
+		}
+		for _, command := range plugins {
+			p, err := newPluginReport(command)
+			if err != nil {
+				fatal(err)
+			}
+			enabled = append(enabled, p)
+		}
+		if *format == "json" && len(enabled) > 0 {
+			fatal(fmt.Errorf("-format json can't be used with -report or -plugin"))
+		}
+		if product == "vault" && len(enabled) > 0 {
+			fatal(fmt.Errorf("-report and -plugin can't be used with Vault snapshots, which only have keys and encrypted values"))
+		}
+		if product == "vault" {
+			// Integrated storage keeps Vault's paths at the top level.
+			*vaultPath = ""
+		}
+		if *fast && sample > 1 {
+			fatal(fmt.Errorf("-fast and -sample can't be used together"))
+		}
+		if product == "vault" && sample > 1 {
+			fatal(fmt.Errorf("-sample can't be used with Vault snapshots"))
+		}
+		if *fast && len(enabled) > 0 {
+			fatal(fmt.Errorf("-fast can't be used with -report or -plugin since reports need every record decoded"))
+		}
+
+		stats := make(map[int]typeStats)
+		kv := newKVStats(*kvDepth, kvExclude)
+		if sample > 1 {
+			kv.setSample(int(sample))
+		}
+		vault := newVaultStats(*vaultPath)
+		if sample > 1 {
+			vault.setSample(int(sample))
+		}
+		anomalies := newKeyAnomalies(*maxKeyLen)
+		large := newLargeRecords(int(maxRecordSize))
+		var keyLens *keyLengths
+		var nomad *nomadStats
+		if product == "nomad" && !*fast {
+			nomad = newNomadStats()
+		}
+		if *showKeyLengths {
+			keyLens = newKeyLengths(*kvDepth)
+		}
+		if *vaultMounts != "" {
+			if err := vault.loadMounts(*vaultMounts); err != nil {
+				fatal(err)
+			}
+		}
+
+		// countType adds a record to the type breakdown and countKey adds it to
+		// the key breakdowns. count does both.
+		countType := func(msgType int, size int) {
+			s := stats[msgType]
+			if s.Name == "" {
+				s.Name = typeName(msgType)
+			}
+			s.Sum += size
+			s.Count++
+			stats[msgType] = s
+		}
+		countKey := func(msgType int, key string, size int) {
+			if typeName(msgType) == "KVS" || product == "vault" {
+				kv.add(key, size)
+				vault.add(key, size)
+				anomalies.add(key)
+				if keyLens != nil {
+					keyLens.add(key)
+				}
+			}
+		}
+		// count adds a record to everything but the reports, which need the
+		// decoded value.
+		count := func(msgType int, key string, size int) {
+			countType(msgType, size)
+			countKey(msgType, key, size)
+		}
+
+		in, err := openSnapshot(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer in.Close()
+		var total int
+		if product == "vault" {
+			total, err = scanVaultSnapshot(in, func(key string, size int) {
+				count(0, key, size)
+			})
+		} else if sample > 1 {
+			// Every record is framed so the type breakdown is exact, but only
+			// the sampled records are decoded and added to the rest.
+			smp := newSampler(int(sample))
+			var decodeErr error
+			total, err = scanSnapshot(in, func(msgType int, raw []byte, size int) {
+				countType(msgType, size)
+				if nomad != nil {
+					nomad.add(msgType, func(name string) string {
+						s, _ := snapshot.MapString(raw, name)
+						return s
+					}, size)
+				}
+				if large.count(size) {
+					val, _ := snapshot.Decode(raw)
+					large.add(msgType, val, size)
+				}
+				if !smp.take(msgType) || raw == nil || decodeErr != nil {
+					return
+				}
+				if len(enabled) == 0 {
+					key := ""
+					if typeName(msgType) == "KVS" {
+						key, _ = snapshot.MapString(raw, "Key")
+					}
+					countKey(msgType, key, size)
+					return
+				}
+				val, err := snapshot.Decode(raw)
+				if err != nil {
+					decodeErr = fmt.Errorf("%s: %s record: %w", path, typeName(msgType), err)
+					return
+				}
+				countKey(msgType, kvKey(val), size)
+				for _, r := range enabled {
+					r.add(msgType, val, size)
+				}
+			})
+			if err == nil {
+				err = decodeErr
+			}
+		} else if *fast {
+			// Only the record sizes and the keys of KV entries are read, so
+			// large records are named only by type.
+			total, err = scanSnapshot(in, func(msgType int, raw []byte, size int) {
+				key := skippedKey
+				if typeName(msgType) == "KVS" && raw != nil {
+					key, _ = snapshot.MapString(raw, "Key")
+				}
+				count(msgType, key, size)
+				if large.count(size) {
+					large.add(msgType, nil, size)
+				}
+			})
+		} else if len(enabled) == 0 {
+			// Only keys are needed so there's no need to decode most records.
+			total, err = scanSnapshot(in, func(msgType int, raw []byte, size int) {
+				key := skippedKey
+				if typeName(msgType) == "KVS" && raw != nil {
+					key, _ = snapshot.MapString(raw, "Key")
+				}
+				count(msgType, key, size)
+				if nomad != nil {
+					nomad.add(msgType, func(name string) string {
+						s, _ := snapshot.MapString(raw, name)
+						return s
+					}, size)
+				}
+				if large.count(size) {
+					// Decode the few large records to say what they are.
+					val, _ := snapshot.Decode(raw)
+					large.add(msgType, val, size)
+				}
+			})
+		} else {
+			total, err = readSnapshot(in, func(msgType int, val interface{}, size int) {
+				key := skippedKey
+				if val != nil {
+					key = kvKey(val)
+				}
+				count(msgType, key, size)
+				if nomad != nil {
+					nomad.add(msgType, func(name string) string { return stringField(val, name) }, size)
+				}
+				if large.count(size) {
+					large.add(msgType, val, size)
+				}
+				for _, r := range enabled {
+					r.add(msgType, val, size)
+				}
+			})
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+		rep := newReport(stats, kv, total)
+		ss := make(statSlice, 0, len(rep.Types))
+		for _, t := range rep.Types {
+			ss = append(ss, typeStats{Name: t.Name, Sum: t.Size, Count: t.Count})
+		}
+		statsd.send(rep.Records, rep.Size, ss, kv.prefixes.slice())
+		if *format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(rep); err != nil {
+				fatal(err)
+			}
+			return
+		}
+
+		// Output stats in size-order
+		printStats(os.Stdout, "Record Type", ss, rep.Size)
+		kv.print(os.Stdout)
+		if nomad != nil {
+			nomad.print(os.Stdout, cfg.Top)
+		}
+		vault.print(os.Stdout)
+		if sample > 1 {
+			fmt.Printf("\nThe key checks and any reports below only cover the 1 in %d records sampled, so their counts and sizes are of the sample.\n", int(sample))
+		}
+		anomalies.print(os.Stdout)
+		large.print(os.Stdout)
+		if keyLens != nil {
+			keyLens.print(os.Stdout)
+		}
+		for _, r := range enabled {
+			fmt.Println()
+			r.print(os.Stdout)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// statsdTopPrefixes is how many of the largest KV prefixes gauges are sent for.
+const statsdTopPrefixes = 10
+
+// statsdPacketBytes is the most written in one UDP packet, small enough not to
+// be fragmented on common networks.
+const statsdPacketBytes = 1432
+
+// statsdConfig is where to send the key numbers from an analysis as StatsD
+// gauges, for monitoring stacks that don't scrape Prometheus.
+type statsdConfig struct {
+	addr   string
+	prefix string
+	// dogstatsd puts record types and prefixes in DogStatsD tags rather than
+	// metric names.
+	dogstatsd bool
+	tags      stringsFlag
+}
+
+// statsdFlags registers the flags for sending gauges to StatsD.
+func statsdFlags(fs *flag.FlagSet) *statsdConfig {
+	c := &statsdConfig{}
+	fs.StringVar(&c.addr, "statsd-addr", "", "StatsD or DogStatsD address, e.g. 127.0.0.1:8125, to send the total, per type and largest KV prefix sizes to as gauges")
+	fs.StringVar(&c.prefix, "statsd-prefix", "consul_snapshot", "prefix for the names of the gauges sent to -statsd-addr")
+	fs.BoolVar(&c.dogstatsd, "dogstatsd", false, "name record types and KV prefixes with DogStatsD tags rather than in the gauge names")
+	fs.Var(&c.tags, "statsd-tag", "DogStatsD tag, e.g. datacenter:dc1, to add to every gauge (may be repeated, implies -dogstatsd)")
+	return c
+}
+
+// gauge is a single value to send.
+type gauge struct {
+	name  string
+	tag   string
+	value int
+}
+
+// send sends the size and record count of a snapshot along with the sizes and
+// counts of its types and largest KV prefixes, warning rather than failing if
+// they can't be sent so monitoring problems don't stop the analysis.
+func (c *statsdConfig) send(records, size int, types, prefixes statSlice) {
+	if c.addr == "" {
+		return
+	}
+	gauges := []gauge{{"records", "", records}, {"size", "", size}}
+	for _, t := range types {
+		gauges = append(gauges, gauge{"type.size", "type:" + t.Name, t.Sum}, gauge{"type.count", "type:" + t.Name, t.Count})
+	}
+	prefixes = append(statSlice(nil), prefixes...)
+	sort.Sort(prefixes)
+	if len(prefixes) > statsdTopPrefixes {
+		prefixes = prefixes[:statsdTopPrefixes]
+	}
+	for _, p := range prefixes {
+		gauges = append(gauges, gauge{"kv_prefix.size", "kv_prefix:" + p.Name, p.Sum}, gauge{"kv_prefix.count", "kv_prefix:" + p.Name, p.Count})
+	}
+	if err := c.write(gauges); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send gauges to %s: %s\n", c.addr, err)
+	}
+}
+
+// write sends gauges in as few packets as possible.
+func (c *statsdConfig) write(gauges []gauge) error {
+	conn, err := net.Dial("udp", c.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	for _, g := range gauges {
+		line := c.format(g)
+		if packet.Len() > 0 && packet.Len()+len(line) > statsdPacketBytes {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		_, err = conn.Write(packet.Bytes())
+	}
+	return err
+}
+
+// format returns the StatsD line for g.
+func (c *statsdConfig) format(g gauge) string {
+	name := c.prefix + "." + g.name
+	var tags []string
+	if g.tag != "" {
+		if c.dogstatsd || len(c.tags) > 0 {
+			kv := strings.SplitN(g.tag, ":", 2)
+			tags = append(tags, kv[0]+":"+dogstatsdTagValue(kv[1]))
+		} else {
+			// Plain StatsD has no tags so the subject goes in the name,
+			// e.g. consul_snapshot.type.KVS.size.
+			kv := strings.SplitN(g.tag, ":", 2)
+			i := strings.LastIndex(g.name, ".")
+			name = c.prefix + "." + g.name[:i] + "." + statsdName(kv[1]) + g.name[i:]
+		}
+	}
+	tags = append(tags, c.tags...)
+	line := fmt.Sprintf("%s:%d|g", name, g.value)
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line + "\n"
+}
+
+// statsdName replaces the characters that can't appear in a StatsD metric
+// name segment, including the dots that separate them.
+func statsdName(s string) string {
+	s = strings.TrimSuffix(s, "/")
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// dogstatsdTagValue replaces the characters that would end a DogStatsD tag.
+func dogstatsdTagValue(s string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_", " ", "_").Replace(s)
+}
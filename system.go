@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// systemMetadata prints the system metadata key/value pairs, such as the
+// markers Consul uses to record which features have been enabled, since
+// they influence upgrade behavior.
+type systemMetadata struct {
+	entries map[string]string
+}
+
+func newSystemMetadata(c *reportConfig) report {
+	return &systemMetadata{entries: make(map[string]string)}
+}
+
+func (s *systemMetadata) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "SystemMetadataRequestType" {
+		return
+	}
+	s.entries[stringField(val, "Key")] = stringField(val, "Value")
+}
+
+func (s *systemMetadata) print(w io.Writer) {
+	fmt.Fprintln(w, "System Metadata")
+	if len(s.entries) == 0 {
+		fmt.Fprintln(w, "  none")
+		return
+	}
+	keys := make([]string, 0, len(s.entries))
+	for k := range s.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s = %q\n", k, s.entries[k])
+	}
+}
+
+// autopilotConfig prints the stored autopilot configuration.
+type autopilotConfig struct {
+	config interface{}
+}
+
+func newAutopilotConfig(c *reportConfig) report {
+	return &autopilotConfig{}
+}
+
+func (a *autopilotConfig) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) == "Autopilot" {
+		a.config = val
+	}
+}
+
+func (a *autopilotConfig) print(w io.Writer) {
+	fmt.Fprintln(w, "Autopilot Configuration")
+	if a.config == nil {
+		fmt.Fprintln(w, "  none stored, servers use their configured defaults")
+		return
+	}
+	c := a.config
+	fmt.Fprintf(w, "  %-28s %t\n", "CleanupDeadServers:", boolField(c, "CleanupDeadServers"))
+	fmt.Fprintf(w, "  %-28s %s\n", "LastContactThreshold:", time.Duration(uintField(c, "LastContactThreshold")))
+	fmt.Fprintf(w, "  %-28s %d\n", "MaxTrailingLogs:", uintField(c, "MaxTrailingLogs"))
+	fmt.Fprintf(w, "  %-28s %d\n", "MinQuorum:", uintField(c, "MinQuorum"))
+	fmt.Fprintf(w, "  %-28s %s\n", "ServerStabilizationTime:", time.Duration(uintField(c, "ServerStabilizationTime")))
+	fmt.Fprintf(w, "  %-28s %q\n", "RedundancyZoneTag:", stringField(c, "RedundancyZoneTag"))
+	fmt.Fprintf(w, "  %-28s %t\n", "DisableUpgradeMigration:", boolField(c, "DisableUpgradeMigration"))
+	fmt.Fprintf(w, "  %-28s %q\n", "UpgradeVersionTag:", stringField(c, "UpgradeVersionTag"))
+	fmt.Fprintf(w, "  %-28s %d\n", "ModifyIndex:", uintField(c, "ModifyIndex"))
+}
+
+// chunkingState reports partially applied chunked writes. Consul splits large
+// writes into chunks and only applies them once every chunk has arrived, so
+// chunks left behind here are state that will never be applied.
+type chunkingState struct {
+	records, size int
+	partial       int
+	chunks        int
+	data          int
+}
+
+func newChunkingState(c *reportConfig) report {
+	return &chunkingState{}
+}
+
+func (c *chunkingState) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "ChunkingStateType" {
+		return
+	}
+	c.records++
+	c.size += size
+
+	ops, _ := field(val, "ChunkMap").(map[interface{}]interface{})
+	for _, op := range ops {
+		chunks, _ := op.([]interface{})
+		if len(chunks) == 0 {
+			continue
+		}
+		if uint64(len(chunks)) < uintField(chunks[0], "NumChunks") {
+			c.partial++
+		}
+		for _, chunk := range chunks {
+			c.chunks++
+			c.data += len(stringField(chunk, "Data"))
+		}
+	}
+}
+
+func (c *chunkingState) print(w io.Writer) {
+	fmt.Fprintf(w, "Chunking State: %d records (%s)\n", c.records, ByteSize(uint64(c.size)))
+	if c.chunks == 0 {
+		fmt.Fprintln(w, "  no chunked writes in progress")
+		return
+	}
+	fmt.Fprintf(w, "  %d incomplete chunked applies holding %d chunks (%s of data)\n",
+		c.partial, c.chunks, ByteSize(uint64(c.data)))
+}
+
+// networkAreas lists the Consul Enterprise network areas configured for WAN
+// federation. Only whether TLS is in use is shown.
+type networkAreas struct {
+	areas []interface{}
+	size  int
+}
+
+func newNetworkAreas(c *reportConfig) report {
+	return &networkAreas{}
+}
+
+func (n *networkAreas) add(msgType int, val interface{}, size int) {
+	if typeName(msgType) != "Area" {
+		return
+	}
+	n.areas = append(n.areas, val)
+	n.size += size
+}
+
+func (n *networkAreas) print(w io.Writer) {
+	fmt.Fprintf(w, "Network Areas: %d (%s)\n", len(n.areas), ByteSize(uint64(n.size)))
+	for _, a := range n.areas {
+		tls := "disabled"
+		if boolField(a, "UseTLS") {
+			tls = "enabled"
+		}
+		fmt.Fprintf(w, "  %s: peer datacenter %q, TLS %s\n", stringField(a, "ID"), stringField(a, "PeerDatacenter"), tls)
+		if join := stringList(field(a, "RetryJoin")); len(join) > 0 {
+			fmt.Fprintf(w, "    retry join: %s\n", strings.Join(join, ", "))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// tombstoneStats summarises KV tombstones. Tombstones are only needed until
+// they have been reaped by tombstone GC, after which the space they use in the
+// snapshot is reclaimed.
+type tombstoneStats struct {
+	top int
+
+	count, size, total int
+	minIndex, maxIndex uint64
+	// lastIndex is the highest raft index any record was modified at.
+	lastIndex uint64
+	prefixes  statMap
+}
+
+func newTombstoneStats(c *reportConfig) report {
+	return &tombstoneStats{top: c.Top, prefixes: make(statMap)}
+}
+
+func (t *tombstoneStats) add(msgType int, val interface{}, size int) {
+	t.total += size
+	if index := recordModifyIndex(msgType, val); index > t.lastIndex {
+		t.lastIndex = index
+	}
+
+	if typeName(msgType) != "Tombstone" {
+		return
+	}
+	// Tombstones are written as a DirEntry with just the Key and, as its
+	// ModifyIndex, the index it was deleted at.
+	index := uintField(val, "ModifyIndex")
+	t.count++
+	t.size += size
+	if t.minIndex == 0 || index < t.minIndex {
+		t.minIndex = index
+	}
+	if index > t.maxIndex {
+		t.maxIndex = index
+	}
+	t.prefixes.add(kvPrefix(kvKey(val), 1), size)
+}
+
+func (t *tombstoneStats) print(w io.Writer) {
+	if t.count == 0 {
+		fmt.Fprintln(w, "Tombstones: none")
+		return
+	}
+	fmt.Fprintf(w, "Tombstones: %d (%s) covering indexes %d-%d\n", t.count, ByteSize(uint64(t.size)), t.minIndex, t.maxIndex)
+	fmt.Fprintf(w, "Reclaimable after tombstone GC: ~%s (%.1f%% of the snapshot)\n",
+		ByteSize(uint64(t.size)), 100*float64(t.size)/float64(t.total))
+	if t.lastIndex > t.maxIndex {
+		fmt.Fprintf(w, "The newest tombstone is %d indexes behind the latest write (%d).\n", t.lastIndex-t.maxIndex, t.lastIndex)
+	}
+	fmt.Fprintln(w, "Tombstones are reaped once they're older than tombstone_ttl; old tombstones")
+	fmt.Fprintln(w, "remaining suggest GC isn't keeping up, otherwise waiting for GC will shrink the snapshot.")
+
+	fmt.Fprintln(w)
+	printTopStats(w, "Tombstone Prefix", t.prefixes.slice(), t.size, t.top)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// testTombstone returns a tombstone as Consul persists it: a DirEntry with the
+// Key and the index it was deleted at as its ModifyIndex.
+func testTombstone(key string, index uint64) testRecord {
+	return testRecord{tombstoneType, &testDirEntry{Key: key, RaftIndex: RaftIndex{ModifyIndex: index}}}
+}
+
+func testKV(key, value string, create, modify uint64) testRecord {
+	return testRecord{snapshot.KVSType, &testDirEntry{Key: key, Value: []byte(value), RaftIndex: RaftIndex{CreateIndex: create, ModifyIndex: modify}}}
+}
+
+func TestTombstoneIndexes(t *testing.T) {
+	snap := testSnapshot(t, 60,
+		testKV("app/live", "v", 5, 50),
+		testTombstone("app/gone", 20),
+		testTombstone("app/gone2", 30),
+	)
+	ts := newTombstoneStats(&reportConfig{Top: 10}).(*tombstoneStats)
+	if _, err := readSnapshot(bytes.NewReader(snap), ts.add); err != nil {
+		t.Fatal(err)
+	}
+	if ts.count != 2 || ts.minIndex != 20 || ts.maxIndex != 30 {
+		t.Errorf("got %d tombstones covering %d-%d, want 2 covering 20-30", ts.count, ts.minIndex, ts.maxIndex)
+	}
+	if ts.lastIndex != 50 {
+		t.Errorf("got last index %d, want 50", ts.lastIndex)
+	}
+}
+
+func TestTombstoneIndexRanges(t *testing.T) {
+	snap := testSnapshot(t, 60,
+		testKV("app/live", "v", 5, 50),
+		testTombstone("app/gone", 20),
+		testTombstone("app/gone2", 55),
+	)
+	s := newIndexRangeStats(&reportConfig{Top: 10, KVDepth: 1}).(*indexRangeStats)
+	if _, err := readSnapshot(bytes.NewReader(snap), s.add); err != nil {
+		t.Fatal(err)
+	}
+	r := s.types["Tombstone"]
+	if r == nil || r.Unindexed != 0 || r.MinModify != 20 || r.MaxModify != 55 {
+		t.Fatalf("got tombstone range %+v, want ModifyIndex 20-55", r)
+	}
+	if s.lastIndex != 55 {
+		t.Errorf("got latest index %d, want 55", s.lastIndex)
+	}
+	var out strings.Builder
+	s.print(&out)
+	if !strings.Contains(out.String(), "20-55") {
+		t.Errorf("tombstone range missing from:\n%s", out.String())
+	}
+}
+
+func TestTombstoneChanges(t *testing.T) {
+	older := testSnapshot(t, 40, testKV("app/a", "v1", 5, 10), testTombstone("app/gone", 20))
+	newer := testSnapshot(t, 60, testKV("app/a", "v2", 5, 45), testTombstone("app/gone", 20), testTombstone("app/b", 58))
+
+	for _, tc := range []struct {
+		snap []byte
+		want uint64
+	}{{older, 20}, {newer, 58}} {
+		var last uint64
+		_, err := readSnapshot(bytes.NewReader(tc.snap), func(msgType int, val interface{}, size int) {
+			if typeName(msgType) == "Tombstone" {
+				if index := recordModifyIndex(msgType, val); index > last {
+					last = index
+				}
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last != tc.want {
+			t.Errorf("got newest tombstone index %d, want %d", last, tc.want)
+		}
+	}
+
+	o, err := summarize(bytes.NewReader(older), 1, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := summarize(bytes.NewReader(newer), 1, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := recordChanges(o.Records, n.Records)
+	if len(changes) != 1 || changes[0].ID != "KVS app/a" || changes[0].Change != "modified" || changes[0].ModifyIndex != 45 {
+		t.Errorf("got changes %+v, want app/a modified at 45", changes)
+	}
+	if got := n.Types["Tombstone"].Count; got != 2 {
+		t.Errorf("got %d tombstones in the newer breakdown, want 2", got)
+	}
+}
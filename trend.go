@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trendCommand implements `trend <dir>` which summarises every backup in a
+// directory and shows how the snapshot has grown over time.
+func trendCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	var kvExclude stringsFlag
+	kvDepth := fs.Int("kv-depth", 1, "number of path segments to group KV keys by in the prefix breakdown")
+	fs.Var(&kvExclude, "kv-exclude", "KV prefix to leave out of the prefix breakdown (may be repeated)")
+	top := fs.Int("top", 10, "number of record types and KV prefixes to show growth for")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool trend [options] <backup directory>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		backups, err := findBackups(fs.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		if len(backups) < 2 {
+			fmt.Fprintf(os.Stderr, "Need at least two backups in %s to show a trend\n", fs.Arg(0))
+			exit(exitError)
+		}
+
+		for _, b := range backups {
+			if b.Summary, err = summarizeFile(b.Path, *kvDepth, kvExclude, false); err != nil {
+				fatal(err)
+			}
+		}
+		printTrend(os.Stdout, backups, *top)
+	}
+}
+
+// backup is a snapshot taken at a point in time.
+type backup struct {
+	Path    string
+	Time    time.Time
+	Summary *snapshotSummary
+}
+
+// backupTimestamp matches the unix timestamp snapshot agent puts in the names
+// of the backups it saves, e.g. consul-1573672800000000000.snap.
+var backupTimestamp = regexp.MustCompile(`(\d{10,19})`)
+
+// findBackups returns the snapshots in dir ordered by when they were taken,
+// using the timestamp in their name if there is one or their modification
+// time otherwise.
+func findBackups(dir string) ([]*backup, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []*backup
+	for _, info := range infos {
+		ext := filepath.Ext(info.Name())
+		if info.IsDir() || (ext != ".snap" && ext != ".bin") {
+			continue
+		}
+		backups = append(backups, &backup{Path: filepath.Join(dir, info.Name()), Time: backupTime(info)})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.Before(backups[j].Time) })
+	return backups, nil
+}
+
+// backupTime returns when the backup was taken, from the timestamp in its name
+// if there is one or its modification time otherwise.
+func backupTime(info os.FileInfo) time.Time {
+	m := backupTimestamp.FindString(info.Name())
+	if m == "" {
+		return info.ModTime()
+	}
+	n, _ := strconv.ParseInt(m, 10, 64)
+	// Work out the unit from the number of digits
+	for i := len(m); i > 10; i -= 3 {
+		n /= 1000
+	}
+	return time.Unix(n, 0)
+}
+
+// perDay returns the change from a to b as a rate per day over d.
+func perDay(a, b int, d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(float64(b-a) / d.Hours() * 24)
+}
+
+func printTrend(w io.Writer, backups []*backup, top int) {
+	fmt.Fprintf(w, "%-20s % 12s % 12s % 12s\n", "Backup", "Total Size", "Change", "Per Day")
+	fmt.Fprintf(w, "%s %s %s %s\n", strings.Repeat("-", 20), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 12))
+	var prev *backup
+	for _, b := range backups {
+		_, size := sumStats(b.Summary.Types)
+		change, rate := "", ""
+		if prev != nil {
+			_, prevSize := sumStats(prev.Summary.Types)
+			change = signedByteSize(size - prevSize)
+			rate = signedByteSize(perDay(prevSize, size, b.Time.Sub(prev.Time)))
+		}
+		fmt.Fprintf(w, "%-20s % 12s % 12s % 12s\n", b.Time.UTC().Format("2006-01-02 15:04:05"), ByteSize(uint64(size)), change, rate)
+		prev = b
+	}
+
+	first, last := backups[0], backups[len(backups)-1]
+	span := last.Time.Sub(first.Time)
+	fmt.Fprintln(w)
+	printGrowth(w, "Record Type", first.Summary.Types, last.Summary.Types, span, top)
+	fmt.Fprintln(w)
+	printGrowth(w, "KV Prefix", first.Summary.KV.prefixes, last.Summary.KV.prefixes, span, top)
+}
+
+// printGrowth lists the top largest rows in last along with how they have
+// grown since first.
+func printGrowth(w io.Writer, heading string, first, last statMap, span time.Duration, top int) {
+	ss := last.slice()
+	for name := range first {
+		if _, ok := last[name]; !ok {
+			ss = append(ss, typeStats{Name: name})
+		}
+	}
+	sort.Sort(ss)
+	if top > 0 && len(ss) > top {
+		ss = ss[:top]
+	}
+
+	fmt.Fprintf(w, "% 22s % 12s % 12s % 12s % 12s\n", heading, "First", "Last", "Change", "Per Day")
+	fmt.Fprintf(w, "%s %s %s %s %s\n", strings.Repeat("-", 22), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 12), strings.Repeat("-", 12))
+	for _, s := range ss {
+		a, b := first[s.Name].Sum, last[s.Name].Sum
+		fmt.Fprintf(w, "% 22s % 12s % 12s % 12s % 12s\n", s.Name, ByteSize(uint64(a)), ByteSize(uint64(b)),
+			signedByteSize(b-a), signedByteSize(perDay(a, b, span)))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// vaultStats breaks down the KV entries written by Vault's Consul storage
+// backend according to Vault's own layout so it's possible to see which
+// mounts, leases or core state are responsible.
+type vaultStats struct {
+	path string
+
+	// mounts maps mount UUIDs to the path they are mounted at.
+	mounts map[string]string
+
+	groups statMap
+	total  int
+
+	// sample and samples are as for kvStats.
+	sample  int
+	samples map[string]int
+}
+
+func newVaultStats(path string) *vaultStats {
+	return &vaultStats{
+		path:   path,
+		mounts: make(map[string]string),
+		groups: make(statMap),
+	}
+}
+
+// loadMounts reads mount names from a file containing the JSON output of
+// `vault secrets list -format=json` or `vault auth list -format=json`.
+func (v *vaultStats) loadMounts(file string) error {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var mounts map[string]struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal(bs, &mounts); err != nil {
+		return fmt.Errorf("failed to parse Vault mounts in %s: %s", file, err)
+	}
+	for path, m := range mounts {
+		if m.UUID != "" {
+			v.mounts[m.UUID] = path
+		}
+	}
+	return nil
+}
+
+// setSample sets the breakdown to be estimated from 1 in n entries.
+func (v *vaultStats) setSample(n int) {
+	v.sample = n
+	v.samples = make(map[string]int)
+}
+
+// add records a KVS entry of the given encoded size if it belongs to Vault.
+func (v *vaultStats) add(key string, size int) {
+	if !strings.HasPrefix(key, v.path) {
+		return
+	}
+	group := v.group(strings.TrimPrefix(key, v.path))
+	if v.sample > 1 {
+		s := v.groups[group]
+		s.Name = group
+		s.Sum += size * v.sample
+		s.Count += v.sample
+		v.groups[group] = s
+		v.samples[group]++
+		v.total += size * v.sample
+		return
+	}
+	v.groups.add(group, size)
+	v.total += size
+}
+
+// group returns the name to aggregate a Vault storage path under. Secret
+// engine and auth method data is grouped by mount, system paths by their
+// second segment (e.g. sys/expire for leases) and everything else by the top
+// level segment.
+func (v *vaultStats) group(path string) string {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 2 {
+		return path
+	}
+	switch parts[0] {
+	case "logical", "auth":
+		if name, ok := v.mounts[parts[1]]; ok {
+			return fmt.Sprintf("%s/%s (%s)", parts[0], parts[1], name)
+		}
+		return parts[0] + "/" + parts[1]
+	case "sys":
+		return parts[0] + "/" + parts[1]
+	}
+	return parts[0] + "/"
+}
+
+func (v *vaultStats) print(w io.Writer) {
+	if len(v.groups) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	if v.sample > 1 {
+		printSampledStats(w, "Vault Path", v.groups.slice(), v.samples, v.sample, v.total)
+		return
+	}
+	printStats(w, "Vault Path", v.groups.slice(), v.total)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// vaultKeyPeekBytes is how much of a storage entry larger than
+// -max-record-bytes is read to find its key, which comes before its value.
+const vaultKeyPeekBytes = 64 << 10
+
+// errVaultStats is the error for commands other than stats given a Vault
+// snapshot, which they can't read since it isn't made of msgpack records.
+var errVaultStats = errors.New("only stats can read Vault snapshots")
+
+// scanVaultSnapshot reads the raw state of a Vault integrated storage (raft)
+// snapshot from r, calling fn with the key and encoded size of each storage
+// entry, and returns the total size read. Vault writes every key and value of
+// its BoltDB store as a StorageEntry protobuf prefixed with its length as a
+// uvarint. Values are encrypted by Vault's barrier so only keys and sizes mean
+// anything.
+func scanVaultSnapshot(r io.Reader, fn func(key string, size int)) (int, error) {
+	br := bufio.NewReader(r)
+	var buf []byte
+	offset := 0
+	for record := 1; ; record++ {
+		fail := func(ioErr bool, err error) (int, error) {
+			return offset, &snapshotError{Offset: offset, Record: record, Type: "StorageEntry", IO: ioErr, Err: err}
+		}
+		length, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return fail(!isTruncated(err), err)
+		}
+		size := uvarintLen(length) + int(length)
+
+		n := int(length)
+		if n > int(maxRecordBytes) {
+			if strictTypes {
+				return fail(false, errRecordTooLarge)
+			}
+			if n > vaultKeyPeekBytes {
+				n = vaultKeyPeekBytes
+			}
+		}
+		if cap(buf) < n {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return fail(!isTruncated(err), truncated(err))
+		}
+		if rest := int64(length) - int64(n); rest > 0 {
+			if _, err := io.CopyN(ioutil.Discard, br, rest); err != nil {
+				return fail(!isTruncated(err), truncated(err))
+			}
+		}
+
+		key, err := storageEntryKey(buf)
+		if err != nil && n == int(length) {
+			return fail(false, err)
+		}
+		fn(key, size)
+		offset += size
+	}
+}
+
+// storageEntryKey returns the key, field 1, of an encoded StorageEntry.
+func storageEntryKey(b []byte) (string, error) {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return "", errors.New("invalid field tag")
+		}
+		b = b[n:]
+		var skip uint64
+		switch tag & 7 {
+		case 0:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return "", errors.New("invalid varint")
+			}
+			skip = uint64(n)
+		case 1:
+			skip = 8
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return "", errors.New("invalid length")
+			}
+			if tag>>3 == 1 {
+				return string(b[n : n+int(length)]), nil
+			}
+			skip = uint64(n) + length
+		case 5:
+			skip = 4
+		default:
+			return "", fmt.Errorf("unsupported wire type %d", tag&7)
+		}
+		if skip > uint64(len(b)) {
+			return "", io.ErrUnexpectedEOF
+		}
+		b = b[skip:]
+	}
+	return "", errors.New("storage entry has no key")
+}
+
+// uvarintLen returns the number of bytes v takes as a uvarint.
+func uvarintLen(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// isTruncated returns true if err means the snapshot ended part way through a
+// record.
+func isTruncated(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// truncated returns io.ErrUnexpectedEOF for an io.EOF part way through a
+// record.
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// recordShapes lists fields every record of a type is expected to have, and
+// what kind of value each holds: string, uint, list or map. Lists and maps
+// may also be nil. Only fields Consul has always written are listed so
+// snapshots from older versions verify too.
+var recordShapes = map[string]map[string]string{
+	"Register":                     {"Node": "string"},
+	"KVS":                          {"Key": "string", "CreateIndex": "uint", "ModifyIndex": "uint"},
+	"Session":                      {"ID": "string", "Node": "string"},
+	"ACL (Deprecated)":             {"ID": "string"},
+	"Tombstone":                    {"Key": "string"},
+	"PreparedQuery":                {"ID": "string"},
+	"Autopilot":                    {},
+	"Area":                         {"ID": "string"},
+	"Intention":                    {"ID": "string", "SourceName": "string", "DestinationName": "string"},
+	"ConnectCA":                    {"ID": "string", "RootCert": "string"},
+	"ConnectCAProviderState":       {"ID": "string"},
+	"ConnectCAConfig":              {"Provider": "string", "Config": "map"},
+	"Index":                        {"Key": "string", "Value": "uint"},
+	"ACLTokenSet":                  {"AccessorID": "string", "SecretID": "string", "Policies": "list"},
+	"ACLPolicySet":                 {"ID": "string", "Name": "string", "Rules": "string"},
+	"ACLRoleSetRequestType":        {"ID": "string", "Name": "string"},
+	"ACLAuthMethodSetRequestType":  {"Name": "string", "Type": "string"},
+	"ACLBindingRuleSetRequestType": {"ID": "string", "AuthMethod": "string"},
+	"FederationStateRequestType":   {"State": "map"},
+	"SystemMetadataRequestType":    {"Key": "string"},
+}
+
+// kindOf returns the kind of a decoded value as used in recordShapes.
+func kindOf(v interface{}) string {
+	switch n := v.(type) {
+	case string:
+		return "string"
+	case uint64:
+		return "uint"
+	case int64:
+		if n >= 0 {
+			return "uint"
+		}
+		return "int"
+	case []interface{}:
+		return "list"
+	case map[interface{}]interface{}:
+		return "map"
+	case nil:
+		return "nil"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// verifyRecord checks a decoded record has the shape expected for its type,
+// returning a description of the first problem found or "".
+func verifyRecord(msgType int, val interface{}) string {
+	name := typeName(msgType)
+	switch name {
+	case "CoordinateBatchUpdate":
+		if k := kindOf(val); k != "list" {
+			return fmt.Sprintf("expected a list of coordinates, got %s", k)
+		}
+		return ""
+	case "ConfigEntryRequestType":
+		entry, err := decodeConfigEntry(val)
+		if err != nil {
+			return fmt.Sprintf("couldn't decode config entry: %s", err)
+		}
+		if stringField(entry, "Kind") == "" {
+			return "config entry has no Kind"
+		}
+		return ""
+	}
+
+	fields, ok := recordShapes[name]
+	if !ok {
+		return ""
+	}
+	if k := kindOf(val); k != "map" {
+		return fmt.Sprintf("expected a map, got %s", k)
+	}
+	names := make([]string, 0, len(fields))
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	for _, f := range names {
+		want, got := fields[f], kindOf(field(val, f))
+		if got == want || got == "nil" && (want == "list" || want == "map") {
+			continue
+		}
+		if got == "nil" {
+			return fmt.Sprintf("%s is missing", f)
+		}
+		return fmt.Sprintf("%s should be a %s, got %s", f, want, got)
+	}
+	return ""
+}
+
+// verifySnapshot fully decodes the snapshot read from r and checks the shape of
+// every record. It returns the number of records and bytes read along with the
+// raft index anomalies found, or an error describing the first corrupt record.
+func verifySnapshot(r io.Reader) (records, size int, idx *indexChecks, err error) {
+	s, err := newSnapshotScanner(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	idx = newIndexChecks(s.header.LastIndex)
+	for {
+		msgType, val, err := s.next()
+		if err == io.EOF {
+			return s.records, s.offset, idx, nil
+		} else if err != nil {
+			return s.records, s.offset, idx, err
+		}
+		idx.add(s.records, msgType, val)
+		if problem := verifyRecord(msgType, val); problem != "" {
+			return s.records, s.offset, idx, &snapshotError{
+				Offset: s.offset - s.size,
+				Record: s.records,
+				Type:   typeName(msgType),
+				Err:    errors.New(problem),
+			}
+		}
+	}
+}
+
+// verifyCommand implements `verify <snapshot>`, a pre-flight check that reads
+// every record before attempting a restore.
+func verifyCommand(fs *flag.FlagSet) func() {
+	inputFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool verify <snapshot>")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		r, err := openSnapshot(fs.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		defer r.Close()
+
+		records, size, idx, err := verifySnapshot(r)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", fs.Arg(0), err))
+		}
+		if n := idx.count(); n > 0 {
+			if strictTypes {
+				fmt.Printf("%s: FAILED, %d raft index anomalies in %d records (%s)\n", fs.Arg(0), n, records, ByteSize(uint64(size)))
+				idx.print(os.Stdout)
+				exit(exitCorrupt)
+			}
+			fmt.Printf("%s: OK with %d raft index anomalies, %d records (%s)\n", fs.Arg(0), n, records, ByteSize(uint64(size)))
+			idx.print(os.Stdout)
+			return
+		}
+		fmt.Printf("%s: OK, %d records (%s)\n", fs.Arg(0), records, ByteSize(uint64(size)))
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+func TestVerifyConfigEntry(t *testing.T) {
+	records, _, _, err := verifySnapshot(bytes.NewReader(testConfigEntrySnapshot(t, testServiceDefaults)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records != 1 {
+		t.Errorf("got %d records, want 1", records)
+	}
+}
+
+func TestVerifyRecordBadConfigEntry(t *testing.T) {
+	// A Kind with nothing after it, as if the request was cut short.
+	problem := verifyRecord(snapshot.ConfigEntryType, []byte("\xb0service-defaults"))
+	if !strings.HasPrefix(problem, "couldn't decode config entry: ") {
+		t.Errorf("got %q", problem)
+	}
+}
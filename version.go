@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// version is the tool's version, set when building releases with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// versionInfo is what `version -format json` writes.
+type versionInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	// NewestType is the highest message type the tool can name, and
+	// NewestConsul the Consul release that added it.
+	NewestType     int    `json:"newest_type"`
+	NewestTypeName string `json:"newest_type_name"`
+	NewestConsul   string `json:"newest_consul"`
+}
+
+func newVersionInfo() versionInfo {
+	newest := consulVersions[len(consulVersions)-1]
+	info := versionInfo{
+		Version:        version,
+		GoVersion:      runtime.Version(),
+		NewestType:     len(snapshot.TypeNames) - 1,
+		NewestTypeName: snapshot.TypeNames[len(snapshot.TypeNames)-1],
+		NewestConsul:   fmt.Sprintf("%d.%d", newest.major, newest.minor),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Revision = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// versionCommand implements `version`, which prints the tool's build along
+// with the newest message type it knows, so it's easy to tell whether it's too
+// old for a cluster's snapshots.
+func versionCommand(fs *flag.FlagSet) func() {
+	format := formatFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: consul-snapshot-tool version [options]")
+		fs.PrintDefaults()
+	}
+	return func() {
+		if fs.NArg() != 0 || !validFormat(*format) {
+			fs.Usage()
+			exit(exitError)
+		}
+
+		info := newVersionInfo()
+		if *format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(info); err != nil {
+				fatal(err)
+			}
+			return
+		}
+
+		build := info.Version
+		if info.Revision != "" {
+			rev := info.Revision
+			if len(rev) > 12 {
+				rev = rev[:12]
+			}
+			if info.Modified {
+				rev += ", modified"
+			}
+			build += " (" + rev + ")"
+		}
+		fmt.Printf("consul-snapshot-tool %s built with %s\n", build, info.GoVersion)
+		fmt.Printf("Knows message types 0-%d, up to %s added in Consul %s.\n", info.NewestType, info.NewestTypeName, info.NewestConsul)
+		fmt.Println("Snapshots from newer versions of Consul may have types it lists as Unknown(N).")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/banks/consul-snapshot-tool/snapshot"
+)
+
+// consulVersions lists the number of message types each Consul release knows
+// about, oldest first. Consul only ever appends new types so a release's type
+// table is a prefix of typeNames.
+var consulVersions = []struct {
+	major, minor int
+	types        int
+}{
+	{0, 8, 11},  // Autopilot and Area
+	{0, 9, 12},  // ACLBootstrap
+	{1, 2, 17},  // Connect intentions, CA and Index
+	{1, 4, 22},  // ACL tokens and policies, ConnectCALeafRequestType
+	{1, 5, 29},  // Config entries, ACL roles, binding rules and auth methods
+	{1, 6, 30},  // ChunkingStateType
+	{1, 8, 31},  // FederationStateRequestType
+	{1, 9, 32},  // SystemMetadataRequestType
+	{1, 11, 35}, // Virtual IPs and KindServiceNamesType
+	{1, 13, 40}, // Cluster peering
+	{1, 14, 41}, // PeeringSecretsWriteType
+	{1, 15, 42}, // RaftLogVerifierCheckpoint
+	{1, 16, 44}, // ResourceOperationType and UpdateVirtualIPRequestType
+}
+
+// parseConsulVersion parses a Consul version like 1.17 or v1.17.2, ignoring
+// the patch version and any suffix.
+func parseConsulVersion(s string) (major, minor int, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("invalid Consul version %q, expected e.g. 1.17", s)
+	}
+	if major, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("invalid Consul version %q, expected e.g. 1.17", s)
+	}
+	if minor, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, fmt.Errorf("invalid Consul version %q, expected e.g. 1.17", s)
+	}
+	return major, minor, nil
+}
+
+// setConsulVersion limits typeNames to the types known by the given Consul
+// version, so any newer type numbers found are reported as unknown rather than
+// named after types the version doesn't have.
+func setConsulVersion(s string) error {
+	if product != "consul" {
+		return fmt.Errorf("-consul-version only applies to Consul snapshots")
+	}
+	major, minor, err := parseConsulVersion(s)
+	if err != nil {
+		return err
+	}
+	first := consulVersions[0]
+	if major < first.major || major == first.major && minor < first.minor {
+		return fmt.Errorf("Consul %s is older than the oldest supported version %d.%d", s, first.major, first.minor)
+	}
+	types := first.types
+	for _, v := range consulVersions {
+		if v.major > major || v.major == major && v.minor > minor {
+			break
+		}
+		types = v.types
+	}
+	typeNames = append([]string(nil), snapshot.TypeNames[:types]...)
+	return nil
+}
+
+// consulVersionValue is a flag.Value for -consul-version. Setting it only
+// checks the version parses; applyInputFlags limits the type table to it.
+type consulVersionValue string
+
+func (v *consulVersionValue) String() string { return string(*v) }
+
+func (v *consulVersionValue) Set(s string) error {
+	if _, _, err := parseConsulVersion(s); err != nil {
+		return err
+	}
+	*v = consulVersionValue(s)
+	return nil
+}
+
+// typeOverrides holds the type names loaded with -type-map, which take
+// precedence over typeNames.
+var typeOverrides = map[int]string{}
+
+// loadTypeMap reads a JSON object mapping type numbers to names, e.g.
+// {"44": "MyNewType"}, adding to the built-in names. Reports find the records
+// they need by name so types that already have one can't be renamed, and a
+// new type can't take the name of another, or a report would misread it.
+func loadTypeMap(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	for k, name := range m {
+		n, err := strconv.Atoi(k)
+		if err != nil || n < 0 || n > 255 {
+			return fmt.Errorf("%s: invalid type number %q", path, k)
+		}
+		if name == "" {
+			return fmt.Errorf("%s: empty name for type %d", path, n)
+		}
+		if n < len(typeNames) && typeNames[n] != "" {
+			return fmt.Errorf("%s: type %d is already named %s, only types the tool doesn't know can be named", path, n, typeNames[n])
+		}
+		for i, known := range typeNames {
+			if strings.EqualFold(known, name) {
+				return fmt.Errorf("%s: can't name type %d %s, which is type %d", path, n, name, i)
+			}
+		}
+		typeOverrides[n] = name
+	}
+	return nil
+}
+
+// strictTypes makes records of unknown types an error rather than counting
+// them as Unknown(<N>).
+var strictTypes bool
+
+// knownType returns true if msgType has a name.
+func knownType(msgType int) bool {
+	_, ok := typeOverrides[msgType]
+	return ok || msgType >= 0 && msgType < len(typeNames) && typeNames[msgType] != ""
+}
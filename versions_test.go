@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTypeMap(t *testing.T) {
+	defer func() { typeOverrides = map[int]string{} }()
+	for m, wantErr := range map[string]string{
+		`{"2": "Renamed"}`:                     "type 2 is already named KVS",
+		`{"200": "kvs"}`:                       "which is type 2",
+		`{"200": "MyNewType", "64": "NSType"}`: "",
+	} {
+		path := filepath.Join(t.TempDir(), "types.json")
+		if err := ioutil.WriteFile(path, []byte(m), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := loadTypeMap(path)
+		switch {
+		case wantErr == "" && err != nil:
+			t.Errorf("%s: %s", m, err)
+		case wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)):
+			t.Errorf("%s: got error %v, want %q", m, err, wantErr)
+		}
+	}
+	if typeName(200) != "MyNewType" || typeName(64) != "NSType" || typeName(2) != "KVS" {
+		t.Errorf("got names %s, %s, %s", typeName(200), typeName(64), typeName(2))
+	}
+}
+
+func TestConsulVersionSetTwice(t *testing.T) {
+	defer setProduct("consul")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	inputFlags(fs)
+	// As if set by the config file then the command line.
+	for _, v := range []string{"1.2", "1.16"} {
+		if err := fs.Set("consul-version", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := applyInputFlags(fs); err != nil {
+		t.Fatal(err)
+	}
+	if typeName(43) != "UpdateVirtualIPRequestType" {
+		t.Errorf("got %s for type 43, want the 1.16 table", typeName(43))
+	}
+}
+
+func TestEnterpriseTypeNames(t *testing.T) {
+	defer func() { typeOverrides = map[int]string{} }()
+	if got := typeName(68); got != "Unknown(68)" {
+		t.Errorf("got %s for type 68, want it unnamed", got)
+	}
+	var out strings.Builder
+	newEnterpriseStats(&reportConfig{}).print(&out)
+	if !strings.HasPrefix(out.String(), "Name the Enterprise types") || strings.Contains(out.String(), "Licenses:") {
+		t.Errorf("got:\n%s\nwant only a note to name the types", out.String())
+	}
+
+	typeOverrides[70] = "LicenseRequestType"
+	e := newEnterpriseStats(&reportConfig{})
+	e.add(70, map[string]interface{}{}, 10)
+	out.Reset()
+	e.print(&out)
+	if !strings.Contains(out.String(), "Licenses: 1 (10B)") {
+		t.Errorf("got:\n%s\nwant the license named with -type-map counted", out.String())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+)
+
+// virtualIPStats reports the virtual IPs allocated to services for
+// transparent proxy.
+type virtualIPStats struct {
+	top int
+
+	// services maps service names to their allocated IPs.
+	services map[string][]string
+	free     int
+	counter  string
+	size     int
+}
+
+func newVirtualIPStats(c *reportConfig) report {
+	return &virtualIPStats{top: c.Top, services: make(map[string][]string)}
+}
+
+// ipField returns the IP at path formatted as a string. IPs are encoded as
+// their raw bytes.
+func ipField(v interface{}, path ...string) string {
+	switch ip := field(v, path...).(type) {
+	case string:
+		return net.IP(ip).String()
+	case []byte:
+		return net.IP(ip).String()
+	}
+	return ""
+}
+
+func (v *virtualIPStats) add(msgType int, val interface{}, size int) {
+	switch typeName(msgType) {
+	case "ServiceVirtualIPRequestType":
+		svc := field(val, "Service")
+		name := tenantName(stringField(svc, "Partition"), stringField(svc, "Namespace")) + "/" + stringField(svc, "Name")
+		v.services[name] = append(v.services[name], ipField(val, "IP"))
+	case "FreeVirtualIPRequestType":
+		// The counter records the next IP to allocate, the rest have been
+		// freed and can be reused.
+		if boolField(val, "IsCounter") {
+			v.counter = ipField(val, "IP")
+		} else {
+			v.free++
+		}
+	default:
+		return
+	}
+	v.size += size
+}
+
+func (v *virtualIPStats) print(w io.Writer) {
+	allocated := 0
+	names := make([]string, 0, len(v.services))
+	for name, ips := range v.services {
+		allocated += len(ips)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "Virtual IPs: %d allocated to %d services, %d freed (%s)\n", allocated, len(names), v.free, ByteSize(uint64(v.size)))
+	if v.counter != "" {
+		fmt.Fprintf(w, "Next IP counter: %s\n", v.counter)
+	}
+	for i, name := range names {
+		if v.top > 0 && i >= v.top {
+			fmt.Fprintf(w, "  ... and %d more services\n", len(names)-i)
+			break
+		}
+		fmt.Fprintf(w, "  %s: %v\n", name, v.services[name])
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// watchCommand implements `watch` which periodically fetches a snapshot from a
+// Consul agent, appends its breakdown to a trend store and prints what changed
+// since the last run.
+func watchCommand(fs *flag.FlagSet) func() {
+	api := consulAPIFlags(fs)
+	stale := fs.Bool("stale", false, "allow any server to serve the snapshot rather than only the leader")
+	interval := fs.Duration("interval", time.Hour, "how often to fetch a snapshot")
+	timeout := fs.Duration("timeout", 10*time.Minute, "longest to spend fetching and reading each snapshot, 0 for no limit")
+	store := fs.String("store", "consul-snapshot-trend.jsonl", "file each breakdown is appended to")
+	var kvExclude stringsFlag
+	kvDepth := fs.Int("kv-depth", 1, "number of path segments to group KV keys by in the prefix breakdown")
+	fs.Var(&kvExclude, "kv-exclude", "KV prefix to leave out of the prefix breakdown (may be repeated)")
+	statsd := statsdFlags(fs)
+	return func() {
+
+		prev, err := lastTrendPoint(*store)
+		if err != nil {
+			fatal(err)
+		}
+
+		for {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if *timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, *timeout)
+			}
+			s, err := fetchSnapshot(ctx, api, *stale, *kvDepth, kvExclude)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: failed to fetch snapshot: %s\n", time.Now().Format(time.RFC3339), err)
+			} else {
+				point := &trendPoint{Time: time.Now(), Types: s.Types, KV: s.KV.prefixes}
+				if err := appendTrendPoint(*store, point); err != nil {
+					fatal(err)
+				}
+				printWatch(os.Stdout, prev, point)
+				count, size := sumStats(point.Types)
+				statsd.send(count, size, point.Types.slice(), point.KV.slice())
+				prev = point
+			}
+			time.Sleep(*interval)
+		}
+	}
+}
+
+// fetchSnapshot downloads a snapshot from the Consul agent and returns its
+// breakdown, giving up once ctx is done. The token needs operator:read.
+func fetchSnapshot(ctx context.Context, api *consulAPI, stale bool, kvDepth int, kvExclude []string) (*snapshotSummary, error) {
+	path := "/v1/snapshot"
+	if stale {
+		path += "?stale"
+	}
+	resp, err := api.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r, err := snapshotReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return summarize(r, kvDepth, kvExclude, false)
+}
+
+// trendPoint is the breakdown of a snapshot at a point in time as stored in
+// the trend store, one JSON object per line.
+type trendPoint struct {
+	Time  time.Time
+	Types statMap
+	KV    statMap
+}
+
+// lastTrendPoint returns the most recent point in the trend store or nil if
+// the store doesn't exist yet.
+func lastTrendPoint(path string) (*trendPoint, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last *trendPoint
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*MEGABYTE)
+	for scanner.Scan() {
+		var p trendPoint
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		last = &p
+	}
+	return last, scanner.Err()
+}
+
+func appendTrendPoint(path string, p *trendPoint) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(p); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printWatch(w io.Writer, prev, cur *trendPoint) {
+	_, size := sumStats(cur.Types)
+	fmt.Fprintf(w, "%s: snapshot is %s\n", cur.Time.Format(time.RFC3339), ByteSize(uint64(size)))
+	if prev == nil {
+		printStats(w, "Record Type", cur.Types.slice(), size)
+		fmt.Fprintln(w)
+		return
+	}
+	_, prevSize := sumStats(prev.Types)
+	fmt.Fprintf(w, "Changed %s since %s (%s per day)\n\n", signedByteSize(size-prevSize),
+		prev.Time.Format(time.RFC3339), signedByteSize(perDay(prevSize, size, cur.Time.Sub(prev.Time))))
+	printDiff(w, "Record Type", prev.Types, cur.Types)
+	fmt.Fprintln(w)
+	printDiff(w, "KV Prefix", prev.KV, cur.KV)
+	fmt.Fprintln(w)
+}